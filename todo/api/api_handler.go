@@ -0,0 +1,546 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The api package creates and maintains a reference to the data handler
+// this is a good design practice
+//
+// The handlers run concurrently, one goroutine per request.  db.ToDo
+// serializes them, and other processes such as the CLI, with its own
+// locks, so the counters are the only state kept here and they are
+// updated atomically.
+type ToDoAPI struct {
+	db           *db.ToDo
+	bootTime     time.Time
+	transactions atomic.Uint64
+	errors       atomic.Uint64
+}
+
+// New returns a ToDoAPI serving the items in todo
+func New(todo *db.ToDo) *ToDoAPI {
+	return &ToDoAPI{db: todo, bootTime: time.Now()}
+}
+
+// AddRoutes registers the API on app
+func (ta *ToDoAPI) AddRoutes(app *fiber.App) {
+	//HTTP Standards for "REST" APIS
+	//GET - Read/Query
+	//POST - Create
+	//PUT - Update
+	//DELETE - Delete
+
+	app.Get("/todo", ta.GetAllItems)
+	app.Post("/todo", ta.AddItem)
+
+	app.Get("/todo/health", ta.HealthCheck)
+	app.Get("/todo/next", ta.GetNextItems)
+	app.Get("/todo/lists", ta.GetLists)
+
+	//The whole database at once, for the -db http:// store of other
+	//todo CLIs
+	app.Get(db.StorePath, ta.GetStore)
+	app.Put(db.StorePath, ta.PutStore)
+
+	app.Get("/todo/:id<int>", ta.GetItem)
+	app.Put("/todo/:id<int>", ta.UpdateItem)
+	app.Delete("/todo/:id<int>", ta.DeleteItem)
+
+	//The done flag is its own resource, so it can be set and cleared
+	//without sending the whole item
+	app.Put("/todo/:id<int>/done", ta.SetDone(true))
+	app.Delete("/todo/:id<int>/done", ta.SetDone(false))
+}
+
+// ErrorResult is the body of every error response
+type ErrorResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// ErrorHandler is the fiber ErrorHandler for the API.  It sends errors
+// as a JSON ErrorResult instead of fiber's plain text.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	code := http.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		code = fiberErr.Code
+	}
+
+	return c.Status(code).JSON(ErrorResult{Status: code, Error: err.Error()})
+}
+
+// implementation for GET /todo
+// returns all todos.  The query parameters done, title, match, list,
+// sort, limit and offset filter, sort and page them just like todo list
+// does.
+func (ta *ToDoAPI) GetAllItems(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	q, err := queryFromParams(c)
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	todoList, err := ta.db.QueryItemsContext(c.UserContext(), q)
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error Getting All Items: ", err)
+		return fiber.NewError(http.StatusInternalServerError,
+			"Error Getting All Items")
+	}
+	//Note that the database returns a nil slice if there are no items
+	//in the database.  We need to convert this to an empty slice
+	//so that the JSON marshalling works correctly.  We want to return
+	//an empty slice, not a nil slice. This will result in the json being []
+	if todoList == nil {
+		todoList = make([]db.ToDoItem, 0)
+	}
+
+	return c.JSON(todoList)
+}
+
+// implementation for GET /todo/next
+// returns the open items that aren't blocked, in the order to do them,
+// as todo next does.  With all=true the blocked items are included,
+// after the items blocking them, and list picks the lists to show.
+func (ta *ToDoAPI) GetNextItems(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	lists, err := db.ParseLists(c.Query("list"))
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	var todoList []db.ToDoItem
+	if c.QueryBool("all") {
+		todoList, err = ta.db.GetAllItemsContext(c.UserContext())
+		todoList = db.DependencyOrder(todoList)
+	} else {
+		todoList, err = ta.db.NextItemsContext(c.UserContext())
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error Getting Next Items: ", err)
+		return fiber.NewError(http.StatusInternalServerError,
+			"Error Getting Next Items")
+	}
+	if len(lists) > 0 {
+		q := db.Query{Lists: lists}
+		todoList = slices.DeleteFunc(todoList, func(item db.ToDoItem) bool { return !q.Matches(item) })
+	}
+	if todoList == nil {
+		todoList = make([]db.ToDoItem, 0)
+	}
+
+	return c.JSON(todoList)
+}
+
+// ListResult is one list in the body of GET /todo/lists
+type ListResult struct {
+	Name  string `json:"name"`
+	Open  int    `json:"open"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// implementation for GET /todo/lists
+// returns every list that has items, with its counts, as todo lists
+// does
+func (ta *ToDoAPI) GetLists(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	lists, err := ta.db.ListsContext(c.UserContext())
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error Getting Lists: ", err)
+		return fiber.NewError(http.StatusInternalServerError,
+			"Error Getting Lists")
+	}
+
+	results := make([]ListResult, 0, len(lists))
+	for _, list := range lists {
+		results = append(results, ListResult{Name: list.Name, Open: list.Open, Done: list.Done,
+			Total: list.Open + list.Done})
+	}
+	return c.JSON(results)
+}
+
+// implementation for GET /todo/:id
+// returns a single todo
+func (ta *ToDoAPI) GetItem(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, "id must be an integer")
+	}
+
+	item, err := ta.db.GetItemContext(c.UserContext(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusNotFound, "item not found")
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error getting item: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error getting item")
+	}
+
+	return c.JSON(item)
+}
+
+// implementation for POST /todo
+// adds a new todo.  An id of 0, or none, gets the next free id.  The
+// item as stored is returned.
+func (ta *ToDoAPI) AddItem(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	var item db.ToDoItem
+	if err := c.BodyParser(&item); err != nil {
+		ta.errors.Add(1)
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest, "body must be a JSON todo item")
+	}
+	if item.Title == "" {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, "the item needs a title")
+	}
+	rule, err := db.ParseRecurrence(string(item.Repeat))
+	if err == nil {
+		item.List, err = db.ParseList(item.List)
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	item.Repeat = rule
+
+	id, err := ta.db.AddItemContext(c.UserContext(), item)
+	if errors.Is(err, db.ErrExists) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusConflict, "an item with that id already exists")
+	}
+	if errors.Is(err, db.ErrVetoed) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, db.ErrBadId) || errors.Is(err, db.ErrBadParent) || errors.Is(err, db.ErrBadBlocker) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error adding item: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error adding item")
+	}
+
+	item, err = ta.db.GetItemContext(c.UserContext(), id)
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error fetching added item: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error adding item")
+	}
+
+	return c.Status(http.StatusCreated).JSON(item)
+}
+
+// implementation for PUT /todo/:id
+// Web api standards use PUT for Updates.  The body replaces the whole
+// item; its id may be left out, but if given must match the URL.
+func (ta *ToDoAPI) UpdateItem(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, "id must be an integer")
+	}
+
+	var item db.ToDoItem
+	if err := c.BodyParser(&item); err != nil {
+		ta.errors.Add(1)
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest, "body must be a JSON todo item")
+	}
+	if item.Id == 0 {
+		item.Id = id
+	}
+	if item.Id != id {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, "id in the body does not match the URL")
+	}
+	if item.Title == "" {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, "the item needs a title")
+	}
+	rule, err := db.ParseRecurrence(string(item.Repeat))
+	if err == nil {
+		item.List, err = db.ParseList(item.List)
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	item.Repeat = rule
+
+	err = ta.db.UpdateItemContext(c.UserContext(), item)
+	if errors.Is(err, db.ErrNotFound) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusNotFound, "item not found")
+	}
+	if errors.Is(err, db.ErrVetoed) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, db.ErrBadId) || errors.Is(err, db.ErrBadParent) || errors.Is(err, db.ErrBadBlocker) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error updating item: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error updating item")
+	}
+
+	return ta.sendItem(c, id)
+}
+
+// implementation for DELETE /todo/:id
+// deletes a todo.  The mode query parameter says what happens to its
+// subtasks: refuse (the default, 409 if it has any), cascade or
+// reparent, see db.DeleteMode.
+func (ta *ToDoAPI) DeleteItem(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, "id must be an integer")
+	}
+	mode, err := db.ParseDeleteMode(c.Query("mode", string(db.DeleteRefuse)))
+	if err != nil {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	_, err = ta.db.DeleteItemModeContext(c.UserContext(), id, mode)
+	if errors.Is(err, db.ErrNotFound) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusNotFound, "item not found")
+	}
+	if errors.Is(err, db.ErrVetoed) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, db.ErrHasChildren) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error deleting item: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error deleting item")
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// SetDone returns the handler for PUT /todo/:id/done (value true) and
+// DELETE /todo/:id/done, which mark an item done and not done, along
+// with all of its subtasks if the children query parameter is true.
+// An item blocked by open items can't be marked done unless the force
+// query parameter is true.  The updated item is returned.
+func (ta *ToDoAPI) SetDone(value bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ta.transactions.Add(1)
+
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			ta.errors.Add(1)
+			return fiber.NewError(http.StatusBadRequest, "id must be an integer")
+		}
+
+		err = ta.db.ChangeDoneStatusContext(c.UserContext(), id, value, c.QueryBool("children"), c.QueryBool("force"))
+		if errors.Is(err, db.ErrNotFound) {
+			ta.errors.Add(1)
+			return fiber.NewError(http.StatusNotFound, "item not found")
+		}
+		if errors.Is(err, db.ErrVetoed) {
+			ta.errors.Add(1)
+			return fiber.NewError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, db.ErrBlocked) {
+			ta.errors.Add(1)
+			return fiber.NewError(http.StatusConflict, err.Error())
+		}
+		if err != nil {
+			ta.errors.Add(1)
+			log.Println("Error changing done status: ", err)
+			return fiber.NewError(http.StatusInternalServerError, "Error changing done status")
+		}
+
+		return ta.sendItem(c, id)
+	}
+}
+
+// implementation for GET /todo/store
+// returns the whole database in the todo.json file format, with its
+// version as the ETag.  This is what db.HttpStore loads.
+func (ta *ToDoAPI) GetStore(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	data, version, err := ta.db.DumpContext(c.UserContext())
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error dumping the database: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error reading the database")
+	}
+
+	c.Set(fiber.HeaderETag, `"`+version+`"`)
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(data)
+}
+
+// implementation for PUT /todo/store
+// replaces the whole database, which is what db.HttpStore saves.  The
+// If-Match header must carry the ETag from the GET the new database is
+// based on, so nobody's changes are overwritten unseen.  The X-Todo-Op
+// header says what the change was, for the journal.
+func (ta *ToDoAPI) PutStore(c *fiber.Ctx) error {
+	ta.transactions.Add(1)
+
+	version := strings.Trim(c.Get(fiber.HeaderIfMatch), `"`)
+	if version == "" {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusPreconditionRequired, "If-Match must give the version being replaced")
+	}
+
+	if !json.Valid(c.Body()) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, "body must be a JSON todo database")
+	}
+
+	newVersion, err := ta.db.ReplaceContext(c.UserContext(), c.Body(), version, c.Get(db.OpHeader))
+	if errors.Is(err, db.ErrBadId) || errors.Is(err, db.ErrExists) ||
+		errors.Is(err, db.ErrBadParent) || errors.Is(err, db.ErrBadBlocker) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, db.ErrBlocked) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, db.ErrVetoed) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, db.ErrConflict) {
+		ta.errors.Add(1)
+		return fiber.NewError(http.StatusPreconditionFailed, db.ErrConflict.Error())
+	}
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error replacing the database: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error replacing the database")
+	}
+
+	c.Set(fiber.HeaderETag, `"`+newVersion+`"`)
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// implementation of GET /todo/health. It is a good practice to build in a
+// health check for your API.
+
+type HealthCheckResult struct {
+	Status       string `json:"status"`
+	Version      string `json:"version"`
+	Uptime       uint   `json:"uptime_seconds"`
+	Transactions uint64 `json:"transaction_count"`
+	Errors       uint64 `json:"error_count"`
+}
+
+func (ta *ToDoAPI) HealthCheck(c *fiber.Ctx) error {
+	//A server that can't read its database isn't healthy
+	status, code := "ok", http.StatusOK
+	if _, err := ta.db.QueryItemsContext(c.UserContext(), db.Query{Limit: 1}); err != nil {
+		log.Println("Health check failed: ", err)
+		status, code = "error", http.StatusServiceUnavailable
+	}
+
+	return c.Status(code).
+		JSON(HealthCheckResult{
+			Status:       status,
+			Version:      "1.0.0",
+			Uptime:       uint(time.Since(ta.bootTime).Seconds()),
+			Transactions: ta.transactions.Load(),
+			Errors:       ta.errors.Load(),
+		})
+}
+
+// sendItem responds with the item as it is now stored
+func (ta *ToDoAPI) sendItem(c *fiber.Ctx, id int) error {
+	item, err := ta.db.GetItemContext(c.UserContext(), id)
+	if err != nil {
+		ta.errors.Add(1)
+		log.Println("Error fetching item: ", err)
+		return fiber.NewError(http.StatusInternalServerError, "Error fetching item")
+	}
+
+	return c.JSON(item)
+}
+
+// queryFromParams builds the db.Query for GET /todo from its query
+// parameters
+func queryFromParams(c *fiber.Ctx) (db.Query, error) {
+	var q db.Query
+
+	if done := c.Query("done"); done != "" {
+		value := c.QueryBool("done")
+		if done != "true" && done != "false" {
+			return q, errors.New("done must be true or false")
+		}
+		q.Done = &value
+	}
+	q.TitleContains = c.Query("title")
+	if match := c.Query("match"); match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return q, err
+		}
+		q.TitleRegexp = re
+	}
+	lists, err := db.ParseLists(c.Query("list"))
+	if err != nil {
+		return q, err
+	}
+	q.Lists = lists
+	sortKeys, err := db.ParseSort(c.Query("sort"))
+	if err != nil {
+		return q, err
+	}
+	q.Sort = sortKeys
+
+	q.Limit = c.QueryInt("limit")
+	q.Offset = c.QueryInt("offset")
+	if q.Limit < 0 || q.Offset < 0 {
+		return q, errors.New("limit and offset can't be negative")
+	}
+
+	return q, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"drexel.edu/todo/db"
+)
+
+// batchOp is one line of the input to todo batch, a JSON object such as
+//
+//	{"op":"add","item":{"title":"Buy milk","priority":2}}
+//	{"op":"edit","id":3,"item":{"due":"2024-06-01T00:00:00Z"}}
+//	{"op":"done","id":3,"children":true}
+//	{"op":"undone","id":4}
+//	{"op":"rm","id":5,"mode":"cascade"}
+//	{"op":"move","id":6,"list":"work","children":true}
+//
+// The ops are named after the commands that make the same changes.  add
+// takes the whole item, and edit just the fields to change.  done,
+// undone, rm and move take the flags of their commands as fields, and
+// move the list to put the item in as list.
+type batchOp struct {
+	Op       string          `json:"op"`
+	Id       int             `json:"id"`
+	Item     json.RawMessage `json:"item"`
+	Mode     string          `json:"mode"`
+	List     string          `json:"list"`
+	Children bool            `json:"children"`
+	Force    bool            `json:"force"`
+}
+
+func setupBatch(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("%w: batch takes at most one file name", errUsage)
+		}
+
+		in := os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			var err error
+			in, err = os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+		}
+		ops, err := readBatch(in)
+		if err != nil {
+			return err
+		}
+
+		//The transaction can be run more than once, so it keeps its
+		//messages to itself until it has been saved
+		var messages []string
+		err = todo.Update(func(tx *db.Tx) error {
+			messages = messages[:0]
+			for i, op := range ops {
+				message, err := applyBatchOp(tx, op)
+				if err != nil {
+					return fmt.Errorf("op %d (%s): %w", i+1, op.Op, err)
+				}
+				if message != "" {
+					messages = append(messages, message)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%w; nothing was changed", err)
+		}
+
+		for _, message := range messages {
+			fmt.Fprintln(os.Stderr, message)
+		}
+		fmt.Fprintln(os.Stderr, "Applied", len(ops), "changes in one write")
+		return nil
+	}
+}
+
+// readBatch reads the ops for todo batch, one JSON object per line.
+// Blank lines are skipped.  The whole input is checked before any of
+// it is applied.
+func readBatch(r io.Reader) ([]batchOp, error) {
+	var ops []batchOp
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var op batchOp
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&op); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errUsage, line, err)
+		}
+		switch op.Op {
+		case "add", "edit", "done", "undone", "rm", "move":
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown op %q, use add, edit, done, undone, rm or move", errUsage, line, op.Op)
+		}
+		if op.Op != "add" && op.Id == 0 {
+			return nil, fmt.Errorf("%w: line %d: %s needs the id of an item", errUsage, line, op.Op)
+		}
+		if (op.Op == "add" || op.Op == "edit") && len(op.Item) == 0 {
+			return nil, fmt.Errorf("%w: line %d: %s needs an item", errUsage, line, op.Op)
+		}
+		if op.Mode == "" {
+			op.Mode = string(db.DeleteRefuse)
+		}
+		if _, err := db.ParseDeleteMode(op.Mode); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errUsage, line, err)
+		}
+		var err error
+		op.List, err = db.ParseList(op.List)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errUsage, line, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, scanner.Err()
+}
+
+// applyBatchOp makes the change op describes in tx, and returns what
+// to tell the user about it, if anything
+func applyBatchOp(tx *db.Tx, op batchOp) (string, error) {
+	switch op.Op {
+	case "add":
+		var item db.ToDoItem
+		if err := checkBatchItem(op.Item, &item); err != nil {
+			return "", err
+		}
+		id, err := tx.AddItem(item)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint("Added item ", id), nil
+	case "edit":
+		stored, err := tx.GetItem(op.Id)
+		if err != nil {
+			return "", err
+		}
+
+		//The stored item's dates are shared with the DB, so the fields
+		//are decoded onto a copy of it that has its own
+		var item db.ToDoItem
+		current, err := json.Marshal(stored)
+		if err == nil {
+			err = json.Unmarshal(current, &item)
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := checkBatchItem(op.Item, &item); err != nil {
+			return "", err
+		}
+		item.Id = op.Id
+		return "", tx.UpdateItem(item)
+	case "done", "undone":
+		return "", tx.ChangeDoneStatus(op.Id, op.Op == "done", op.Children, op.Force)
+	case "move":
+		_, err := tx.MoveItem(op.Id, op.List, op.Children)
+		return "", err
+	default:
+		deleted, err := tx.DeleteItemMode(op.Id, db.DeleteMode(op.Mode))
+		if len(deleted) > 1 {
+			return fmt.Sprint("Deleted item ", op.Id, " and ", len(deleted)-1, " subtasks"), err
+		}
+		return "", err
+	}
+}
+
+// checkBatchItem decodes the JSON item of an add or edit op on top of
+// item, and checks the result the way add and edit check their flags
+func checkBatchItem(raw json.RawMessage, item *db.ToDoItem) error {
+	if err := json.Unmarshal(raw, item); err != nil {
+		return fmt.Errorf("bad item: %w", err)
+	}
+	if item.Title == "" {
+		return fmt.Errorf("the item needs a title")
+	}
+
+	var err error
+	item.Repeat, err = db.ParseRecurrence(string(item.Repeat))
+	if err != nil {
+		return err
+	}
+	item.List, err = db.ParseList(item.List)
+	return err
+}
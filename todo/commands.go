@@ -0,0 +1,1224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"drexel.edu/todo/db"
+)
+
+// command is one todo subcommand.  setup registers the command's flags
+// on its flag.FlagSet and returns the function that runs the command
+// once the flags have been parsed.  The run function is handed the
+// positional arguments left over after the flags, and returns an error
+// wrapping errUsage if they don't make sense.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(todo *db.ToDo, args []string) error
+}
+
+// commands is every subcommand, in the order they are listed in the
+// help text
+var commands = []*command{
+	{"add", "[flags] <title words>...", "Add an item to the database", setupAdd},
+	{"list", "[flags]", "List the items in the database", setupList},
+	{"lists", "", "List the named lists, with how many items each has", setupLists},
+	{"next", "[flags]", "List the open items that aren't blocked, in the order to do them", setupNext},
+	{"show", "<id>...", "Show one or more items", setupShow},
+	{"edit", "[flags] <id>", "Change fields of an item", setupEdit},
+	{"move", "[flags] <list> <id>...", "Move items to another list", setupMove},
+	{"done", "[flags] <id>...", "Mark items as done", setupDone(true)},
+	{"undone", "[flags] <id>...", "Mark items as not done", setupDone(false)},
+	{"rm", "[flags] <id>...", "Delete items from the database", setupRm},
+	{"purge", "[flags]", "Delete done items, such as the ones finished over a month ago", setupPurge},
+	{"start", "<id>", "Start timing the work on an item, stopping any other timer", setupStart},
+	{"stop", "[id]", "Stop the timer running on an item", setupStop},
+	{"report", "[flags]", "Sum the time worked by item, tag or day, as a table or CSV", setupReport},
+	{"batch", "[file]", "Make the changes listed in an NDJSON file, or stdin, in one write", setupBatch},
+	{"import", "[flags] <file>", "Add the items in a todo.txt, CSV, Markdown or JSON file", setupImport},
+	{"export", "[flags] [file]", "Write items to a todo.txt, CSV, Markdown or JSON file", setupExport},
+	{"undo", "[count]", "Undo the last change, or the last count changes", setupUndo(true)},
+	{"redo", "[count]", "Redo the last undone change, or the last count", setupUndo(false)},
+	{"log", "[flags]", "Show the history of changes, newest first", setupLog},
+	{"watch", "[flags]", "Print the changes made to the items as they happen, until interrupted", setupWatch},
+	{"serve", "[flags]", "Serve the database as a REST API", setupServe},
+	{"backup", "", "Take a snapshot of the database, kept until deleted by hand", setupBackup},
+	{"restore", "[flags]", "Restore the database from a snapshot or the backup file", setupRestore},
+	{"fsck", "[flags]", "Check the database file for problems, and with -repair fix them", setupFsck},
+	{"encrypt", "", "Encrypt the database, and its snapshots and journal, with the passphrase", setupEncrypt},
+	{"decrypt", "", "Decrypt the database, and its snapshots and journal, for good", setupDecrypt},
+	{"rekey", "[flags]", "Encrypt the database again with a new passphrase", setupRekey},
+}
+
+// fileCommands work on the database file, or the journal and snapshots
+// kept next to it, which a todo server doesn't share, so they are
+// refused in remote mode rather than failing partway
+var fileCommands = []string{"undo", "redo", "log", "watch", "backup", "restore", "fsck", "encrypt", "decrypt", "rekey"}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// flagSet returns a new FlagSet for the command, with a usage function
+// that prints the command's synopsis and flags
+func (cmd *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: todo [global flags] %s %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(out, "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+//------------------------------------------------------------
+// THE SUBCOMMANDS
+//------------------------------------------------------------
+
+// itemFlags are the flags shared by add and edit that set the fields
+// of an item one at a time
+type itemFlags struct {
+	title    string
+	due      string
+	repeat   string
+	priority int
+	tags     string
+	notes    string
+	assignee string
+	list     string
+	parent   int
+	blocked  string
+}
+
+func (f *itemFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.title, "title", "", "Title of the item")
+	fs.StringVar(&f.due, "due", "", "Due date, as YYYY-MM-DD or RFC 3339, or \"\" for none")
+	fs.StringVar(&f.repeat, "repeat", "", "Repeat when done: daily, every:N[d|w], weekly[:mon,...] or monthly[:day], \"\" for never")
+	fs.IntVar(&f.priority, "priority", 0, "Priority, higher is more urgent, 0 for none")
+	fs.StringVar(&f.tags, "tags", "", "Comma separated list of tags")
+	fs.StringVar(&f.notes, "notes", "", "Free form notes")
+	fs.StringVar(&f.assignee, "assignee", "", "Who the item is assigned to")
+	fs.StringVar(&f.list, "list", "", "Name of the list the item is in, default for the default list")
+	fs.IntVar(&f.parent, "parent", 0, "Id of the item this is a subtask of, 0 for none")
+	fs.StringVar(&f.blocked, "blocked-by", "", "Comma separated ids of the items that have to be done first, \"\" for none")
+}
+
+// apply copies the flags that were actually given on the command line
+// onto item, leaving every other field alone
+func (f *itemFlags) apply(fs *flag.FlagSet, item *db.ToDoItem) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "title":
+			item.Title = f.title
+		case "due":
+			item.DueDate, err = parseDate(f.due)
+		case "repeat":
+			item.Repeat = db.Recurrence(f.repeat)
+		case "priority":
+			item.Priority = f.priority
+		case "tags":
+			item.Tags = parseTags(f.tags)
+		case "notes":
+			item.Notes = f.notes
+		case "assignee":
+			item.Assignee = f.assignee
+		case "list":
+			item.List = f.list
+		case "parent":
+			item.ParentId = f.parent
+		case "blocked-by":
+			item.BlockedBy, err = parseIdList(f.blocked)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	//The rule and list may have come from -json rather than their
+	//flags, so they are checked either way
+	item.Repeat, err = db.ParseRecurrence(string(item.Repeat))
+	if err != nil {
+		return err
+	}
+	item.List, err = db.ParseList(item.List)
+	return err
+}
+
+func setupAdd(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	var fields itemFlags
+	fields.register(fs)
+	jsonFlag := fs.String("json", "", "The whole item as a JSON object, instead of a title and flags")
+	idFlag := fs.Int("id", 0, "Id for the new item, one is assigned if this is left out")
+	doneFlag := fs.Bool("done", false, "Add the item already done")
+
+	return func(todo *db.ToDo, args []string) error {
+		var item db.ToDoItem
+		if *jsonFlag != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("%w: give either -json or a title, not both", errUsage)
+			}
+			var err error
+			item, err = todo.JsonToItem(*jsonFlag)
+			if err != nil {
+				return fmt.Errorf("%w: -json requires a valid JSON todo item string: %v", errUsage, err)
+			}
+		}
+		if len(args) > 0 {
+			item.Title = strings.Join(args, " ")
+		}
+		if err := fields.apply(fs, &item); err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if *idFlag != 0 {
+			item.Id = *idFlag
+		}
+		if *doneFlag {
+			item.IsDone = true
+		}
+		if item.Title == "" {
+			return fmt.Errorf("%w: the item needs a title", errUsage)
+		}
+
+		id, err := todo.AddItem(item)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Added item", id)
+		return nil
+	}
+}
+
+// queryFlags are the flags shared by list, export and the bulk forms of
+// done, undone and rm that pick which items to work on and in what
+// order
+type queryFlags struct {
+	done   bool
+	open   bool
+	title  string
+	match  string
+	lists  string
+	sort   string
+	limit  int
+	offset int
+}
+
+func (f *queryFlags) register(fs *flag.FlagSet, verb string) {
+	fs.BoolVar(&f.done, "done", false, "Only "+verb+" items that are done")
+	fs.BoolVar(&f.open, "open", false, "Only "+verb+" items that are not done")
+	fs.StringVar(&f.title, "title", "", "Only "+verb+" items whose title contains this, ignoring case")
+	fs.StringVar(&f.match, "match", "", "Only "+verb+" items whose title matches this regular expression")
+	fs.StringVar(&f.lists, "list", "", "Only "+verb+" items in these comma separated lists, default for the default list")
+	fs.StringVar(&f.sort, "sort", "id", "Comma separated fields to sort on, prefix a field with - to reverse it")
+	fs.IntVar(&f.limit, "limit", 0, strings.ToUpper(verb[:1])+verb[1:]+" at most this many items, 0 for all")
+	fs.IntVar(&f.offset, "offset", 0, "Skip this many items before the first one")
+}
+
+// selects reports whether any of the flags that filter items were
+// given, which is what turns done, undone and rm into bulk commands
+func (f *queryFlags) selects() bool {
+	return f.done || f.open || f.title != "" || f.match != "" || f.lists != ""
+}
+
+// orders reports whether any of the flags that sort or page the items
+// were given, which only mean something along with the filter flags
+// when done, undone and rm are given ids instead
+func (f *queryFlags) orders() bool {
+	return f.sort != "id" || f.limit != 0 || f.offset != 0
+}
+
+// query builds the db.Query the flags describe
+func (f *queryFlags) query() (db.Query, error) {
+	var q db.Query
+	switch {
+	case f.done && f.open:
+		return q, fmt.Errorf("%w: -done and -open can't be used together", errUsage)
+	case f.done, f.open:
+		done := f.done
+		q.Done = &done
+	}
+	q.TitleContains = f.title
+	if f.match != "" {
+		re, err := regexp.Compile(f.match)
+		if err != nil {
+			return q, fmt.Errorf("%w: bad -match: %v", errUsage, err)
+		}
+		q.TitleRegexp = re
+	}
+	lists, err := db.ParseLists(f.lists)
+	if err != nil {
+		return q, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	q.Lists = lists
+	sortKeys, err := db.ParseSort(f.sort)
+	if err != nil {
+		return q, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	q.Sort = sortKeys
+	if f.limit < 0 || f.offset < 0 {
+		return q, fmt.Errorf("%w: -limit and -offset can't be negative", errUsage)
+	}
+	q.Limit = f.limit
+	q.Offset = f.offset
+	return q, nil
+}
+
+func setupList(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	var filter queryFlags
+	filter.register(fs, "list")
+	treeFlag := fs.Bool("tree", false, "Show subtasks under their parents")
+	upcomingFlag := fs.String("upcoming", "", "Also list the occurrences repeating items will have within this long, as 30d, 2w or 48h")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: list takes no arguments", errUsage)
+		}
+
+		q, err := filter.query()
+		if err != nil {
+			return err
+		}
+		if *upcomingFlag != "" {
+			window, err := parseAge(*upcomingFlag)
+			if err != nil {
+				return fmt.Errorf("%w: -upcoming: %v", errUsage, err)
+			}
+			q.Upcoming = time.Now().Add(window)
+		}
+		todoList, err := todo.QueryItems(q)
+		if err != nil {
+			return err
+		}
+		show := printItems
+		if *treeFlag {
+			show = printTree
+		}
+		if err := show(todoList); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "THERE ARE", len(todoList), "ITEMS IN THE DB")
+		return nil
+	}
+}
+
+func setupNext(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	allFlag := fs.Bool("all", false, "Also list the blocked items, after the items blocking them")
+	listFlag := fs.String("list", "", "Only list items in these comma separated lists, default for the default list")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: next takes no arguments", errUsage)
+		}
+		lists, err := db.ParseLists(*listFlag)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+
+		var items []db.ToDoItem
+		if *allFlag {
+			items, err = todo.GetAllItems()
+			items = db.DependencyOrder(items)
+		} else {
+			items, err = todo.NextItems()
+		}
+		if err != nil {
+			return err
+		}
+
+		//Items can be blocked by items in other lists, so the order is
+		//worked out over all of them first
+		if len(lists) > 0 {
+			q := db.Query{Lists: lists}
+			items = slices.DeleteFunc(items, func(item db.ToDoItem) bool { return !q.Matches(item) })
+		}
+		if err := printItems(items); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "THERE ARE", len(items), "ITEMS TO DO")
+		return nil
+	}
+}
+
+func setupLists(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: lists takes no arguments", errUsage)
+		}
+
+		lists, err := todo.Lists()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "LIST\tOPEN\tDONE\tTOTAL")
+		for _, list := range lists {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", list.Name, list.Open, list.Done, list.Open+list.Done)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "THERE ARE", len(lists), "LISTS IN THE DB")
+		return nil
+	}
+}
+
+func setupShow(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		ids, err := parseIds(args)
+		if err != nil {
+			return err
+		}
+
+		//Print whatever was found even if some ids weren't, so that
+		//formats like a JSON array stay a single document
+		var items []db.ToDoItem
+		err = forEachId(ids, func(id int) error {
+			item, err := todo.GetItem(id)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+		if printErr := printItems(items); printErr != nil {
+			return errors.Join(err, printErr)
+		}
+		return err
+	}
+}
+
+func setupEdit(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	var fields itemFlags
+	fields.register(fs)
+	jsonFlag := fs.String("json", "", "Replace the whole item with this JSON object")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("%w: edit takes exactly one id", errUsage)
+		}
+		ids, err := parseIds(args)
+		if err != nil {
+			return err
+		}
+
+		item, err := todo.GetItem(ids[0])
+		if err != nil {
+			return err
+		}
+		if *jsonFlag != "" {
+			item, err = todo.JsonToItem(*jsonFlag)
+			if err != nil {
+				return fmt.Errorf("%w: -json requires a valid JSON todo item string: %v", errUsage, err)
+			}
+			item.Id = ids[0]
+		}
+		if err := fields.apply(fs, &item); err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if item.Title == "" {
+			return fmt.Errorf("%w: the item needs a title", errUsage)
+		}
+
+		return todo.UpdateItem(item)
+	}
+}
+
+func setupMove(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	childrenFlag := fs.Bool("children", false, "Also move every subtask of the items, all the way down")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("%w: move takes a list name and at least one id", errUsage)
+		}
+		list, err := db.ParseList(args[0])
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		ids, err := parseIds(args[1:])
+		if err != nil {
+			return err
+		}
+
+		moved, err := todo.MoveItems(list, ids, *childrenFlag)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Moved %d items to %s\n", len(moved), db.ListName(list))
+		return nil
+	}
+}
+
+func setupDone(value bool) func(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+		var filter queryFlags
+		filter.register(fs, "mark")
+		childrenFlag := fs.Bool("children", false, "Also mark every subtask of the items, all the way down")
+		forceFlag := fs.Bool("force", false, "Mark items done even if items blocking them are still open")
+		previewFlag := fs.Bool("preview", false, "With filter flags, show the items that would be marked without changing the database")
+
+		return func(todo *db.ToDo, args []string) error {
+			if filter.selects() {
+				if len(args) > 0 || *childrenFlag {
+					return fmt.Errorf("%w: filter flags can't be used with ids or -children", errUsage)
+				}
+				q, err := filter.query()
+				if err != nil {
+					return err
+				}
+
+				items, err := todo.MarkMatching(q, value, *forceFlag, *previewFlag)
+				if errors.Is(err, db.ErrBlocked) {
+					return fmt.Errorf("%w; finish those first or use -force", err)
+				}
+				if err != nil {
+					return err
+				}
+				status := "not done"
+				if value {
+					status = "done"
+				}
+				return reportBulk(items, "Marked %d items "+status, "%d items would be marked "+status, *previewFlag)
+			}
+			if *previewFlag {
+				return fmt.Errorf("%w: -preview needs filter flags to pick the items", errUsage)
+			}
+			if filter.orders() {
+				return fmt.Errorf("%w: -sort, -limit and -offset need filter flags to pick the items", errUsage)
+			}
+
+			ids, err := parseIds(args)
+			if err != nil {
+				return err
+			}
+
+			//The ids are marked in one write, so if one can't be
+			//none are
+			err = todo.MarkItems(ids, value, *childrenFlag, *forceFlag)
+			if errors.Is(err, db.ErrBlocked) {
+				return fmt.Errorf("%w; finish those first or use -force", err)
+			}
+			return err
+		}
+	}
+}
+
+func setupRm(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	var filter queryFlags
+	filter.register(fs, "delete")
+	modeFlag := fs.String("mode", string(db.DeleteRefuse), "What to do with subtasks: refuse to delete, cascade to delete them too, or reparent to move them up")
+	previewFlag := fs.Bool("preview", false, "With filter flags, show the items that would be deleted without changing the database")
+
+	return func(todo *db.ToDo, args []string) error {
+		mode, err := db.ParseDeleteMode(*modeFlag)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+
+		if filter.selects() {
+			if len(args) > 0 {
+				return fmt.Errorf("%w: filter flags can't be used with ids", errUsage)
+			}
+			q, err := filter.query()
+			if err != nil {
+				return err
+			}
+
+			items, err := todo.DeleteMatching(q, mode, *previewFlag)
+			if err != nil {
+				return err
+			}
+			return reportBulk(items, "Deleted %d items", "%d items would be deleted", *previewFlag)
+		}
+		if *previewFlag {
+			return fmt.Errorf("%w: -preview needs filter flags to pick the items", errUsage)
+		}
+		if filter.orders() {
+			return fmt.Errorf("%w: -sort, -limit and -offset need filter flags to pick the items", errUsage)
+		}
+
+		ids, err := parseIds(args)
+		if err != nil {
+			return err
+		}
+
+		//The ids are deleted in one write, so if one can't be none
+		//are
+		deleted, err := todo.DeleteItems(ids, mode)
+		if err != nil {
+			return err
+		}
+		if subtasks := len(deleted) - len(ids); subtasks > 0 {
+			fmt.Fprintln(os.Stderr, "Deleted", len(deleted), "items,", subtasks, "of them subtasks")
+		}
+		return nil
+	}
+}
+
+func setupPurge(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	var filter queryFlags
+	filter.register(fs, "purge")
+	olderFlag := fs.String("older-than", "", "Only purge items finished longer ago than this, as 30d, 2w or 48h")
+	previewFlag := fs.Bool("preview", false, "Show the items that would be purged without changing the database")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: purge takes no arguments", errUsage)
+		}
+		if filter.open {
+			return fmt.Errorf("%w: purge only deletes done items", errUsage)
+		}
+
+		q, err := filter.query()
+		if err != nil {
+			return err
+		}
+		if *olderFlag != "" {
+			age, err := parseAge(*olderFlag)
+			if err != nil {
+				return fmt.Errorf("%w: -older-than: %v", errUsage, err)
+			}
+			q.CompletedBefore = time.Now().Add(-age)
+		}
+
+		items, err := todo.Purge(q, *previewFlag)
+		if err != nil {
+			return err
+		}
+		return reportBulk(items, "Purged %d items", "%d items would be purged", *previewFlag)
+	}
+}
+
+func setupBackup(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: backup takes no arguments", errUsage)
+		}
+
+		snapshot, err := todo.Backup()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Took snapshot", snapshot.Name)
+		return nil
+	}
+}
+
+func setupRestore(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	listFlag := fs.Bool("list", false, "List the snapshots instead of restoring one")
+	snapshotFlag := fs.String("snapshot", "", "Restore the snapshot with this name, or latest for the newest")
+	beforeFlag := fs.String("before", "", "Restore the newest snapshot taken at or before this time, as YYYY-MM-DD or RFC 3339")
+	yesFlag := fs.Bool("yes", false, "Restore without asking, after showing the changes")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: restore takes no arguments", errUsage)
+		}
+		if *listFlag {
+			return listSnapshots(todo)
+		}
+
+		//With neither -snapshot nor -before, restore the .bak file
+		var snapshot db.Snapshot
+		var err error
+		switch {
+		case *snapshotFlag != "" && *beforeFlag != "":
+			return fmt.Errorf("%w: -snapshot and -before can't be used together", errUsage)
+		case *snapshotFlag != "":
+			snapshot, err = todo.FindSnapshot(*snapshotFlag)
+		case *beforeFlag != "":
+			var at *time.Time
+			at, err = parseDate(*beforeFlag)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errUsage, err)
+			}
+			snapshot, err = todo.SnapshotBefore(*at)
+		default:
+			snapshot, err = todo.BackupFile()
+		}
+		if err != nil {
+			return err
+		}
+
+		changes, err := todo.DiffSnapshot(snapshot)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			fmt.Fprintln(os.Stderr, "The database already matches", snapshot.Name)
+			return nil
+		}
+		printChanges(changes)
+		if !*yesFlag && !confirm(fmt.Sprintf("Restore %s, making these %d changes?", snapshot.Name, len(changes))) {
+			fmt.Fprintln(os.Stderr, "Restore cancelled")
+			return nil
+		}
+
+		if err := todo.RestoreSnapshot(snapshot); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Database restored from", snapshot.Name)
+		return nil
+	}
+}
+
+func setupFsck(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	repairFlag := fs.Bool("repair", false, "Fix the problems: renumber duplicate ids, quarantine bad records, or restore the newest valid backup")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: fsck takes no arguments", errUsage)
+		}
+
+		report, err := todo.Repair(!*repairFlag)
+		for _, problem := range report.Problems {
+			fmt.Println(problem)
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case len(report.Problems) == 0:
+			fmt.Fprintln(os.Stderr, "No problems found")
+			return nil
+		case !report.Repaired:
+			return fmt.Errorf("%w: found %d problems, run todo fsck -repair to fix them", db.ErrCorrupt, len(report.Problems))
+		}
+		if report.Backup != "" {
+			fmt.Fprintln(os.Stderr, "Restored the database from", report.Backup)
+		}
+		if report.Quarantine != "" {
+			fmt.Fprintln(os.Stderr, "Moved what couldn't be kept to", report.Quarantine)
+		}
+		fmt.Fprintf(os.Stderr, "Fixed %d problems\n", len(report.Problems))
+		return nil
+	}
+}
+
+// The passphrase of an encrypted database comes from these environment
+// variables, unless it is given in a file
+const (
+	passphraseEnv    = "TODO_PASSPHRASE"
+	newPassphraseEnv = "TODO_NEW_PASSPHRASE"
+)
+
+func setupEncrypt(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: encrypt takes no arguments", errUsage)
+		}
+
+		if err := todo.Encrypt(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Database encrypted, keep the passphrase safe as it can't be read without it")
+		return nil
+	}
+}
+
+func setupDecrypt(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: decrypt takes no arguments", errUsage)
+		}
+
+		if err := todo.Decrypt(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Database decrypted")
+		return nil
+	}
+}
+
+func setupRekey(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	newKeyFileFlag := fs.String("new-key-file", "", "File holding the new passphrase, instead of $"+newPassphraseEnv)
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: rekey takes no arguments", errUsage)
+		}
+		passphrase, err := readPassphrase(*newKeyFileFlag, newPassphraseEnv)
+		if err != nil {
+			return err
+		}
+		if len(passphrase) == 0 {
+			return fmt.Errorf("%w: give the new passphrase with -new-key-file or %s", errUsage, newPassphraseEnv)
+		}
+
+		if err := todo.Rekey(passphrase); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Database encrypted with the new passphrase")
+		return nil
+	}
+}
+
+// readPassphrase reads a passphrase from keyFile, without the line
+// ending, or if that isn't given, from the environment variable env.
+// It is empty if there is neither.
+func readPassphrase(keyFile, env string) ([]byte, error) {
+	if keyFile == "" {
+		return []byte(os.Getenv(env)), nil
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errKeyFile, err)
+	}
+	passphrase := bytes.TrimRight(data, "\r\n")
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%w: key file %s is empty", errKeyFile, keyFile)
+	}
+	return passphrase, nil
+}
+
+// listSnapshots prints a table of the snapshots, oldest first
+func listSnapshots(todo *db.ToDo) error {
+	snapshots, err := todo.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTAKEN\tKIND")
+	for _, snapshot := range snapshots {
+		kind := "auto"
+		if snapshot.Manual {
+			kind = "manual"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", snapshot.Name, snapshot.Time.Local().Format("2006-01-02 15:04:05"), kind)
+	}
+	return tw.Flush()
+}
+
+// printChanges shows what a restore would do to each item: + for an
+// item it brings back, - for one it removes and ~ for one it changes,
+// followed by the fields that change
+func printChanges(changes []db.Change) {
+	for _, change := range changes {
+		switch {
+		case change.Before == nil:
+			fmt.Printf("+ %d %q\n", change.Id, change.After.Title)
+		case change.After == nil:
+			fmt.Printf("- %d %q\n", change.Id, change.Before.Title)
+		default:
+			fmt.Printf("~ %d %q: %s\n", change.Id, change.Before.Title, strings.Join(change.Fields(), ", "))
+		}
+	}
+}
+
+// confirm asks a yes or no question on stderr and reads the answer from
+// stdin.  Anything but yes, including no answer at all, is a no.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// reportBulk says what a bulk command did, with the number of items in
+// place of the %d in did.  A preview lists the items it would have
+// changed, as list would, and then says so with wouldDo.
+func reportBulk(items []db.ToDoItem, did, wouldDo string, preview bool) error {
+	if !preview {
+		fmt.Fprintf(os.Stderr, did+"\n", len(items))
+		return nil
+	}
+
+	if err := printItems(items); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, wouldDo+", nothing was changed\n", len(items))
+	return nil
+}
+
+// printItems writes items to stdout in the format picked by the global
+// -output flag.  Anything else a command has to say goes to stderr, so
+// stdout can be piped into jq or a spreadsheet.
+func printItems(items []db.ToDoItem) error {
+	format, err := db.ParseFormat(outputFlag)
+	if err != nil {
+		return err
+	}
+	return db.WriteItems(os.Stdout, format, items)
+}
+
+// printTree is printItems with subtasks shown under their parents
+func printTree(items []db.ToDoItem) error {
+	format, err := db.ParseFormat(outputFlag)
+	if err != nil {
+		return err
+	}
+	return db.WriteTree(os.Stdout, format, items)
+}
+
+func setupImport(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	formatFlag := fs.String("format", "", "Format of the file: csv, jsonl, json, markdown or todotxt, guessed from the file name if left out")
+	collisionFlag := fs.String("on-collision", string(db.CollisionSkip), "What to do with an item whose id is taken: skip, overwrite or renumber")
+	previewFlag := fs.Bool("preview", false, "Show what would be imported without changing the database")
+	listFlag := fs.String("list", "", "Put the items that aren't in a list of their own in this list")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("%w: import takes exactly one file name, or - for stdin", errUsage)
+		}
+		list, err := db.ParseList(*listFlag)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		format, err := fileFormat(*formatFlag, args[0])
+		if err != nil {
+			return err
+		}
+		onCollision, err := db.ParseCollision(*collisionFlag)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+
+		in := os.Stdin
+		if args[0] != "-" {
+			in, err = os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+		}
+		items, err := db.ReadItems(in, format)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", args[0], err)
+		}
+		for i := range items {
+			if items[i].List == "" {
+				items[i].List = list
+			}
+		}
+
+		actions, err := todo.ImportItems(items, onCollision, *previewFlag)
+		if err != nil {
+			return err
+		}
+
+		counts := make(map[string]int)
+		for _, action := range actions {
+			counts[action.Action]++
+			if *previewFlag {
+				fmt.Printf("%-9s %5d -> %-5d %s\n", action.Action, action.FromId, action.Item.Id, action.Item.Title)
+			}
+		}
+		verb := "Imported"
+		if *previewFlag {
+			verb = "Would import"
+		}
+		fmt.Fprintf(os.Stderr, "%s %d items: %d added, %d overwritten, %d renumbered, %d skipped\n",
+			verb, len(actions)-counts["skip"], counts["add"], counts["overwrite"], counts["renumber"], counts["skip"])
+		return nil
+	}
+}
+
+func setupExport(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	formatFlag := fs.String("format", "", "Format to write: csv, jsonl, json, markdown or todotxt, guessed from the file name if left out")
+	var filter queryFlags
+	filter.register(fs, "export")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("%w: export takes at most one file name", errUsage)
+		}
+		fileName := "-"
+		if len(args) == 1 {
+			fileName = args[0]
+		}
+		format, err := fileFormat(*formatFlag, fileName)
+		if err != nil {
+			return err
+		}
+
+		q, err := filter.query()
+		if err != nil {
+			return err
+		}
+		items, err := todo.QueryItems(q)
+		if err != nil {
+			return err
+		}
+
+		if fileName == "-" {
+			return db.WriteItems(os.Stdout, format, items)
+		}
+		out, err := os.Create(fileName)
+		if err != nil {
+			return err
+		}
+		err = db.WriteItems(out, format, items)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Exported", len(items), "items to", fileName)
+		return nil
+	}
+}
+
+func setupUndo(undo bool) func(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+		return func(todo *db.ToDo, args []string) error {
+			count := 1
+			if len(args) > 1 {
+				return fmt.Errorf("%w: give at most one count", errUsage)
+			}
+			if len(args) == 1 {
+				var err error
+				count, err = strconv.Atoi(args[0])
+				if err != nil || count < 1 {
+					return fmt.Errorf("%w: %q is not a count", errUsage, args[0])
+				}
+			}
+
+			step, verb := todo.Redo, "Redid"
+			if undo {
+				step, verb = todo.Undo, "Undid"
+			}
+			for i := 0; i < count; i++ {
+				entry, err := step()
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(os.Stderr, verb, describeEntry(entry))
+			}
+			return nil
+		}
+	}
+}
+
+func setupLog(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	limitFlag := fs.Int("limit", 20, "Show at most this many changes, 0 for all")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: log takes no arguments", errUsage)
+		}
+		if *limitFlag < 0 {
+			return fmt.Errorf("%w: -limit can't be negative", errUsage)
+		}
+
+		entries, err := todo.Journal()
+		if err != nil {
+			return err
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			if *limitFlag > 0 && len(entries)-i > *limitFlag {
+				break
+			}
+			fmt.Printf("%s  %s\n", entries[i].Time.Local().Format("2006-01-02 15:04:05"), describeEntry(entries[i]))
+		}
+		return nil
+	}
+}
+
+// describeEntry sums up a journal entry in one line, such as
+//
+//	#12 update item 3 "Buy milk"
+func describeEntry(entry db.JournalEntry) string {
+	what := entry.Op
+	switch {
+	case entry.Undoes != 0:
+		what = fmt.Sprintf("undo of #%d", entry.Undoes)
+	case entry.Redoes != 0:
+		what = fmt.Sprintf("redo of #%d", entry.Redoes)
+	}
+
+	if len(entry.Changes) != 1 {
+		return fmt.Sprintf("#%d %s, %d items", entry.Seq, what, len(entry.Changes))
+	}
+	change := entry.Changes[0]
+	item := change.After
+	if item == nil {
+		item = change.Before
+	}
+	return fmt.Sprintf("#%d %s item %d %q", entry.Seq, what, change.Id, item.Title)
+}
+
+// formatExtensions maps file name extensions to the format import and
+// export use for them when -format is left out
+var formatExtensions = map[string]db.Format{
+	".csv":   db.FormatCSV,
+	".jsonl": db.FormatJSONL,
+	".json":  db.FormatJSON,
+	".md":    db.FormatMarkdown,
+	".txt":   db.FormatTodoTxt,
+}
+
+// fileFormat works out the format of an import or export file, from
+// the -format flag if it was given and the file name otherwise.  stdin
+// and stdout, "-", default to todo.txt.
+func fileFormat(name, fileName string) (db.Format, error) {
+	if name != "" {
+		format, err := db.ParseFormat(name)
+		if err != nil || format == db.FormatTable {
+			return "", fmt.Errorf("%w: -format must be csv, jsonl, json, markdown or todotxt", errUsage)
+		}
+		return format, nil
+	}
+	if fileName == "-" {
+		return db.FormatTodoTxt, nil
+	}
+	format, found := formatExtensions[strings.ToLower(filepath.Ext(fileName))]
+	if !found {
+		return "", fmt.Errorf("%w: can't tell the format of %s, give -format", errUsage, fileName)
+	}
+	return format, nil
+}
+
+//------------------------------------------------------------
+// ARGUMENT PARSING HELPERS
+//------------------------------------------------------------
+
+// parseArgs parses the command's flags out of args and returns the
+// positional arguments.  Unlike fs.Parse, flags may come after
+// positional arguments, so "todo add Buy milk -priority 2" works.  A
+// "--" ends flag parsing, for titles or ids that start with a dash.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		rest := fs.Args()
+		consumed := len(args) - len(rest)
+		if consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// parseIds converts positional arguments to item ids.  At least one id
+// is required.
+func parseIds(args []string) ([]int, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: at least one item id is required", errUsage)
+	}
+
+	ids := make([]int, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not an item id", errUsage, arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseIdList parses a comma separated list of item ids.  An empty
+// list means none.
+func parseIdList(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an item id", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// forEachId calls fn for every id, carrying on past failures so one
+// bad id doesn't stop the rest, and returns all the errors together
+func forEachId(ids []int, fn func(id int) error) error {
+	var errs []error
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseDate accepts a plain date (taken as midnight UTC) or a full RFC
+// 3339 timestamp.  An empty string means no date.
+func parseDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a date, use YYYY-MM-DD or RFC 3339", s)
+	}
+
+	t = t.UTC()
+	return &t, nil
+}
+
+// parseAge accepts a Go duration such as "36h", or a whole number of
+// days or weeks such as "30d" or "2w"
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, found := strings.CutSuffix(s, suffix); found {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("%q is not an age, use something like 30d, 2w or 36h", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("%q is not an age, use something like 30d, 2w or 36h", s)
+	}
+	return age, nil
+}
+
+// parseTags splits a comma separated list of tags, dropping blanks
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"maps"
+)
+
+// The bulk operations change every item a Query matches, or every item
+// in a list of ids, in a single write, rather than one write per item.
+// The ones that take a Query can also be run as a preview, which works
+// out what would change without saving anything.
+
+// MarkMatching marks every item q matches as done (value true) or not
+// done, like ChangeDoneStatus with its force option.  Items that
+// already have that status are left alone.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The items whose status changed are returned, as they are
+//			after the change
+//		(2) If preview is set the DB file will not be modified
+//		(3) If there is an error, it will be returned and
+//			nothing will be changed
+func (t *ToDo) MarkMatching(q Query, value, force, preview bool) ([]ToDoItem, error) {
+	return t.MarkMatchingContext(context.Background(), q, value, force, preview)
+}
+
+// MarkMatchingContext is MarkMatching, giving up with ctx's error if
+// ctx is done before the changes are saved, or read for a preview
+func (t *ToDo) MarkMatchingContext(ctx context.Context, q Query, value, force, preview bool) ([]ToDoItem, error) {
+	op := "undone"
+	if value {
+		op = "done"
+	}
+
+	var changed []ToDoItem
+	err := t.bulkContext(ctx, op, preview, func() error {
+		matched, err := q.Apply(t.items())
+		if err != nil {
+			return err
+		}
+
+		changed = nil
+		for _, item := range matched {
+			if item.IsDone == value {
+				continue
+			}
+			if err := t.setDone(item.Id, value); err != nil {
+				return err
+			}
+			changed = append(changed, t.toDoMap[item.Id])
+		}
+
+		if value && !force {
+			for _, item := range changed {
+				if err := checkBlocked(t.toDoMap, item.Id); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("MarkMatching: %w", err)
+	}
+
+	return changed, nil
+}
+
+// DeleteMatching deletes every item q matches, dealing with their
+// subtasks as mode says.  Subtasks are deleted before their parents,
+// so DeleteRefuse only refuses if an item has subtasks q doesn't match.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) Every item deleted is returned, subtasks deleted by
+//			DeleteCascade included, as they were before
+//		(2) If preview is set the DB file will not be modified
+//		(3) If there is an error, it will be returned and
+//			nothing will be deleted
+func (t *ToDo) DeleteMatching(q Query, mode DeleteMode, preview bool) ([]ToDoItem, error) {
+	return t.DeleteMatchingContext(context.Background(), q, mode, preview)
+}
+
+// DeleteMatchingContext is DeleteMatching, giving up with ctx's error
+// if ctx is done before the deletions are saved, or read for a preview
+func (t *ToDo) DeleteMatchingContext(ctx context.Context, q Query, mode DeleteMode, preview bool) ([]ToDoItem, error) {
+	deleted, err := t.deleteMatching(ctx, q, mode, preview)
+	if err != nil {
+		return nil, fmt.Errorf("DeleteMatching: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// deleteMatching does the work of DeleteMatchingContext, leaving the
+// error for the method called to wrap
+func (t *ToDo) deleteMatching(ctx context.Context, q Query, mode DeleteMode, preview bool) ([]ToDoItem, error) {
+	if _, err := ParseDeleteMode(string(mode)); err != nil {
+		return nil, err
+	}
+
+	var deleted []ToDoItem
+	err := t.bulkContext(ctx, "delete", preview, func() error {
+		matched, err := q.Apply(t.items())
+		if err != nil {
+			return err
+		}
+
+		deleted, err = t.deleteItems(matched, mode)
+		return err
+	})
+	return deleted, err
+}
+
+// MarkItems is ChangeDoneStatus for several items at once.  The items
+// blocking them are checked once they have all been marked, so an item
+// can be marked done along with the items blocking it.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The items must exist in the DB
+//
+// Postconditions:
+//
+//	 (1) The status of the items, and their subtasks if children
+//			is set, will be updated in a single write
+//		(2) If there is an error, it will be returned and
+//			nothing will be changed
+func (t *ToDo) MarkItems(ids []int, value, children, force bool) error {
+	return t.MarkItemsContext(context.Background(), ids, value, children, force)
+}
+
+// MarkItemsContext is MarkItems, giving up with ctx's error if ctx is
+// done before the change is saved
+func (t *ToDo) MarkItemsContext(ctx context.Context, ids []int, value, children, force bool) error {
+	op := "undone"
+	if value {
+		op = "done"
+	}
+	err := t.writeDB(ctx, &JournalEntry{Op: op}, func() error {
+		return t.markDone(ids, value, children, force)
+	})
+	if err != nil {
+		return fmt.Errorf("MarkItems: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteItems is DeleteItemMode for several items at once.  Subtasks
+// are deleted before their parents, as with DeleteMatching.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The items must exist in the DB
+//
+// Postconditions:
+//
+//	 (1) Every item deleted is returned, subtasks deleted by
+//			DeleteCascade included, as they were before
+//		(2) If there is an error, it will be returned and
+//			nothing will be deleted
+func (t *ToDo) DeleteItems(ids []int, mode DeleteMode) ([]ToDoItem, error) {
+	return t.DeleteItemsContext(context.Background(), ids, mode)
+}
+
+// DeleteItemsContext is DeleteItems, giving up with ctx's error if ctx
+// is done before the deletions are saved
+func (t *ToDo) DeleteItemsContext(ctx context.Context, ids []int, mode DeleteMode) ([]ToDoItem, error) {
+	if _, err := ParseDeleteMode(string(mode)); err != nil {
+		return nil, fmt.Errorf("DeleteItems: %w", err)
+	}
+
+	var deleted []ToDoItem
+	err := t.writeDB(ctx, &JournalEntry{Op: "delete"}, func() error {
+		items := make([]ToDoItem, 0, len(ids))
+		for _, id := range ids {
+			item, found := t.toDoMap[id]
+			if !found {
+				return notFound(id)
+			}
+			items = append(items, item)
+		}
+
+		var err error
+		deleted, err = t.deleteItems(items, mode)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DeleteItems: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// Purge is DeleteMatching for finished work: it deletes the done items
+// q matches, whatever q says about done, and moves any open subtasks
+// they have up to their parent rather than losing them.  Set
+// q.CompletedBefore to purge only items finished a while ago.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The items deleted are returned, as they were before
+//		(2) If preview is set the DB file will not be modified
+//		(3) If there is an error, it will be returned and
+//			nothing will be deleted
+func (t *ToDo) Purge(q Query, preview bool) ([]ToDoItem, error) {
+	return t.PurgeContext(context.Background(), q, preview)
+}
+
+// PurgeContext is Purge, giving up with ctx's error if ctx is done
+// before the deletions are saved, or read for a preview
+func (t *ToDo) PurgeContext(ctx context.Context, q Query, preview bool) ([]ToDoItem, error) {
+	done := true
+	q.Done = &done
+	deleted, err := t.deleteMatching(ctx, q, DeleteReparent, preview)
+	if err != nil {
+		return nil, fmt.Errorf("Purge: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// bulkContext runs fn in a single write recorded under op, as writeDB
+// does.  If preview is set fn is run on a copy of the items instead,
+// which is thrown away afterwards, so nothing is saved or journaled.
+func (t *ToDo) bulkContext(ctx context.Context, op string, preview bool, fn func() error) error {
+	if !preview {
+		return t.writeDB(ctx, &JournalEntry{Op: op}, fn)
+	}
+
+	return t.viewContext(ctx, func() error {
+		toDoMap, lastId := t.toDoMap, t.lastId
+		defer func() {
+			t.toDoMap, t.lastId = toDoMap, lastId
+		}()
+
+		t.toDoMap = maps.Clone(toDoMap)
+		return fn()
+	})
+}
+
+// markDone does the work of MarkItems on t.toDoMap
+func (t *ToDo) markDone(ids []int, value, children, force bool) error {
+	var marked []int
+	for _, id := range ids {
+		if _, found := t.toDoMap[id]; !found {
+			return notFound(id)
+		}
+		marked = append(marked, id)
+		if children {
+			marked = append(marked, descendants(t.toDoMap, id)...)
+		}
+	}
+	for _, id := range marked {
+		if err := t.setDone(id, value); err != nil {
+			return err
+		}
+	}
+
+	if value && !force {
+		for _, id := range marked {
+			if err := checkBlocked(t.toDoMap, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteItems does the work of DeleteMatching on t.toDoMap, deleting
+// items, and returns the items deleted as they were before
+func (t *ToDo) deleteItems(items []ToDoItem, mode DeleteMode) ([]ToDoItem, error) {
+	var deleted []ToDoItem
+	before := maps.Clone(t.toDoMap)
+	ordered, _ := TreeOrder(items)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if _, found := t.toDoMap[ordered[i].Id]; !found {
+			continue
+		}
+		ids, err := t.deleteItem(ordered[i].Id, mode)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			deleted = append(deleted, before[id])
+		}
+	}
+	return deleted, nil
+}
+
+// items returns the items in t.toDoMap as a slice, in no particular
+// order
+func (t *ToDo) items() []ToDoItem {
+	items := make([]ToDoItem, 0, len(t.toDoMap))
+	for _, item := range t.toDoMap {
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,491 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// A JSON file database can be kept encrypted, so the items in it can't
+// be read without a passphrase.  Everything kept next to it that holds
+// items is encrypted along with it: the snapshots, the ".bak" backup,
+// the journal and the quarantine file.
+//
+// An encrypted file is still JSON, an envelope holding the AES-256-GCM
+// ciphertext of what would otherwise be in the file, along with the
+// scrypt salt and cost the key was derived from the passphrase with.
+// The journal and quarantine file are encrypted a line at a time, so
+// they can still be appended to.
+//
+// Whether a database is encrypted is up to its file, not whether a
+// passphrase was given.  The passphrase is only needed to read an
+// encrypted file, and saves leave the file encrypted or not the way it
+// was.  Encrypt, Decrypt and Rekey are what change that.
+
+// ErrNoPassphrase is returned when reading an encrypted file without a
+// passphrase
+var ErrNoPassphrase = errors.New("the database is encrypted and no passphrase was given")
+
+// ErrBadPassphrase is returned when an encrypted file can't be
+// decrypted.  AES-GCM can't tell a wrong passphrase from a file that
+// has been changed, so it means either.
+var ErrBadPassphrase = errors.New("wrong passphrase, or the encrypted file has been changed")
+
+// sealCipher is the cipher encrypted files are written with
+const sealCipher = "aes-256-gcm"
+
+// The scrypt cost new keys are derived with, the one recommended for
+// interactive logins.  Reading a file with a cost much higher than
+// this is refused, as the cost comes from the file.
+const (
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+	scryptMaxN = 1 << 20
+)
+
+// kdfParams is how the key of an encrypted file is derived from the
+// passphrase
+type kdfParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// newKDFParams returns the parameters for a new key, with a new salt
+func newKDFParams() (*kdfParams, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &kdfParams{Name: "scrypt", Salt: salt, N: scryptN, R: scryptR, P: scryptP}, nil
+}
+
+// sealed is an encrypted file, or one line of an encrypted journal or
+// quarantine file.  Cipher comes first, which is how isSealed spots
+// one.  The header, Cipher and KDF, is authenticated along with Data.
+type sealed struct {
+	Cipher string    `json:"cipher"`
+	KDF    kdfParams `json:"kdf"`
+	Nonce  []byte    `json:"nonce,omitempty"`
+	Data   []byte    `json:"data,omitempty"`
+}
+
+// header is the part of an encrypted file that is authenticated but
+// not encrypted
+func (s sealed) header() ([]byte, error) {
+	return json.Marshal(sealed{Cipher: s.Cipher, KDF: s.KDF})
+}
+
+// isSealed reports whether data is encrypted, which is when it is a
+// JSON object whose first field is "cipher".  Only the start of data
+// is read.
+func isSealed(data []byte) bool {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return false
+	}
+	token, err := decoder.Token()
+	return err == nil && token == "cipher"
+}
+
+// keyring holds a passphrase and the keys derived from it.  Deriving a
+// key is slow on purpose, so each is only derived once.  A nil keyring
+// has no passphrase.
+type keyring struct {
+	passphrase []byte
+
+	mu    sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+// newKeyring returns a keyring for passphrase, or nil if it is empty
+func newKeyring(passphrase []byte) *keyring {
+	if len(passphrase) == 0 {
+		return nil
+	}
+	return &keyring{passphrase: bytes.Clone(passphrase), aeads: make(map[string]cipher.AEAD)}
+}
+
+// aead returns the cipher for the key params derives
+func (k *keyring) aead(params kdfParams) (cipher.AEAD, error) {
+	if params.Name != "scrypt" {
+		return nil, fmt.Errorf("unknown key derivation %q", params.Name)
+	}
+	if params.N > scryptMaxN || params.R*params.P > 64 {
+		return nil, fmt.Errorf("the scrypt cost of the file is too high to derive its key")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := fmt.Sprintf("%x/%d/%d/%d", params.Salt, params.N, params.R, params.P)
+	if aead, found := k.aeads[id]; found {
+		return aead, nil
+	}
+	key, err := scrypt.Key(k.passphrase, params.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k.aeads[id] = aead
+	return aead, nil
+}
+
+// seal encrypts data with the key params derives.  If params is nil,
+// data is returned as it is.
+func (k *keyring) seal(params *kdfParams, data []byte) ([]byte, error) {
+	if params == nil {
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrNoPassphrase
+	}
+	aead, err := k.aead(*params)
+	if err != nil {
+		return nil, err
+	}
+
+	file := sealed{Cipher: sealCipher, KDF: *params, Nonce: make([]byte, aead.NonceSize())}
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return nil, err
+	}
+	header, err := file.header()
+	if err != nil {
+		return nil, err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, data, header)
+	return json.Marshal(file)
+}
+
+// open decrypts data if it is encrypted, returning the parameters its
+// key was derived with, or nil and data as it is if it isn't
+func (k *keyring) open(data []byte) ([]byte, *kdfParams, error) {
+	if !isSealed(data) {
+		return data, nil, nil
+	}
+	var file sealed
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil, err
+	}
+	if file.Cipher != sealCipher {
+		return nil, nil, fmt.Errorf("unknown cipher %q", file.Cipher)
+	}
+	if k == nil {
+		return nil, nil, ErrNoPassphrase
+	}
+	aead, err := k.aead(file.KDF)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, nil, fmt.Errorf("the nonce is %d bytes, not %d", len(file.Nonce), aead.NonceSize())
+	}
+	header, err := file.header()
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, header)
+	if err != nil {
+		return nil, nil, ErrBadPassphrase
+	}
+	return plain, &file.KDF, nil
+}
+
+// sealing is how a database and the files next to it are read and
+// written: keys opens the ones that are encrypted, and new ones are
+// encrypted with the key params derives, or written as plaintext if
+// params is nil
+type sealing struct {
+	keys   *keyring
+	params *kdfParams
+}
+
+// seal encrypts data, if the files are encrypted
+func (s sealing) seal(data []byte) ([]byte, error) {
+	return s.keys.seal(s.params, data)
+}
+
+// open decrypts data, if it is encrypted
+func (s sealing) open(data []byte) ([]byte, error) {
+	data, _, err := s.keys.open(data)
+	return data, err
+}
+
+// readFile reads a whole file, decrypting it if it is encrypted
+func (s sealing) readFile(fileName string) ([]byte, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(data)
+}
+
+// SetPassphrase gives the passphrase encrypted files are read with.
+// Only JSON file databases can be encrypted, other stores ignore it.
+func (t *ToDo) SetPassphrase(passphrase []byte) {
+	if store, ok := t.store.(*JsonStore); ok {
+		store.SetPassphrase(passphrase)
+	}
+}
+
+// sealing is how the files next to the database are read and written,
+// the same way as the database file itself
+func (t *ToDo) sealing() sealing {
+	if store, ok := t.store.(*JsonStore); ok {
+		return store.sealing()
+	}
+	return sealing{}
+}
+
+// Encrypt encrypts the database with the passphrase given to
+// SetPassphrase, along with its snapshots, backup, journal and
+// quarantine file.
+// Preconditions:   (1) The DB must be kept in a JSON file
+//
+//					(2) A passphrase must have been set
+//
+// Postconditions:
+//
+//	 (1) Every file will be encrypted, with a key derived from the
+//			passphrase and a new salt
+//		(2) If the database is already encrypted, an error will be
+//			returned and nothing will be changed
+func (t *ToDo) Encrypt() error {
+	err := t.reseal(func(from sealing) (sealing, error) {
+		if from.params != nil {
+			return sealing{}, errors.New("the database is already encrypted")
+		}
+		if from.keys == nil {
+			return sealing{}, errors.New("no passphrase was given")
+		}
+		params, err := newKDFParams()
+		return sealing{keys: from.keys, params: params}, err
+	})
+	if err != nil {
+		return fmt.Errorf("Encrypt: %w", err)
+	}
+
+	return nil
+}
+
+// Decrypt writes the database, and every file next to it that Encrypt
+// encrypted, as plaintext again.
+// Preconditions:   (1) The DB must be kept in an encrypted JSON file
+//
+//					(2) The passphrase must have been set
+//
+// Postconditions:
+//
+//	 (1) Every file will be plaintext
+//		(2) If there is an error, it will be returned
+func (t *ToDo) Decrypt() error {
+	err := t.reseal(func(from sealing) (sealing, error) {
+		if from.params == nil {
+			return sealing{}, errors.New("the database isn't encrypted")
+		}
+		return sealing{keys: from.keys}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Decrypt: %w", err)
+	}
+
+	return nil
+}
+
+// Rekey encrypts the database, and every file next to it, again with a
+// new key derived from passphrase and a new salt.  From then on only
+// the new passphrase opens them.
+// Preconditions:   (1) The DB must be kept in an encrypted JSON file
+//
+//					(2) The current passphrase must have been set
+//
+// Postconditions:
+//
+//	 (1) Every file will be encrypted with the new key
+//		(2) The ToDo will use the new passphrase from then on
+//		(3) If there is an error, it will be returned
+func (t *ToDo) Rekey(passphrase []byte) error {
+	err := t.reseal(func(from sealing) (sealing, error) {
+		if from.params == nil {
+			return sealing{}, errors.New("the database isn't encrypted, use Encrypt")
+		}
+		keys := newKeyring(passphrase)
+		if keys == nil {
+			return sealing{}, errors.New("the new passphrase is empty")
+		}
+		params, err := newKDFParams()
+		return sealing{keys: keys, params: params}, err
+	})
+	if err != nil {
+		return fmt.Errorf("Rekey: %w", err)
+	}
+
+	return nil
+}
+
+// resealed is a file rewritten by reseal.  info is the file as it
+// was, whose mode and modification time are kept, as the backup file
+// is dated by it.
+type resealed struct {
+	fileName string
+	data     []byte
+	info     os.FileInfo
+}
+
+// reseal rewrites the database, and every file next to it that holds
+// items, the way change says given how they are written now.  All of
+// them are read and converted before any is written, so a wrong
+// passphrase or a file that can't be read changes nothing.  The other
+// files are only renamed into place once the database is saved, so if
+// the save fails they can all still be read the old way.
+func (t *ToDo) reseal(change func(from sealing) (sealing, error)) error {
+	store, ok := t.store.(*JsonStore)
+	if !ok {
+		return errors.New("only JSON file databases can be encrypted")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	unlock, err := store.Lock()
+	if err != nil {
+		return &StoreError{Op: "locking", Err: err}
+	}
+	defer unlock()
+
+	contents, err := store.Load()
+	if err != nil {
+		return &StoreError{Op: "loading", Err: err}
+	}
+	from := store.sealing()
+	to, err := change(from)
+	if err != nil {
+		return err
+	}
+
+	var files []resealed
+	wholeFiles := []string{store.FileName() + ".bak"}
+	snapshots, err := t.listSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		wholeFiles = append(wholeFiles, snapshot.path)
+	}
+	for _, fileName := range wholeFiles {
+		file, err := resealFile(fileName, from, to, false)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		files = append(files, file)
+	}
+	for _, fileName := range []string{t.journalFileName(), store.FileName() + ".quarantine"} {
+		file, err := resealFile(fileName, from, to, true)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		files = append(files, file)
+	}
+
+	staged := make([]string, 0, len(files))
+	defer func() {
+		for _, tmpName := range staged {
+			if tmpName != "" {
+				os.Remove(tmpName)
+			}
+		}
+	}()
+	for _, file := range files {
+		tmpName, err := stageFile(file.fileName, file.data, file.info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		staged = append(staged, tmpName)
+	}
+
+	store.setSealing(to)
+	err = store.Save(contents)
+	t.version = 0
+	if err != nil {
+		store.setSealing(from)
+		return &StoreError{Op: "saving", Err: err}
+	}
+
+	for i, file := range files {
+		err := renameStaged(staged[i], file.fileName)
+		if err != nil {
+			return fmt.Errorf("the database was saved, but %s wasn't: %w", file.fileName, err)
+		}
+		staged[i] = ""
+		os.Chtimes(file.fileName, file.info.ModTime(), file.info.ModTime())
+	}
+
+	return nil
+}
+
+// resealFile reads a file the way from says and converts it to the
+// way to says.  lines is set for files that are encrypted a line at a
+// time.
+func resealFile(fileName string, from, to sealing, lines bool) (resealed, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return resealed{}, err
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return resealed{}, err
+	}
+
+	if !lines {
+		data, err = from.open(data)
+		if err == nil {
+			data, err = to.seal(data)
+		}
+		return resealed{fileName: fileName, data: data, info: info}, err
+	}
+
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		line, err := from.open(scanner.Bytes())
+		if err == nil {
+			line, err = to.seal(line)
+		}
+		if err != nil {
+			return resealed{}, fmt.Errorf("line %d: %w", n, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return resealed{fileName: fileName, data: buf.Bytes(), info: info}, scanner.Err()
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An item's BlockedBy lists the items that have to be done before it
+// can be.  Every write that sets blockers checks that they exist and
+// don't lead back to the item, so the links never form a loop.  A
+// blocker that is done, or has been deleted, no longer blocks.
+
+// ErrBadBlocker is returned when one of an item's blockers doesn't
+// exist or is blocked, however indirectly, by the item itself
+var ErrBadBlocker = errors.New("bad blocker")
+
+// ErrBlocked is returned when an item is marked done while items that
+// block it are still open
+var ErrBlocked = errors.New("item is blocked")
+
+// NextItems returns the open items that aren't blocked by any open
+// item, in the order DependencyOrder gives.  These are the items that
+// can be worked on now.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The unblocked open items will be returned
+//		(2) If there is an error, it will be returned
+//		(3) The database file will not be modified
+func (t *ToDo) NextItems() ([]ToDoItem, error) {
+	return t.NextItemsContext(context.Background())
+}
+
+// NextItemsContext is NextItems, giving up with ctx's error if ctx is
+// done before the database is read
+func (t *ToDo) NextItemsContext(ctx context.Context) ([]ToDoItem, error) {
+	items, err := t.GetAllItemsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("NextItems: %w", err)
+	}
+
+	open := make(map[int]bool, len(items))
+	for _, item := range items {
+		open[item.Id] = !item.IsDone
+	}
+	var next []ToDoItem
+	for _, item := range DependencyOrder(items) {
+		if len(openBlockers(open, item)) == 0 {
+			next = append(next, item)
+		}
+	}
+	return next, nil
+}
+
+// DependencyOrder returns the open items among items in an order that
+// puts every item after the open items that block it.  Among the items
+// that are free to go next, the most urgent comes first, then the one
+// due soonest, then the lowest id.  Items caught in a loop of blockers,
+// which the db package doesn't let happen but a hand edited file might,
+// come last.
+func DependencyOrder(items []ToDoItem) []ToDoItem {
+	open := make(map[int]bool, len(items))
+	for _, item := range items {
+		open[item.Id] = !item.IsDone
+	}
+
+	waiting := make(map[int]int)
+	unblocks := make(map[int][]int)
+	var ready []int
+	for i, item := range items {
+		if item.IsDone {
+			continue
+		}
+		blockers := openBlockers(open, item)
+		for _, id := range blockers {
+			unblocks[id] = append(unblocks[id], i)
+		}
+		waiting[i] = len(blockers)
+		if len(blockers) == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	first := func(a, b int) bool {
+		x, y := items[a], items[b]
+		switch {
+		case x.Priority != y.Priority:
+			return x.Priority > y.Priority
+		case timeLess(x.DueDate, y.DueDate), timeLess(y.DueDate, x.DueDate):
+			return timeLess(x.DueDate, y.DueDate)
+		}
+		return x.Id < y.Id
+	}
+
+	ordered := make([]ToDoItem, 0, len(waiting))
+	placed := make(map[int]bool, len(waiting))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(a, b int) bool { return first(ready[a], ready[b]) })
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, items[i])
+		placed[i] = true
+		for _, j := range unblocks[items[i].Id] {
+			waiting[j]--
+			if waiting[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	for i, item := range items {
+		if !item.IsDone && !placed[i] {
+			ordered = append(ordered, item)
+		}
+	}
+	return ordered
+}
+
+// openBlockers returns the ids of item's blockers that open says are
+// open, leaving out any that are missing
+func openBlockers(open map[int]bool, item ToDoItem) []int {
+	var ids []int
+	for _, id := range item.BlockedBy {
+		if open[id] && id != item.Id && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// checkBlocked returns an error wrapping ErrBlocked if any of the
+// blockers of item id in toDoMap are still open
+func checkBlocked(toDoMap DbMap, id int) error {
+	open := make(map[int]bool, len(toDoMap[id].BlockedBy))
+	for _, blocker := range toDoMap[id].BlockedBy {
+		item, found := toDoMap[blocker]
+		open[blocker] = found && !item.IsDone
+	}
+	if blockers := openBlockers(open, toDoMap[id]); len(blockers) > 0 {
+		return fmt.Errorf("%w: item %d is waiting on %s", ErrBlocked, id, joinIds(blockers))
+	}
+	return nil
+}
+
+// checkBlockers returns an error wrapping ErrBadBlocker if any of item's
+// blockers is not in toDoMap, is item itself, or is blocked by item
+// through a chain of blockers
+func checkBlockers(toDoMap DbMap, item ToDoItem) error {
+	for _, id := range item.BlockedBy {
+		if id == item.Id {
+			return fmt.Errorf("%w: item %d can't block itself", ErrBadBlocker, item.Id)
+		}
+		if _, found := toDoMap[id]; !found {
+			return fmt.Errorf("%w: blocking item %d does not exist", ErrBadBlocker, id)
+		}
+	}
+
+	//Blockers already looked through don't lead back to item, so
+	//seen is shared between the walks
+	seen := map[int]bool{}
+	for _, blocker := range item.BlockedBy {
+		for queue := []int{blocker}; len(queue) > 0; queue = queue[1:] {
+			id := queue[0]
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if slices.Contains(toDoMap[id].BlockedBy, item.Id) {
+				return fmt.Errorf("%w: item %d is already waiting on item %d", ErrBadBlocker, blocker, item.Id)
+			}
+			queue = append(queue, toDoMap[id].BlockedBy...)
+		}
+	}
+	return nil
+}
+
+// unblock removes the deleted ids from the blockers of every item left
+// in t.toDoMap
+func (t *ToDo) unblock(deleted []int) {
+	for id, item := range t.toDoMap {
+		var kept []int
+		for _, blocker := range item.BlockedBy {
+			if !slices.Contains(deleted, blocker) {
+				kept = append(kept, blocker)
+			}
+		}
+		if len(kept) != len(item.BlockedBy) {
+			changed := item
+			changed.BlockedBy = kept
+			t.toDoMap[id] = stampUpdate(item, changed)
+		}
+	}
+}
+
+// joinIds formats ids as a comma separated list
+func joinIds(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
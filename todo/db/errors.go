@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors the ToDo methods return can be told apart with errors.Is
+// and errors.As, whatever they have been wrapped in:
+//
+//	ErrNotFound, ErrExists	an item that isn't in the database, or an
+//				id that is already taken, in an *ItemError
+//	ErrBadId, ErrBadParent, ErrBadBlocker
+//				an item the database can't hold: a
+//				negative id, in an *ItemError, or a
+//				parent or blocker that doesn't exist or
+//				makes a loop
+//	*StoreError		the store couldn't be locked, read or
+//				written, such as a file that can't be
+//				opened or a server that doesn't answer
+//	ErrCorrupt		the database file can't be parsed, in a
+//				*StoreError
+//	ErrConflict		someone else saved first, see HttpStore
+//	ErrNoPassphrase, ErrBadPassphrase
+//				an encrypted database can't be opened
+//	ErrBlocked, ErrHasChildren
+//				a change the item's dependencies or
+//				subtasks don't allow
+//	ErrNoTimer, ErrTimerRunning, ErrItemDone
+//				a timer that can't be stopped or
+//				started, the last two in an *ItemError
+//	ErrVetoed		a hook stopped the change
+//
+// The methods that take a context.Context return its error, which
+// wraps context.Canceled or context.DeadlineExceeded, once it is done.
+
+// ErrNotFound is returned, in an *ItemError, for an item that isn't in
+// the database
+var ErrNotFound = errors.New("does not exist")
+
+// ErrExists is returned, in an *ItemError, when an item is added with
+// an id another item already has
+var ErrExists = errors.New("already exists")
+
+// ErrBadId is returned, in an *ItemError, for an item given a negative
+// id.  Ids start at 1; 0 asks for the next free one.
+var ErrBadId = errors.New("has a negative id, ids start at 1")
+
+// ItemError is an error about one item, such as ErrNotFound
+type ItemError struct {
+	Id  int
+	Err error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d %v", e.Id, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// notFound returns the error for item id not being in the database
+func notFound(id int) error {
+	return &ItemError{Id: id, Err: ErrNotFound}
+}
+
+// StoreError is an error from the Store behind a ToDo.  Op is what was
+// being done: "locking", "loading" or "saving".
+type StoreError struct {
+	Op  string
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("error %s DB: %v", e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
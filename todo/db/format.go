@@ -0,0 +1,288 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Format is one of the ways WriteItems can lay out a list of items
+type Format string
+
+const (
+	// FormatTable is an aligned, human readable table
+	FormatTable Format = "table"
+	// FormatCSV is comma separated values with a header row
+	FormatCSV Format = "csv"
+	// FormatJSONL is JSON Lines, one compact JSON object per line
+	FormatJSONL Format = "jsonl"
+	// FormatJSON is a single indented JSON array
+	FormatJSON Format = "json"
+	// FormatMarkdown is a Markdown task list, "- [ ] title"
+	FormatMarkdown Format = "markdown"
+	// FormatTodoTxt is the todo.txt format, see writeTodoTxt
+	FormatTodoTxt Format = "todotxt"
+)
+
+// Formats lists every Format, in the order they are shown in help text
+var Formats = []Format{FormatTable, FormatCSV, FormatJSONL, FormatJSON, FormatMarkdown, FormatTodoTxt}
+
+// csvHeader is the header row of FormatCSV.  The columns are the JSON
+// field names, in the same order as the ToDoItem fields.
+var csvHeader = []string{
+	"id", "title", "done", "due", "repeat", "priority", "tags", "notes", "assignee",
+	"list", "parent", "blocked_by", "created_at", "updated_at", "completed_at",
+}
+
+// ParseFormat returns the Format with the given name.  "md" is accepted
+// for FormatMarkdown and "todo.txt" for FormatTodoTxt.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "md":
+		return FormatMarkdown, nil
+	case "todo.txt":
+		return FormatTodoTxt, nil
+	}
+	for _, format := range Formats {
+		if string(format) == name {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q", name)
+}
+
+// WriteItems writes items to w in the given format, in the order given
+func WriteItems(w io.Writer, format Format, items []ToDoItem) error {
+	switch format {
+	case FormatTable:
+		return writeTable(w, items)
+	case FormatCSV:
+		return writeCSV(w, items)
+	case FormatJSONL:
+		return writeJSONL(w, items)
+	case FormatJSON:
+		return writeJSON(w, items)
+	case FormatMarkdown:
+		return writeMarkdown(w, items, nil)
+	case FormatTodoTxt:
+		return writeTodoTxt(w, items)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+func writeTable(w io.Writer, items []ToDoItem) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDONE\tPRI\tDUE\tREPEAT\tLIST\tTAGS\tASSIGNEE\tBLOCKED BY\tTITLE")
+	for _, item := range items {
+		done := "[ ]"
+		if item.IsDone {
+			done = "[x]"
+		}
+		priority := ""
+		if item.Priority != 0 {
+			priority = strconv.Itoa(item.Priority)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			item.Id, done, priority, formatDate(item.DueDate), item.Repeat, item.List,
+			strings.Join(item.Tags, ","), item.Assignee, joinIds(item.BlockedBy), item.Title)
+	}
+	return tw.Flush()
+}
+
+// WriteTree is WriteItems with the items in tree order, see TreeOrder.
+// The table and Markdown formats also indent subtasks under their
+// parents.
+func WriteTree(w io.Writer, format Format, items []ToDoItem) error {
+	ordered, depths := TreeOrder(items)
+
+	switch format {
+	case FormatTable:
+		for i := range ordered {
+			ordered[i].Title = strings.Repeat("  ", depths[i]) + ordered[i].Title
+		}
+		return writeTable(w, ordered)
+	case FormatMarkdown:
+		return writeMarkdown(w, ordered, depths)
+	default:
+		return WriteItems(w, format, ordered)
+	}
+}
+
+func writeCSV(w io.Writer, items []ToDoItem) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, item := range items {
+		cw.Write([]string{
+			strconv.Itoa(item.Id),
+			item.Title,
+			strconv.FormatBool(item.IsDone),
+			formatTime(item.DueDate),
+			string(item.Repeat),
+			strconv.Itoa(item.Priority),
+			strings.Join(item.Tags, ","),
+			item.Notes,
+			item.Assignee,
+			item.List,
+			formatId(item.ParentId),
+			joinIds(item.BlockedBy),
+			formatTime(item.CreatedAt),
+			formatTime(item.UpdatedAt),
+			formatTime(item.CompletedAt),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSONL(w io.Writer, items []ToDoItem) error {
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, items []ToDoItem) error {
+	//An empty list should still be a valid array, not "null"
+	if items == nil {
+		items = []ToDoItem{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
+
+// writeMarkdown writes a task list, with each item indented by its
+// depth if depths is given, so subtasks nest under their parents
+func writeMarkdown(w io.Writer, items []ToDoItem, depths []int) error {
+	for i, item := range items {
+		box := "[ ]"
+		if item.IsDone {
+			box = "[x]"
+		}
+		indent := ""
+		if depths != nil {
+			indent = strings.Repeat("  ", depths[i])
+		}
+		_, err := fmt.Fprintf(w, "%s- %s %s\n", indent, box, item.Title)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTodoTxt writes one todo.txt line per item, see
+// http://todotxt.org.  The line is laid out as
+//
+//	x <completed> (A) <created> <title> +tag due:<date> assignee:<who> list:<list> id:<id>
+//
+// Tags go out as +projects, except tags already starting with "@",
+// which are contexts.  Priorities 1 to 26 become (Z) to (A), so higher
+// is still more urgent; done items keep theirs as a pri:A tag, as
+// todo.txt has no place for it.  Notes can't be written at all.
+func writeTodoTxt(w io.Writer, items []ToDoItem) error {
+	for _, item := range items {
+		_, err := fmt.Fprintln(w, todoTxtLine(item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func todoTxtLine(item ToDoItem) string {
+	var words []string
+
+	priority := ""
+	if item.Priority > 0 {
+		priority = string(rune('A' + 26 - min(item.Priority, 26)))
+	}
+
+	if item.IsDone {
+		words = append(words, "x")
+		//todo.txt only allows a creation date after a completion date
+		if item.CompletedAt != nil {
+			words = append(words, formatDate(item.CompletedAt))
+			if item.CreatedAt != nil {
+				words = append(words, formatDate(item.CreatedAt))
+			}
+		}
+	} else {
+		if priority != "" {
+			words = append(words, "("+priority+")")
+		}
+		if item.CreatedAt != nil {
+			words = append(words, formatDate(item.CreatedAt))
+		}
+	}
+
+	if item.Title != "" {
+		words = append(words, item.Title)
+	}
+	for _, tag := range item.Tags {
+		if strings.HasPrefix(tag, "@") {
+			words = append(words, tag)
+		} else {
+			words = append(words, "+"+tag)
+		}
+	}
+	if item.DueDate != nil {
+		words = append(words, "due:"+formatDate(item.DueDate))
+	}
+	if item.Repeat != "" {
+		words = append(words, "rec:"+string(item.Repeat))
+	}
+	if item.IsDone && priority != "" {
+		words = append(words, "pri:"+priority)
+	}
+	if item.Assignee != "" {
+		words = append(words, "assignee:"+item.Assignee)
+	}
+	if item.List != "" {
+		words = append(words, "list:"+item.List)
+	}
+	if item.ParentId != 0 {
+		words = append(words, "parent:"+strconv.Itoa(item.ParentId))
+	}
+	if len(item.BlockedBy) > 0 {
+		words = append(words, "blocked_by:"+joinIds(item.BlockedBy))
+	}
+	if item.Id != 0 {
+		words = append(words, "id:"+strconv.Itoa(item.Id))
+	}
+
+	return strings.Join(words, " ")
+}
+
+// formatId shows an optional item id, which is 0 when it is missing
+func formatId(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
+
+// formatDate shows just the date part of an optional time
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// formatTime shows an optional time in RFC 3339, the same as the JSON
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
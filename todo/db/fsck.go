@@ -0,0 +1,696 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrCorrupt is returned when loading a database file that can't be
+// parsed.  Repair can find out what is wrong with it and fix it.
+var ErrCorrupt = errors.New("database file is corrupt")
+
+// Fix says what Repair does about a Problem
+type Fix string
+
+const (
+	// FixRenumber gives an item whose id is taken a new id
+	FixRenumber Fix = "renumber"
+
+	// FixQuarantine moves a record that isn't a valid item out of the
+	// database into the quarantine file next to it
+	FixQuarantine Fix = "quarantine"
+
+	// FixClear drops a reference to an item that doesn't exist
+	FixClear Fix = "clear"
+
+	// FixRewrite writes the file's header again with the right values
+	FixRewrite Fix = "rewrite"
+
+	// FixRestore replaces the whole file with the newest valid backup
+	FixRestore Fix = "restore"
+
+	// FixNone means the problem can't be fixed automatically
+	FixNone Fix = "none"
+)
+
+// Problem is one thing wrong with a database file.  Location is where
+// in the file it is, as a line and column, and Id the item it is with,
+// if it is known.
+type Problem struct {
+	Location string
+	Id       int
+	Message  string
+	Fix      Fix
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.Location, p.Message, p.Fix)
+}
+
+// RepairReport is what Repair found and did, or would do
+type RepairReport struct {
+	// Problems is everything wrong with the file, in the order found
+	Problems []Problem
+
+	// Backup is the name of the backup the file is restored from, if
+	// it can't be read at all
+	Backup string
+
+	// Quarantine is the file records that aren't valid items are moved
+	// to, if there are any
+	Quarantine string
+
+	// Repaired is set once the fixed database has been saved
+	Repaired bool
+}
+
+// quarantined is one line of the quarantine file: a record taken out
+// of the database by Repair, and why.  A file that couldn't be read at
+// all is kept whole, as Text.
+type quarantined struct {
+	Time     time.Time       `json:"time"`
+	Location string          `json:"location"`
+	Problem  string          `json:"problem"`
+	Record   json.RawMessage `json:"record,omitempty"`
+	Text     string          `json:"text,omitempty"`
+}
+
+// fsckResult is what checkFile found in a database file, along with
+// the contents of the file once its problems are fixed.  params is how
+// the fixed file is encrypted, nil if it isn't, and badJournal the
+// numbers of the journal lines that can't be read.
+type fsckResult struct {
+	problems   []Problem
+	contents   Contents
+	quarantine []quarantined
+	unreadable bool
+	backup     string
+	params     *kdfParams
+	badJournal []int
+}
+
+// Repair checks the database file for everything that would stop it
+// loading or leave it inconsistent, such as bad JSON, duplicate ids,
+// records with missing fields or values of the wrong type and
+// references to items that don't exist or parents that loop, and fixes
+// what it can.  Items
+// whose id is taken are renumbered, records that aren't valid items
+// are moved to "<db file>.quarantine" and a file that can't be read at
+// all is replaced by the newest snapshot or backup that can.  Lines of
+// the undo journal that can't be read are quarantined too.  An
+// encrypted file is checked once it is decrypted, and one that can't be
+// decrypted is only replaced by an encrypted backup that can, as that
+// is what shows the passphrase is the right one.
+// Preconditions:   (1) The DB must be kept in a JSON file
+//
+// Postconditions:
+//
+//	 (1) The problems found will be returned, with what is done
+//			about each of them
+//		(2) If preview is set, or there are no problems, nothing
+//			will be changed
+//		(3) If any problem can't be fixed, nothing will be changed
+//			and an error will be returned along with the report
+//		(4) Otherwise the fixed database will be saved and the
+//			records taken out of it added to the quarantine file
+//		(5) The repair is snapshotted and journaled like any other
+//			write, so it can be undone
+func (t *ToDo) Repair(preview bool) (RepairReport, error) {
+	return t.RepairContext(context.Background(), preview)
+}
+
+// RepairContext is Repair, giving up with ctx's error if ctx is done
+// before the repaired database is saved
+func (t *ToDo) RepairContext(ctx context.Context, preview bool) (RepairReport, error) {
+	store, ok := t.store.(*JsonStore)
+	if !ok {
+		return RepairReport{}, errors.New("Repair: only JSON file databases can be checked")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return RepairReport{}, fmt.Errorf("Repair: %w", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	unlock, err := lockStore(ctx, store)
+	if err != nil {
+		return RepairReport{}, fmt.Errorf("Repair: %w", err)
+	}
+	defer unlock()
+
+	raw, err := os.ReadFile(store.FileName())
+	if err != nil {
+		return RepairReport{}, fmt.Errorf("Repair: %w", err)
+	}
+	keys := store.sealing().keys
+	data, params, err := keys.open(raw)
+	var result fsckResult
+	switch {
+	case errors.Is(err, ErrNoPassphrase):
+		return RepairReport{}, fmt.Errorf("Repair: %w", err)
+	case err != nil:
+		result = fsckResult{
+			problems:   []Problem{{Location: "file", Message: "can't be decrypted: " + err.Error(), Fix: FixRestore}},
+			unreadable: true,
+		}
+		result = t.restoreResult(result, raw, keys, errors.Is(err, ErrBadPassphrase))
+	default:
+		result = checkFile(store.FileName(), data)
+		result.params = params
+		if result.unreadable {
+			result = t.restoreResult(result, data, keys, false)
+		}
+		err = t.checkJournal(store.sealing(), &result)
+		if err != nil {
+			return RepairReport{}, fmt.Errorf("Repair: error reading journal: %w", err)
+		}
+	}
+
+	report := RepairReport{Problems: result.problems, Backup: result.backup}
+	if len(result.quarantine) > 0 {
+		report.Quarantine = store.FileName() + ".quarantine"
+	}
+
+	unfixable := 0
+	for _, problem := range result.problems {
+		if problem.Fix == FixNone {
+			unfixable++
+		}
+	}
+	if unfixable > 0 {
+		return report, fmt.Errorf("Repair: %d of the problems can't be fixed automatically", unfixable)
+	}
+	if preview || len(result.problems) == 0 {
+		return report, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("Repair: %w", err)
+	}
+
+	//The repair is a write like any other, so it is snapshotted and
+	//journaled and can be undone.  What it changes is the items as
+	//they load now, or nothing if the file can't be loaded.
+	before := Contents{Items: make(DbMap)}
+	if t.loadDB(ctx) == nil {
+		before = Contents{LastId: t.lastId, Items: maps.Clone(t.toDoMap)}
+	}
+
+	//The records are kept safe before the file they came from is
+	//replaced
+	to := sealing{keys: keys, params: result.params}
+	err = appendQuarantine(to, report.Quarantine, result.quarantine)
+	if err != nil {
+		return report, fmt.Errorf("Repair: error writing quarantine file: %w", err)
+	}
+	err = t.dropJournalLines(result.badJournal)
+	if err != nil {
+		return report, fmt.Errorf("Repair: error rewriting journal: %w", err)
+	}
+	store.setSealing(to)
+	t.toDoMap, t.lastId = result.contents.Items, result.contents.LastId
+	_, err = t.commitDB(ctx, &JournalEntry{Op: "repair"}, before)
+	if err != nil {
+		t.version = 0
+		return report, fmt.Errorf("Repair: %w", err)
+	}
+
+	report.Repaired = true
+	return report, nil
+}
+
+// restoreResult turns the result of checking a file that couldn't be
+// read into one that restores the newest valid backup, if there is
+// one.  The unreadable file is quarantined whole.  Backups are opened
+// with keys, and if encryptedOnly is set, only encrypted ones are
+// used.  The restored file is encrypted if the file or the backup was.
+func (t *ToDo) restoreResult(result fsckResult, data []byte, keys *keyring, encryptedOnly bool) fsckResult {
+	var backups []Snapshot
+	snapshots, err := t.listSnapshots()
+	if err == nil {
+		backups = append(backups, snapshots...)
+	}
+	if backup, err := t.BackupFile(); err == nil {
+		backups = append(backups, backup)
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+
+	whole := quarantined{Time: timeNow(), Location: result.problems[0].Location,
+		Problem: result.problems[0].Message, Text: string(data)}
+	for _, backup := range backups {
+		data, err := os.ReadFile(backup.path)
+		if err != nil {
+			continue
+		}
+		data, params, err := keys.open(data)
+		if err != nil || (encryptedOnly && params == nil) {
+			continue
+		}
+		if result.params != nil {
+			params = result.params
+		}
+		restored := checkFile(backup.path, data)
+		if restored.unreadable || slices.ContainsFunc(restored.problems, func(p Problem) bool { return p.Fix == FixNone }) {
+			continue
+		}
+
+		//Problems in the backup are fixed on the way in too
+		problems := result.problems
+		for _, problem := range restored.problems {
+			problem.Location = backup.Name + " " + problem.Location
+			problems = append(problems, problem)
+		}
+		quarantine := []quarantined{whole}
+		for _, record := range restored.quarantine {
+			record.Location = backup.Name + " " + record.Location
+			quarantine = append(quarantine, record)
+		}
+		return fsckResult{
+			problems:   problems,
+			contents:   restored.contents,
+			quarantine: quarantine,
+			unreadable: true,
+			backup:     backup.Name,
+			params:     params,
+		}
+	}
+
+	result.problems[0].Message += "; there is no valid backup to restore"
+	result.problems[0].Fix = FixNone
+	result.quarantine = []quarantined{whole}
+	return result
+}
+
+// checkJournal adds the journal lines that can't be read to result, to
+// be quarantined
+func (t *ToDo) checkJournal(sealing sealing, result *fsckResult) error {
+	return t.scanJournal(sealing, func(line int, raw []byte, entry JournalEntry, err error) {
+		if err == nil {
+			return
+		}
+		where := fmt.Sprintf("journal line %d", line)
+		message := fmt.Sprintf("can't be read: %v", err)
+		result.problems = append(result.problems, Problem{Location: where, Message: message, Fix: FixQuarantine})
+		result.quarantine = append(result.quarantine, quarantined{Time: timeNow(), Location: where, Problem: message, Text: string(raw)})
+		result.badJournal = append(result.badJournal, line)
+	})
+}
+
+// dropJournalLines writes the journal again without the lines numbered
+func (t *ToDo) dropJournalLines(numbers []int) error {
+	if len(numbers) == 0 {
+		return nil
+	}
+	fileName := t.journalFileName()
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	var kept bytes.Buffer
+	for i, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 || slices.Contains(numbers, i+1) {
+			continue
+		}
+		kept.Write(line)
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			kept.WriteByte('\n')
+		}
+	}
+	return writeFileAtomic(fileName, kept.Bytes(), info.Mode().Perm())
+}
+
+// appendQuarantine adds records to the quarantine file, one JSON object
+// per line, each encrypted on its own if the database is
+func appendQuarantine(sealing sealing, fileName string, records []quarantined) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err == nil {
+			line, err = sealing.seal(line)
+		}
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// record is an element of the items array of a database file, and
+// where in the file it starts
+type record struct {
+	offset int64
+	raw    json.RawMessage
+}
+
+// checkFile checks the raw contents of a database file of any schema
+// version.  It walks the file token by token rather than unmarshaling
+// it, so each record's problems can be placed in the file and one bad
+// record doesn't stop the rest being read.
+func checkFile(fileName string, data []byte) fsckResult {
+	var result fsckResult
+	unreadable := func(offset int64, message string) fsckResult {
+		result.problems = []Problem{{Location: position(data, offset), Message: message, Fix: FixRestore}}
+		result.unreadable = true
+		return result
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	fail := func(err error) fsckResult {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return unreadable(syntaxErr.Offset-1, "bad JSON: "+syntaxErr.Error())
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return unreadable(int64(len(data)), "the file ends too soon")
+		}
+		return unreadable(decoder.InputOffset(), err.Error())
+	}
+
+	start, err := decoder.Token()
+	if errors.Is(err, io.EOF) {
+		return unreadable(0, "the file is empty")
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	var records []record
+	header := map[string]json.RawMessage{}
+	readItems := func() error {
+		for decoder.More() {
+			offset := skipSeparators(data, decoder.InputOffset())
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return err
+			}
+			records = append(records, record{offset, raw})
+		}
+		_, err := decoder.Token()
+		return err
+	}
+
+	//Version 1 files are just the items array
+	layout := SchemaVersion
+	switch start {
+	case json.Delim('['):
+		if err := readItems(); err != nil {
+			return fail(err)
+		}
+		layout = 1
+		header["version"] = json.RawMessage("1")
+	case json.Delim('{'):
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return fail(err)
+			}
+			if key != "items" {
+				var raw json.RawMessage
+				if err := decoder.Decode(&raw); err != nil {
+					return fail(err)
+				}
+				header[key.(string)] = raw
+				continue
+			}
+
+			offset := skipSeparators(data, decoder.InputOffset())
+			items, err := decoder.Token()
+			if err != nil {
+				return fail(err)
+			}
+			if items == nil {
+				continue
+			}
+			if items != json.Delim('[') {
+				return unreadable(offset, "items is not a list")
+			}
+			if err := readItems(); err != nil {
+				return fail(err)
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return fail(err)
+		}
+	default:
+		return unreadable(0, "the file is not a JSON object")
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return unreadable(skipSeparators(data, decoder.InputOffset()), "there is more after the end of the database")
+	}
+
+	lastId, ok := checkHeader(fileName, data, layout, header, &result)
+	if ok {
+		checkItems(data, records, lastId, layout == 1, &result)
+	}
+	return result
+}
+
+// checkHeader checks the version and last_id of a database file laid
+// out as schema version layout, and returns the id high-water mark it
+// holds.  It returns false if the file is too new for its items to be
+// checked.
+func checkHeader(fileName string, data []byte, layout int, header map[string]json.RawMessage, result *fsckResult) (int, bool) {
+	start := position(data, 0)
+	var version int
+	raw, found := header["version"]
+	switch err := json.Unmarshal(raw, &version); {
+	case !found:
+		result.problems = append(result.problems, Problem{Location: start,
+			Message: "the file has no schema version", Fix: FixRewrite})
+	case err != nil || version < 1:
+		result.problems = append(result.problems, Problem{Location: start,
+			Message: fmt.Sprintf("schema version %s is not a version", raw), Fix: FixRewrite})
+	case version > SchemaVersion:
+		result.problems = append(result.problems, Problem{Location: start,
+			Message: fmt.Sprintf("schema version %d is newer than the supported version %d", version, SchemaVersion),
+			Fix:     FixNone})
+		return 0, false
+	case version != layout:
+		result.problems = append(result.problems, Problem{Location: start,
+			Message: fmt.Sprintf("the file says it is schema version %d but is laid out as version %d", version, layout),
+			Fix:     FixRewrite})
+	}
+
+	var lastId int
+	if layout == 1 {
+		meta, err := os.ReadFile(fileName + ".meta")
+		if err == nil {
+			var m dbMeta
+			if json.Unmarshal(meta, &m) == nil {
+				lastId = m.LastId
+			}
+		}
+	} else if raw, found := header["last_id"]; found {
+		if err := json.Unmarshal(raw, &lastId); err != nil || lastId < 0 {
+			lastId = 0
+			result.problems = append(result.problems, Problem{Location: start,
+				Message: fmt.Sprintf("last_id %s is not an id", raw), Fix: FixRewrite})
+		}
+	}
+	return lastId, true
+}
+
+// checkItems checks every record in the items array, then the
+// references between the items that are left, filling in the problems
+// and the fixed contents of result.  Version 1 files kept no last_id
+// in the file, so a missing one isn't a problem for them.
+func checkItems(data []byte, records []record, lastId int, v1 bool, result *fsckResult) {
+	items := make([]ToDoItem, len(records))
+	valid := make([]bool, len(records))
+	maxId, highestUsed := 0, lastId
+	for i, rec := range records {
+		item, err := checkRecord(rec.raw)
+		if item.Id > 0 {
+			highestUsed = max(highestUsed, item.Id)
+		}
+		if err != nil {
+			where := position(data, rec.offset)
+			message := fmt.Sprintf("items[%d] %v", i, err)
+			result.problems = append(result.problems, Problem{Location: where, Id: item.Id, Message: message, Fix: FixQuarantine})
+			result.quarantine = append(result.quarantine, quarantined{Time: timeNow(), Location: where, Problem: message, Record: rec.raw})
+			continue
+		}
+		items[i], valid[i] = item, true
+		maxId = max(maxId, item.Id)
+	}
+
+	if lastId < maxId && !v1 {
+		result.problems = append(result.problems, Problem{Location: position(data, 0),
+			Message: fmt.Sprintf("last_id %d is lower than the highest id, %d", lastId, maxId), Fix: FixRewrite})
+	}
+
+	//Loading a file keeps the last of the items with the same id, so
+	//that is the one that has been shown all along.  The others get
+	//ids no one has seen yet, not even on the records quarantined.
+	lastWithId := map[int]int{}
+	for i := range records {
+		if valid[i] {
+			lastWithId[items[i].Id] = i
+		}
+	}
+	result.contents = Contents{LastId: highestUsed, Items: make(DbMap, len(records))}
+	offsets := make(map[int]int64, len(records))
+	for i := range records {
+		if !valid[i] {
+			continue
+		}
+		if first := lastWithId[items[i].Id]; first != i {
+			result.contents.LastId++
+			result.problems = append(result.problems, Problem{Location: position(data, records[i].offset), Id: items[i].Id,
+				Message: fmt.Sprintf("items[%d] has the same id as items[%d], it becomes item %d", i, first, result.contents.LastId),
+				Fix:     FixRenumber})
+			items[i].Id = result.contents.LastId
+		}
+		result.contents.Items[items[i].Id] = items[i]
+	}
+
+	for i := range records {
+		if !valid[i] {
+			continue
+		}
+		item := items[i]
+		where := position(data, records[i].offset)
+		problem := func(format string, args ...any) {
+			result.problems = append(result.problems, Problem{Location: where, Id: item.Id,
+				Message: fmt.Sprintf(format, args...), Fix: FixClear})
+		}
+
+		switch _, found := result.contents.Items[item.ParentId]; {
+		case item.ParentId == 0:
+		case item.ParentId == item.Id:
+			problem("item %d is its own parent", item.Id)
+			item.ParentId = 0
+		case !found:
+			problem("item %d's parent %d does not exist", item.Id, item.ParentId)
+			item.ParentId = 0
+		}
+
+		var blockers []int
+		for _, blocker := range item.BlockedBy {
+			_, found := result.contents.Items[blocker]
+			switch {
+			case blocker == item.Id:
+				problem("item %d is blocked by itself", item.Id)
+			case !found:
+				problem("item %d is blocked by %d, which does not exist", item.Id, blocker)
+			case slices.Contains(blockers, blocker):
+				problem("item %d is blocked by %d twice", item.Id, blocker)
+			default:
+				blockers = append(blockers, blocker)
+			}
+		}
+		if len(blockers) != len(item.BlockedBy) {
+			item.BlockedBy = blockers
+		}
+		result.contents.Items[item.Id] = item
+		offsets[item.Id] = records[i].offset
+	}
+
+	//Parents that lead back to the item make a loop no walk up the
+	//tree ever leaves.  The lowest id in each loop is found first, and
+	//becomes a top-level item, which breaks the loop.
+	ids := make([]int, 0, len(result.contents.Items))
+	for id := range result.contents.Items {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		var chain []int
+		seen := map[int]bool{}
+		for ancestor := result.contents.Items[id].ParentId; ancestor != 0 && !seen[ancestor]; ancestor = result.contents.Items[ancestor].ParentId {
+			if ancestor == id {
+				item := result.contents.Items[id]
+				result.problems = append(result.problems, Problem{Location: position(data, offsets[id]), Id: id,
+					Message: fmt.Sprintf("item %d is its own ancestor, through %s", id, joinIds(chain)), Fix: FixClear})
+				item.ParentId = 0
+				result.contents.Items[id] = item
+				break
+			}
+			seen[ancestor] = true
+			chain = append(chain, ancestor)
+		}
+	}
+}
+
+// checkRecord decodes one record of the items array, returning what
+// could be read of the item, and an error if it isn't a valid item
+func checkRecord(raw json.RawMessage) (ToDoItem, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return ToDoItem{}, fmt.Errorf("is not an object")
+	}
+
+	var item ToDoItem
+	err := json.Unmarshal(raw, &item)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return item, fmt.Errorf("field %s should be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	if err != nil {
+		return item, fmt.Errorf("has a bad value: %v", err)
+	}
+
+	for _, name := range []string{"id", "title"} {
+		if _, found := fields[name]; !found {
+			return item, fmt.Errorf("has no %s", name)
+		}
+	}
+	if item.Id <= 0 {
+		return item, fmt.Errorf("has id %d, ids start at 1", item.Id)
+	}
+	if strings.TrimSpace(item.Title) == "" {
+		return item, fmt.Errorf("has an empty title")
+	}
+	if _, err := ParseRecurrence(string(item.Repeat)); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// skipSeparators returns the offset of the next value in data at or
+// after offset, past any white space and commas
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,:", rune(data[offset])) {
+		offset++
+	}
+	return offset
+}
+
+// position returns the line and column of offset in data, counting
+// from 1
+func position(data []byte, offset int64) string {
+	offset = min(max(offset, 0), int64(len(data)))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
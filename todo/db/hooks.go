@@ -0,0 +1,241 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hooks are executables in a directory, see SetHooksDir, that are run
+// when items change, so a team can post to chat, tidy up titles or
+// enforce its conventions without changing todo.  Each is named for
+// when it runs and the kind of change it is run for:
+//
+//	pre-add  pre-update  pre-complete  pre-delete
+//	on-add   on-update   on-complete   on-delete
+//
+// A hook is run once for each item a write changes, with the item as
+// JSON on stdin, as it was if it was deleted, and these variables set:
+//
+//	TODO_HOOK  the name of the hook
+//	TODO_OP    the write that changed it, as the journal has it, such
+//	           as "add", "done" or "batch"
+//	TODO_ID    the id of the item
+//
+// Pre-hooks run before the write is saved, while the database is
+// locked, so they mustn't write to it.  If one exits non-zero the whole
+// write is cancelled with an error wrapping ErrVetoed.  If it prints
+// anything, that is a JSON object with the fields of the item to
+// change, which is how a hook changes an item.  The fields it leaves
+// out are kept, and the item keeps its id, and its timestamps and the
+// time worked on it are kept up to date as for any other write.
+//
+// On-hooks run once the write has been saved and the database
+// unlocked.  What they print goes to stderr, and if one fails that is
+// reported there too, as the change has already been made.
+//
+// Every write that changes items runs them, AddItem, UpdateItem,
+// DeleteItem and ChangeItemDoneStatus as well as the other methods,
+// such as Update, Undo and the bulk ones.  Restoring a snapshot or
+// backup puts back the database as it was and doesn't run them.
+
+// ErrVetoed is returned when a pre-hook stops a write
+var ErrVetoed = errors.New("vetoed by a hook")
+
+// hookTimeout is how long a hook may run before it is killed.  A
+// pre-hook that is killed for taking too long vetoes the write, one
+// killed because the write's context is done fails it with the
+// context's error.
+const hookTimeout = 30 * time.Second
+
+// hookNames is the name of the hooks for each kind of change, after
+// "pre-" or "on-"
+var hookNames = map[EventKind]string{
+	EventAdded:     "add",
+	EventUpdated:   "update",
+	EventCompleted: "complete",
+	EventDeleted:   "delete",
+}
+
+// SetHooksDir sets the directory hooks are run from, "" to run none.
+// A directory that doesn't exist has no hooks.
+func (t *ToDo) SetHooksDir(dir string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.hooksDir = dir
+}
+
+// findHooks returns the paths of the hooks in dir, by name.  Files that
+// aren't executable are left out, so a hook can be turned off with
+// chmod.
+func findHooks(dir string) map[string]string {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	hooks := make(map[string]string)
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		hooks[entry.Name()] = path
+	}
+	return hooks
+}
+
+// runPreHooks runs the pre-hooks for the changes a write has made to
+// t.toDoMap, putting in the items they print
+func (t *ToDo) runPreHooks(ctx context.Context, hooks map[string]string, op string, before DbMap) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	for _, event := range Diff(before, t.toDoMap) {
+		name := "pre-" + hookNames[event.Kind]
+		path, found := hooks[name]
+		if !found {
+			continue
+		}
+
+		var stdout, stderr bytes.Buffer
+		err := runHook(ctx, path, name, op, event, &stdout, &stderr)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			why := strings.TrimSpace(stderr.String())
+			if why == "" {
+				why = err.Error()
+			}
+			return fmt.Errorf("%w: %s for item %d: %s", ErrVetoed, name, event.Id, why)
+		}
+		if err != nil {
+			return fmt.Errorf("running %s: %w", name, err)
+		}
+
+		if event.Kind == EventDeleted || len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+			continue
+		}
+		item, err := t.hookItem(event.Item, stdout.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s for item %d: %w", name, event.Id, err)
+		}
+		if oldItem, found := before[item.Id]; found {
+			item = stampUpdate(oldItem, item)
+		} else {
+			item = stampNew(item)
+		}
+		t.toDoMap[item.Id] = item
+	}
+	return nil
+}
+
+// hookItem applies the fields a pre-hook printed to item, and checks
+// the result
+func (t *ToDo) hookItem(item ToDoItem, data []byte) (ToDoItem, error) {
+	//The fields are decoded onto a copy of item made through JSON, so
+	//it doesn't share its dates with the map
+	var changed ToDoItem
+	current, err := json.Marshal(item)
+	if err == nil {
+		err = json.Unmarshal(current, &changed)
+	}
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	err = json.Unmarshal(data, &changed)
+	if err != nil {
+		return ToDoItem{}, fmt.Errorf("printed something that isn't an item: %w", err)
+	}
+	if changed.Id == 0 {
+		changed.Id = item.Id
+	}
+	if changed.Id != item.Id {
+		return ToDoItem{}, fmt.Errorf("changed the id to %d", changed.Id)
+	}
+	if strings.TrimSpace(changed.Title) == "" {
+		return ToDoItem{}, errors.New("left the title empty")
+	}
+	if _, err := ParseRecurrence(string(changed.Repeat)); err != nil {
+		return ToDoItem{}, err
+	}
+	changed.List, err = ParseList(changed.List)
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	if err := checkParent(t.toDoMap, changed); err != nil {
+		return ToDoItem{}, err
+	}
+	if err := checkBlockers(t.toDoMap, changed); err != nil {
+		return ToDoItem{}, err
+	}
+
+	//A hook can't mark an item done while it is waiting on open items
+	//any more than the write could, so that is checked again with the
+	//item as the hook left it
+	if changed.IsDone && !item.IsDone {
+		oldItem := t.toDoMap[changed.Id]
+		t.toDoMap[changed.Id] = changed
+		err := checkBlocked(t.toDoMap, changed.Id)
+		t.toDoMap[changed.Id] = oldItem
+		if err != nil {
+			return ToDoItem{}, err
+		}
+	}
+	return changed, nil
+}
+
+// runOnHooks runs the on-hooks for the changes a write has saved
+func runOnHooks(hooks map[string]string, op string, changes []Change) {
+	if len(hooks) == 0 {
+		return
+	}
+
+	for _, change := range changes {
+		event := changeEvent(change)
+		name := "on-" + hookNames[event.Kind]
+		path, found := hooks[name]
+		if !found {
+			continue
+		}
+		err := runHook(context.Background(), path, name, op, event, os.Stderr, os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s for item %d failed: %v\n", name, event.Id, err)
+		}
+	}
+}
+
+// runHook runs the hook at path for event, killing it if ctx is done
+func runHook(ctx context.Context, path, name, op string, event Event, stdout, stderr io.Writer) error {
+	data, err := json.Marshal(event.Item)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), "TODO_HOOK="+name, "TODO_OP="+op, "TODO_ID="+strconv.Itoa(event.Id))
+	return cmd.Run()
+}
@@ -0,0 +1,501 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadItems reads items written in format, the reverse of WriteItems.
+// FormatTable is for people and can't be read back.  Items read from
+// formats that don't carry ids, like FormatMarkdown, have Id 0, which
+// ImportItems (and AddItem) take to mean "assign one".
+func ReadItems(r io.Reader, format Format) ([]ToDoItem, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatJSONL:
+		return readJSONL(r)
+	case FormatJSON:
+		return readJSON(r)
+	case FormatMarkdown:
+		return readMarkdown(r)
+	case FormatTodoTxt:
+		return readTodoTxt(r)
+	default:
+		return nil, fmt.Errorf("can't read items in %s format", format)
+	}
+}
+
+// readCSV reads a CSV file with a header row.  The columns are matched
+// to fields by the names in csvHeader and may come in any order; only
+// title is required, and columns with other names are ignored so a
+// spreadsheet can carry extra columns of its own.
+func readCSV(r io.Reader) ([]ToDoItem, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, found := columns["title"]; !found {
+		return nil, errors.New("CSV has no title column")
+	}
+
+	var items []ToDoItem
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		field := func(name string) string {
+			i, found := columns[name]
+			if !found || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		item := ToDoItem{
+			Title:    field("title"),
+			Repeat:   Recurrence(field("repeat")),
+			Tags:     splitTags(field("tags")),
+			Notes:    field("notes"),
+			Assignee: field("assignee"),
+			List:     field("list"),
+		}
+		if s := field("parent"); s != "" {
+			item.ParentId, err = strconv.Atoi(s)
+		}
+		if s := field("blocked_by"); s != "" && err == nil {
+			item.BlockedBy, err = splitIds(s)
+		}
+		if s := field("id"); s != "" && err == nil {
+			item.Id, err = strconv.Atoi(s)
+		}
+		if s := field("done"); s != "" && err == nil {
+			item.IsDone, err = strconv.ParseBool(s)
+		}
+		if s := field("priority"); s != "" && err == nil {
+			item.Priority, err = strconv.Atoi(s)
+		}
+		for _, date := range []struct {
+			name string
+			dst  **time.Time
+		}{
+			{"due", &item.DueDate},
+			{"created_at", &item.CreatedAt},
+			{"updated_at", &item.UpdatedAt},
+			{"completed_at", &item.CompletedAt},
+		} {
+			if err == nil {
+				*date.dst, err = parseTime(field(date.name))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item)
+	}
+}
+
+func readJSONL(r io.Reader) ([]ToDoItem, error) {
+	var items []ToDoItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var item ToDoItem
+		err := json.Unmarshal(scanner.Bytes(), &item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+func readJSON(r io.Reader) ([]ToDoItem, error) {
+	var items []ToDoItem
+	err := json.NewDecoder(r).Decode(&items)
+	if err == io.EOF {
+		return nil, nil
+	}
+	return items, err
+}
+
+// markdownTask matches a task list entry, "- [ ] title" or "* [x] title",
+// at any indent
+var markdownTask = regexp.MustCompile(`^\s*[-*+]\s+\[([ xX])\]\s+(.*)$`)
+
+// readMarkdown picks the task list entries out of a Markdown document,
+// such as a GitHub issue, and ignores everything else in it
+func readMarkdown(r io.Reader) ([]ToDoItem, error) {
+	var items []ToDoItem
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := markdownTask.FindStringSubmatch(scanner.Text())
+		if match == nil || strings.TrimSpace(match[2]) == "" {
+			continue
+		}
+		items = append(items, ToDoItem{
+			Title:  strings.TrimSpace(match[2]),
+			IsDone: match[1] != " ",
+		})
+	}
+	return items, scanner.Err()
+}
+
+// todoTxtPriority matches a todo.txt priority, "(A)" to "(Z)"
+var todoTxtPriority = regexp.MustCompile(`^\(([A-Z])\)$`)
+
+// readTodoTxt reads the lines written by writeTodoTxt, and todo.txt
+// files written by other tools.  Words that look like key:value pairs
+// with keys we don't know are left in the title, so URLs survive.
+func readTodoTxt(r io.Reader) ([]ToDoItem, error) {
+	var items []ToDoItem
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+
+		item, err := parseTodoTxtLine(words)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+func parseTodoTxtLine(words []string) (ToDoItem, error) {
+	var item ToDoItem
+
+	//date takes a leading date off words, if there is one
+	date := func() *time.Time {
+		if len(words) == 0 {
+			return nil
+		}
+		t, err := time.Parse("2006-01-02", words[0])
+		if err != nil {
+			return nil
+		}
+		words = words[1:]
+		return &t
+	}
+
+	if words[0] == "x" {
+		item.IsDone = true
+		words = words[1:]
+		item.CompletedAt = date()
+	}
+	if len(words) > 0 {
+		if match := todoTxtPriority.FindStringSubmatch(words[0]); match != nil {
+			item.Priority = priorityOfLetter(match[1])
+			words = words[1:]
+		}
+	}
+	item.CreatedAt = date()
+
+	var title []string
+	for _, word := range words {
+		key, value, _ := strings.Cut(word, ":")
+		switch {
+		case len(word) > 1 && word[0] == '+':
+			item.Tags = append(item.Tags, word[1:])
+		case len(word) > 1 && word[0] == '@':
+			item.Tags = append(item.Tags, word)
+		case key == "due" && value != "":
+			due, err := parseTime(value)
+			if err != nil {
+				return ToDoItem{}, err
+			}
+			item.DueDate = due
+		case key == "rec" && value != "":
+			item.Repeat = Recurrence(value)
+		case key == "pri" && len(value) == 1 && value[0] >= 'A' && value[0] <= 'Z':
+			item.Priority = priorityOfLetter(value)
+		case key == "assignee" && value != "":
+			item.Assignee = value
+		case key == "list" && value != "":
+			item.List = value
+		case key == "parent" && value != "":
+			parent, err := strconv.Atoi(value)
+			if err != nil {
+				return ToDoItem{}, fmt.Errorf("%q is not an item id", value)
+			}
+			item.ParentId = parent
+		case key == "blocked_by" && value != "":
+			blockers, err := splitIds(value)
+			if err != nil {
+				return ToDoItem{}, err
+			}
+			item.BlockedBy = blockers
+		case key == "id" && value != "":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return ToDoItem{}, fmt.Errorf("%q is not an item id", value)
+			}
+			item.Id = id
+		default:
+			title = append(title, word)
+		}
+	}
+	item.Title = strings.Join(title, " ")
+
+	return item, nil
+}
+
+// priorityOfLetter is the reverse of the mapping in writeTodoTxt
+func priorityOfLetter(letter string) int {
+	return 26 - int(letter[0]-'A')
+}
+
+// parseTime accepts a plain date (taken as midnight UTC) or a full RFC
+// 3339 timestamp.  An empty string means no time.
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a date, use YYYY-MM-DD or RFC 3339", s)
+	}
+
+	t = t.UTC()
+	return &t, nil
+}
+
+// splitTags splits a comma separated list of tags, dropping blanks
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// splitIds splits a comma separated list of item ids, dropping blanks
+func splitIds(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an item id", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//------------------------------------------------------------
+// IMPORTING INTO THE DB
+//------------------------------------------------------------
+
+// Collision says what ImportItems does with an imported item whose id
+// is already taken
+type Collision string
+
+const (
+	// CollisionSkip leaves the existing item alone and drops the
+	// imported one
+	CollisionSkip Collision = "skip"
+	// CollisionOverwrite replaces the existing item with the imported one
+	CollisionOverwrite Collision = "overwrite"
+	// CollisionRenumber adds the imported item under a new id
+	CollisionRenumber Collision = "renumber"
+)
+
+// ParseCollision returns the Collision rule with the given name
+func ParseCollision(name string) (Collision, error) {
+	switch c := Collision(name); c {
+	case CollisionSkip, CollisionOverwrite, CollisionRenumber:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown collision rule %q, use skip, overwrite or renumber", name)
+}
+
+// ImportAction is what ImportItems did, or in a preview would do, with
+// one imported item.  Action is "add", "skip", "overwrite" or
+// "renumber"; Item is the item as stored, under its final id, and
+// FromId the id it had in the import.
+type ImportAction struct {
+	Action string
+	FromId int
+	Item   ToDoItem
+}
+
+// ImportItems adds items to the DB in one write, so either all of them
+// are imported or, on error, none are.  Items with Id 0 get the next
+// free id; items whose id is taken, by an existing item or one earlier
+// in the import, are handled according to onCollision.  Timestamps are
+// filled in as AddItem and UpdateItem would.  Subtasks of an item that
+// is renumbered move with it, as do the items it blocks, and every
+// parent and blocker must exist once the
+// import is done.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) One ImportAction is returned per item, in the same order
+//		(2) If preview is set the DB file will not be modified
+//		(3) If there is an error, it will be returned
+func (t *ToDo) ImportItems(items []ToDoItem, onCollision Collision, preview bool) ([]ImportAction, error) {
+	return t.ImportItemsContext(context.Background(), items, onCollision, preview)
+}
+
+// ImportItemsContext is ImportItems, giving up with ctx's error if ctx
+// is done before the items are saved, or read for a preview
+func (t *ToDo) ImportItemsContext(ctx context.Context, items []ToDoItem, onCollision Collision, preview bool) ([]ImportAction, error) {
+	if _, err := ParseCollision(string(onCollision)); err != nil {
+		return nil, fmt.Errorf("ImportItems: %w", err)
+	}
+
+	var actions []ImportAction
+	plan := func() error {
+		//Work on a copy so a preview, or a failure halfway through,
+		//leaves the map matching what is stored
+		toDoMap := maps.Clone(t.toDoMap)
+		lastId := t.lastId
+
+		//Parents refer to ids in the import, so they follow items that
+		//are renumbered
+		renumbered := make(map[int]int)
+
+		actions = make([]ImportAction, 0, len(items))
+		for _, item := range items {
+			action := ImportAction{Action: "add", FromId: item.Id}
+			var err error
+			item.Repeat, err = ParseRecurrence(string(item.Repeat))
+			if err == nil {
+				item.List, err = ParseList(item.List)
+			}
+			if err != nil {
+				return fmt.Errorf("item %q: %w", item.Title, err)
+			}
+			if item.Id < 0 {
+				return &ItemError{Id: item.Id, Err: ErrBadId}
+			}
+			oldItem, found := toDoMap[item.Id]
+
+			switch {
+			case found && onCollision == CollisionSkip:
+				action.Action = "skip"
+				action.Item = item
+				actions = append(actions, action)
+				continue
+			case found && onCollision == CollisionOverwrite:
+				action.Action = "overwrite"
+				item = stampUpdate(oldItem, item)
+			default:
+				if found {
+					action.Action = "renumber"
+				}
+				if found || item.Id == 0 {
+					id, err := nextId(lastId)
+					if err != nil {
+						return err
+					}
+					if found {
+						renumbered[item.Id] = id
+					}
+					item.Id = id
+				}
+				item = stampNew(item)
+			}
+
+			toDoMap[item.Id] = item
+			lastId = max(lastId, item.Id)
+			action.Item = item
+			actions = append(actions, action)
+		}
+
+		for i, action := range actions {
+			if action.Action == "skip" {
+				continue
+			}
+			item := action.Item
+			if newId, found := renumbered[item.ParentId]; found {
+				item.ParentId = newId
+			}
+			if len(item.BlockedBy) > 0 {
+				blockers := make([]int, len(item.BlockedBy))
+				for j, id := range item.BlockedBy {
+					blockers[j] = id
+					if newId, found := renumbered[id]; found {
+						blockers[j] = newId
+					}
+				}
+				item.BlockedBy = blockers
+			}
+			toDoMap[item.Id] = item
+			actions[i].Item = item
+		}
+		for _, action := range actions {
+			if action.Action == "skip" {
+				continue
+			}
+			if err := checkParent(toDoMap, action.Item); err != nil {
+				return fmt.Errorf("item %q: %w", action.Item.Title, err)
+			}
+			if err := checkBlockers(toDoMap, action.Item); err != nil {
+				return fmt.Errorf("item %q: %w", action.Item.Title, err)
+			}
+		}
+
+		if !preview {
+			t.toDoMap = toDoMap
+			t.lastId = lastId
+		}
+		return nil
+	}
+
+	var err error
+	if preview {
+		err = t.viewContext(ctx, plan)
+	} else {
+		err = t.writeDB(ctx, &JournalEntry{Op: "import"}, plan)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ImportItems: %w", err)
+	}
+
+	return actions, nil
+}
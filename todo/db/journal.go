@@ -0,0 +1,444 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JournalEntry records one write to the DB: which operation it was and
+// every item it changed, with before and after images.  Entries are
+// appended to the journal file next to the DB file, one JSON object per
+// line, and never rewritten, other than to encrypt or decrypt them.
+//
+// Undo and Redo are writes too, and append entries of their own with
+// Undoes or Redoes set to the Seq of the entry they reverse or replay.
+type JournalEntry struct {
+	Seq     int       `json:"seq"`
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`
+	Undoes  int       `json:"undoes,omitempty"`
+	Redoes  int       `json:"redoes,omitempty"`
+	Changes []Change  `json:"changes"`
+}
+
+// Change is what happened to one item.  Before is nil for an item that
+// was added and After is nil for one that was deleted.
+type Change struct {
+	Id     int       `json:"id"`
+	Before *ToDoItem `json:"before,omitempty"`
+	After  *ToDoItem `json:"after,omitempty"`
+}
+
+// Fields returns the JSON names of the fields that differ between the
+// before and after images, in the order they appear in ToDoItem.  An
+// add or a delete differs in every field that is set.
+func (c Change) Fields() []string {
+	var before, after map[string]json.RawMessage
+	if c.Before != nil {
+		data, _ := json.Marshal(c.Before)
+		json.Unmarshal(data, &before)
+	}
+	if c.After != nil {
+		data, _ := json.Marshal(c.After)
+		json.Unmarshal(data, &after)
+	}
+
+	var fields []string
+	itemType := reflect.TypeOf(ToDoItem{})
+	for i := 0; i < itemType.NumField(); i++ {
+		name, _, _ := strings.Cut(itemType.Field(i).Tag.Get("json"), ",")
+		if string(before[name]) != string(after[name]) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// errNoJournal is returned by the journal functions when the store has
+// no file to keep a journal next to
+var errNoJournal = errors.New("the undo journal needs a file database")
+
+// journalFileName is where the journal is kept, or "" if the store
+// doesn't have one
+func (t *ToDo) journalFileName() string {
+	fileStore, ok := t.store.(FileStore)
+	if !ok {
+		return ""
+	}
+	return fileStore.FileName() + ".journal"
+}
+
+// Journal returns every entry in the journal, oldest first.  A DB that
+// has never been written to has an empty journal.
+// Preconditions:   (1) The DB must be kept in a file
+//
+// Postconditions:
+//
+//	 (1) The entries will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//		(3) The database file will not be modified
+func (t *ToDo) Journal() ([]JournalEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries, err := t.readJournal()
+	if err != nil {
+		return nil, fmt.Errorf("Journal: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Undo reverses the most recent write that hasn't been undone yet and
+// returns its journal entry.  Calling it again steps further back.
+// Preconditions:   (1) The DB must be kept in a file
+//
+//					(2) The items the write changed must not have
+//						been changed since, other than by writes that
+//						have been undone, or an error is returned
+//
+// Postconditions:
+//
+//	 (1) The items will be as they were before the write
+//		(2) An "undo" entry will be added to the journal
+//		(3) If there is an error, it will be returned
+func (t *ToDo) Undo() (JournalEntry, error) {
+	return t.UndoContext(context.Background())
+}
+
+// UndoContext is Undo, giving up with ctx's error if ctx is done
+// before the undo is saved
+func (t *ToDo) UndoContext(ctx context.Context) (JournalEntry, error) {
+	entry, err := t.stepJournal(ctx, true)
+	if err != nil {
+		return JournalEntry{}, fmt.Errorf("Undo: %w", err)
+	}
+
+	return entry, nil
+}
+
+// Redo replays the write most recently undone and returns its journal
+// entry.  Any new write, other than an undo, clears what can be redone.
+// Preconditions:   (1) The DB must be kept in a file
+//
+//					(2) The items the write changed must be as the
+//						undo left them, or an error is returned
+//
+// Postconditions:
+//
+//	 (1) The items will be as they were after the write
+//		(2) A "redo" entry will be added to the journal
+//		(3) If there is an error, it will be returned
+func (t *ToDo) Redo() (JournalEntry, error) {
+	return t.RedoContext(context.Background())
+}
+
+// RedoContext is Redo, giving up with ctx's error if ctx is done
+// before the redo is saved
+func (t *ToDo) RedoContext(ctx context.Context) (JournalEntry, error) {
+	entry, err := t.stepJournal(ctx, false)
+	if err != nil {
+		return JournalEntry{}, fmt.Errorf("Redo: %w", err)
+	}
+
+	return entry, nil
+}
+
+// stepJournal does the work of Undo (undo set) and Redo
+func (t *ToDo) stepJournal(ctx context.Context, undo bool) (JournalEntry, error) {
+	if t.journalFileName() == "" {
+		return JournalEntry{}, errNoJournal
+	}
+
+	op := "redo"
+	if undo {
+		op = "undo"
+	}
+
+	var target JournalEntry
+	entry := JournalEntry{Op: op}
+	err := t.writeDB(ctx, &entry, func() error {
+		entries, err := t.readJournal()
+		if err != nil {
+			return err
+		}
+		undoable, redoable := undoStacks(entries)
+
+		stack := redoable
+		if undo {
+			stack = undoable
+		}
+		if len(stack) == 0 {
+			return fmt.Errorf("nothing to %s", op)
+		}
+		target = stack[len(stack)-1]
+		if undo {
+			entry.Undoes = target.Seq
+		} else {
+			entry.Redoes = target.Seq
+		}
+
+		//Undoing sets every item back to its before image, redoing to
+		//its after image, but only if nothing else has touched any of
+		//them since.  All are checked before any is changed.
+		for _, change := range target.Changes {
+			from := change.Before
+			if undo {
+				from = change.After
+			}
+
+			current, found := t.toDoMap[change.Id]
+			if (from == nil && found) || (from != nil && (!found || !reflect.DeepEqual(current, *from))) {
+				return fmt.Errorf("item %d has changed since entry %d, can't %s it", change.Id, target.Seq, op)
+			}
+		}
+		for _, change := range target.Changes {
+			to := change.After
+			if undo {
+				to = change.Before
+			}
+
+			if to == nil {
+				delete(t.toDoMap, change.Id)
+			} else {
+				t.toDoMap[change.Id] = *to
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return JournalEntry{}, err
+	}
+
+	return target, nil
+}
+
+// undoStacks replays the journal to find which entries can be undone
+// and which redone, each with the next one to step to last.  A normal
+// write can be undone and clears the redo stack, an undo moves its
+// entry to the redo stack and a redo moves it back.
+func undoStacks(entries []JournalEntry) (undoable, redoable []JournalEntry) {
+	bySeq := make(map[int]JournalEntry, len(entries))
+	for _, entry := range entries {
+		bySeq[entry.Seq] = entry
+	}
+
+	for _, entry := range entries {
+		switch {
+		case entry.Undoes != 0:
+			if n := len(undoable); n > 0 && undoable[n-1].Seq == entry.Undoes {
+				undoable = undoable[:n-1]
+				redoable = append(redoable, bySeq[entry.Undoes])
+			}
+		case entry.Redoes != 0:
+			if n := len(redoable); n > 0 && redoable[n-1].Seq == entry.Redoes {
+				redoable = redoable[:n-1]
+				undoable = append(undoable, bySeq[entry.Redoes])
+			}
+		default:
+			undoable = append(undoable, entry)
+			redoable = nil
+		}
+	}
+	return undoable, redoable
+}
+
+// journalWrite fills in entry for a write that made changes and
+// appends it to the journal.  It must be called with the store lock
+// held, after the write has been saved.  Writes that change nothing,
+// and stores with no journal file, are not recorded.
+func (t *ToDo) journalWrite(entry *JournalEntry, changes []Change) error {
+	fileName := t.journalFileName()
+	if fileName == "" || len(changes) == 0 {
+		return nil
+	}
+	entry.Changes = changes
+
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	seq, err := t.lastSeq(f, info.Size())
+	if err != nil {
+		return err
+	}
+	entry.Seq = seq + 1
+	entry.Time = timeNow()
+
+	line, err := json.Marshal(entry)
+	if err == nil {
+		line, err = t.sealing().seal(line)
+	}
+	if err != nil {
+		return err
+	}
+
+	//A line cut short, by a crash or by hand, is ended first so it
+	//doesn't take the new entry down with it
+	if info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// lastSeq returns the Seq of the last entry in the journal f, which is
+// size bytes long, or 0 if it has none.  Only the last line is read, so
+// a write doesn't cost more as the journal grows, unless that line
+// can't be read, when the entries before it are.
+func (t *ToDo) lastSeq(f *os.File, size int64) (int, error) {
+	raw, err := lastLine(f, size)
+	if err != nil || len(raw) == 0 {
+		return 0, err
+	}
+
+	var entry JournalEntry
+	data, err := t.sealing().open(raw)
+	if errors.Is(err, ErrNoPassphrase) {
+		return 0, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &entry)
+	}
+	if err == nil {
+		return entry.Seq, nil
+	}
+
+	entries, err := t.readJournal()
+	if err != nil {
+		return 0, err
+	}
+	seq := 0
+	for _, entry := range entries {
+		seq = max(seq, entry.Seq)
+	}
+	return seq, nil
+}
+
+// lastLine returns the last line in f, which is size bytes long, that
+// isn't blank, reading back from the end a block at a time
+func lastLine(f io.ReaderAt, size int64) ([]byte, error) {
+	var blocks [][]byte
+	found := false
+	for end := size; end > 0; {
+		start := max(end-64<<10, 0)
+		block := make([]byte, end-start)
+		if _, err := f.ReadAt(block, start); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		end = start
+
+		if !found {
+			block = bytes.TrimRight(block, " \t\r\n")
+			found = len(block) > 0
+		}
+		if i := bytes.LastIndexByte(block, '\n'); i >= 0 {
+			blocks = append(blocks, block[i+1:])
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	slices.Reverse(blocks)
+	return bytes.TrimSpace(bytes.Join(blocks, nil)), nil
+}
+
+// readJournal reads every entry in the journal file, which doesn't
+// exist until the first write.  Lines that can't be read are left out,
+// so one bad line doesn't cost the rest of the history; Repair finds
+// them and moves them to the quarantine file.
+func (t *ToDo) readJournal() ([]JournalEntry, error) {
+	var entries []JournalEntry
+	err := t.scanJournal(t.sealing(), func(line int, raw []byte, entry JournalEntry, err error) {
+		if err == nil {
+			entries = append(entries, entry)
+		}
+	})
+	return entries, err
+}
+
+// scanJournal calls fn for each line in the journal that isn't blank,
+// with the entry on it or the error reading it.  raw is only good until
+// fn returns.  Without the passphrase of an encrypted journal none of
+// it can be read, so that is returned as an error instead.
+func (t *ToDo) scanJournal(sealing sealing, fn func(line int, raw []byte, entry JournalEntry, err error)) error {
+	fileName := t.journalFileName()
+	if fileName == "" {
+		return errNoJournal
+	}
+
+	f, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		data, err := sealing.open(scanner.Bytes())
+		if errors.Is(err, ErrNoPassphrase) {
+			return err
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		fn(line, scanner.Bytes(), entry, err)
+	}
+	return scanner.Err()
+}
+
+// diffItems lists the changes that turn before into after, by id
+func diffItems(before, after DbMap) []Change {
+	var changes []Change
+	for id := range before {
+		oldItem := before[id]
+		newItem, found := after[id]
+		switch {
+		case !found:
+			changes = append(changes, Change{Id: id, Before: &oldItem})
+		case !reflect.DeepEqual(oldItem, newItem):
+			changes = append(changes, Change{Id: id, Before: &oldItem, After: &newItem})
+		}
+	}
+	for id := range after {
+		if _, found := before[id]; !found {
+			newItem := after[id]
+			changes = append(changes, Change{Id: id, After: &newItem})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Id < changes[j].Id })
+	return changes
+}
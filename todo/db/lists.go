@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Items can be kept in named lists, such as "work" and "home", so one
+// database can take the place of several.  An item's List is the name
+// of the list it is in, "" for the default list, and a list exists for
+// as long as it has items.  Ids are shared by all the lists, so moving
+// an item to another list keeps its id.
+
+// DefaultList is the name the default list goes by, for items that
+// haven't been put in a list.  They are stored with List "".
+const DefaultList = "default"
+
+// ListCount is how many items one list has, open and done
+type ListCount struct {
+	Name string
+	Open int
+	Done int
+}
+
+// ParseList checks a list name, returning the List value items in it
+// have: the name itself, or "" for DefaultList.  Names are made of
+// letters, digits and "-", "_", "." and "/", so they can be given
+// as a comma separated list, and written to todo.txt.
+func ParseList(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == DefaultList {
+		return "", nil
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_./", r) {
+			return "", fmt.Errorf("bad list name %q, use letters, digits, -, _, . and /", name)
+		}
+	}
+	return name, nil
+}
+
+// ParseLists turns a comma separated list of list names into the
+// values a Query matches against, see ParseList
+func ParseLists(spec string) ([]string, error) {
+	var lists []string
+	for _, name := range strings.Split(spec, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		list, err := ParseList(name)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(lists, list) {
+			lists = append(lists, list)
+		}
+	}
+	return lists, nil
+}
+
+// ListName is the name of the list an item's List puts it in
+func ListName(list string) string {
+	if list == "" {
+		return DefaultList
+	}
+	return list
+}
+
+// Lists returns every list that has items, with its counts, the
+// default list first and the rest by name.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The lists will be returned, if any have items
+//		(2) If there is an error, it will be returned
+//		(3) The database file will not be modified
+func (t *ToDo) Lists() ([]ListCount, error) {
+	return t.ListsContext(context.Background())
+}
+
+// ListsContext is Lists, giving up with ctx's error if ctx is done
+// before the database is read
+func (t *ToDo) ListsContext(ctx context.Context) ([]ListCount, error) {
+	counts := make(map[string]*ListCount)
+	err := t.viewContext(ctx, func() error {
+		for _, item := range t.toDoMap {
+			count, found := counts[item.List]
+			if !found {
+				count = &ListCount{Name: ListName(item.List)}
+				counts[item.List] = count
+			}
+			if item.IsDone {
+				count.Done++
+			} else {
+				count.Open++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Lists: %w", err)
+	}
+
+	names := make([]string, 0, len(counts))
+	for list := range counts {
+		names = append(names, list)
+	}
+	sort.Strings(names)
+	lists := make([]ListCount, 0, len(names))
+	for _, list := range names {
+		lists = append(lists, *counts[list])
+	}
+	return lists, nil
+}
+
+// MoveItems moves items to another list, along with all their subtasks
+// if children is set, in a single write.  list is the List value to
+// give them, as ParseList returns it.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The items must exist in the DB
+//
+// Postconditions:
+//
+//	 (1) The ids of the items moved will be returned, in the order
+//			they were moved
+//		(2) Items already in the list are left alone
+//		(3) If there is an error, it will be returned and nothing
+//			will be moved
+func (t *ToDo) MoveItems(list string, ids []int, children bool) ([]int, error) {
+	return t.MoveItemsContext(context.Background(), list, ids, children)
+}
+
+// MoveItemsContext is MoveItems, giving up with ctx's error if ctx is
+// done before the move is saved
+func (t *ToDo) MoveItemsContext(ctx context.Context, list string, ids []int, children bool) ([]int, error) {
+	var moved []int
+	err := t.updateContext(ctx, "move", func(tx *Tx) error {
+		moved = moved[:0]
+		for _, id := range ids {
+			ids, err := tx.MoveItem(id, list, children)
+			if err != nil {
+				return err
+			}
+			moved = append(moved, ids...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("MoveItems: %w", err)
+	}
+
+	return moved, nil
+}
+
+// moveItem puts an item, and its subtasks if children is set, in list,
+// returning the ids of the items that weren't in it already
+func (t *ToDo) moveItem(id int, list string, children bool) ([]int, error) {
+	if _, found := t.toDoMap[id]; !found {
+		return nil, notFound(id)
+	}
+
+	ids := []int{id}
+	if children {
+		ids = append(ids, descendants(t.toDoMap, id)...)
+	}
+	var moved []int
+	for _, id := range ids {
+		item := t.toDoMap[id]
+		if item.List == list {
+			continue
+		}
+		oldItem := item
+		item.List = list
+		t.toDoMap[id] = stampUpdate(oldItem, item)
+		moved = append(moved, id)
+	}
+	return moved, nil
+}
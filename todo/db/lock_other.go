@@ -0,0 +1,11 @@
+//go:build !unix
+
+package db
+
+// lockFile is a no-op on platforms without flock(2).  Writers within
+// one process are still serialized by the ToDo mutex, and saveDB still
+// replaces the file atomically, but separate processes are not kept
+// from interleaving their load-modify-save cycles.
+func lockFile(lockFileName string) (func() error, error) {
+	return func() error { return nil }, nil
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on the named lock file,
+// creating it if needed, and blocks until the lock is granted.  The
+// returned function releases the lock.  We lock a separate file rather
+// than the database itself because saveDB replaces the database file
+// with a rename, which would leave other processes holding a lock on
+// the old, unlinked inode.
+func lockFile(lockFileName string) (func() error, error) {
+	f, err := os.OpenFile(lockFileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	unlock := func() error {
+		defer f.Close()
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}
+
+	return unlock, nil
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Query describes which items QueryItems returns and in what order.
+// The zero value matches every item and sorts them by id.
+type Query struct {
+	// Done, if set, keeps only items whose done flag matches it
+	Done *bool
+
+	// TitleContains keeps only items whose title contains it,
+	// ignoring case
+	TitleContains string
+
+	// TitleRegexp, if set, keeps only items whose title matches it
+	TitleRegexp *regexp.Regexp
+
+	// Lists, if set, keeps only items in one of these lists, given as
+	// the List values ParseLists returns
+	Lists []string
+
+	// CompletedBefore, if set, keeps only items that were completed
+	// before then.  Done items with no CompletedAt, which files from
+	// before it was recorded can have, never match.
+	CompletedBefore time.Time
+
+	// Sort lists the fields to sort on, most significant first.  See
+	// ParseSort for the field names.  Ties are always broken by id.
+	Sort []SortKey
+
+	// Offset skips that many items from the start of the sorted
+	// results, and Limit caps how many are returned, 0 for no cap
+	Offset int
+	Limit  int
+
+	// Upcoming, if set, adds the occurrences repeating items will have
+	// up to then, see Occurrences.  They are filtered, sorted and
+	// paged along with the stored items.
+	Upcoming time.Time
+}
+
+// SortKey is one field to sort on
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// itemLess compares two items on one field.  Items missing an optional
+// value (no due date, say) sort after the ones that have it, unless the
+// order is reversed.
+type itemLess func(a, b ToDoItem) bool
+
+// sortFields maps the field names accepted by ParseSort to the
+// comparisons that implement them.  The names are the JSON field names,
+// with the timestamps also available without their "_at".
+var sortFields = map[string]itemLess{
+	"id":       func(a, b ToDoItem) bool { return a.Id < b.Id },
+	"title":    func(a, b ToDoItem) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) },
+	"done":     func(a, b ToDoItem) bool { return !a.IsDone && b.IsDone },
+	"due":      func(a, b ToDoItem) bool { return timeLess(a.DueDate, b.DueDate) },
+	"priority": func(a, b ToDoItem) bool { return a.Priority < b.Priority },
+	"tags": func(a, b ToDoItem) bool {
+		return strings.Join(a.Tags, ",") < strings.Join(b.Tags, ",")
+	},
+	"notes":        func(a, b ToDoItem) bool { return a.Notes < b.Notes },
+	"assignee":     func(a, b ToDoItem) bool { return a.Assignee < b.Assignee },
+	"list":         func(a, b ToDoItem) bool { return a.List < b.List },
+	"created_at":   func(a, b ToDoItem) bool { return timeLess(a.CreatedAt, b.CreatedAt) },
+	"updated_at":   func(a, b ToDoItem) bool { return timeLess(a.UpdatedAt, b.UpdatedAt) },
+	"completed_at": func(a, b ToDoItem) bool { return timeLess(a.CompletedAt, b.CompletedAt) },
+}
+
+func init() {
+	sortFields["created"] = sortFields["created_at"]
+	sortFields["updated"] = sortFields["updated_at"]
+	sortFields["completed"] = sortFields["completed_at"]
+}
+
+// ParseSort turns a comma separated list of field names into sort keys.
+// A name starting with "-" sorts that field in descending order, so
+// "-priority,due" puts the most urgent items first and, among those,
+// the ones due soonest.  The fields are id, title, done, due, priority,
+// tags, notes, assignee, list, created_at, updated_at and completed_at.
+func ParseSort(spec string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key := SortKey{Field: field}
+		if strings.HasPrefix(field, "-") {
+			key = SortKey{Field: field[1:], Descending: true}
+		}
+		if _, found := sortFields[key.Field]; !found {
+			return nil, fmt.Errorf("can't sort on unknown field %q", key.Field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Matches reports whether item passes the query's filters.  Sorting
+// and paging don't come into it.
+func (q Query) Matches(item ToDoItem) bool {
+	if q.Done != nil && item.IsDone != *q.Done {
+		return false
+	}
+	if q.TitleContains != "" &&
+		!strings.Contains(strings.ToLower(item.Title), strings.ToLower(q.TitleContains)) {
+		return false
+	}
+	if q.TitleRegexp != nil && !q.TitleRegexp.MatchString(item.Title) {
+		return false
+	}
+	if len(q.Lists) > 0 && !slices.Contains(q.Lists, item.List) {
+		return false
+	}
+	if !q.CompletedBefore.IsZero() &&
+		(item.CompletedAt == nil || !item.CompletedAt.Before(q.CompletedBefore)) {
+		return false
+	}
+	return true
+}
+
+// Apply filters, sorts and pages items according to the query.  The
+// items slice is not modified.
+func (q Query) Apply(items []ToDoItem) ([]ToDoItem, error) {
+	var less []func(a, b ToDoItem) bool
+	for _, key := range q.Sort {
+		fieldLess, found := sortFields[key.Field]
+		if !found {
+			return nil, fmt.Errorf("can't sort on unknown field %q", key.Field)
+		}
+		if key.Descending {
+			less = append(less, func(a, b ToDoItem) bool { return fieldLess(b, a) })
+		} else {
+			less = append(less, fieldLess)
+		}
+	}
+	less = append(less, sortFields["id"])
+
+	var matched []ToDoItem
+	for _, item := range items {
+		if q.Matches(item) {
+			matched = append(matched, item)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, fieldLess := range less {
+			switch {
+			case fieldLess(matched[i], matched[j]):
+				return true
+			case fieldLess(matched[j], matched[i]):
+				return false
+			}
+		}
+		return false
+	})
+
+	if q.Offset > 0 {
+		matched = matched[min(q.Offset, len(matched)):]
+	}
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+
+	return matched, nil
+}
+
+// QueryItems returns the items selected by q, in the order it asks for.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The matching items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *ToDo) QueryItems(q Query) ([]ToDoItem, error) {
+	return t.QueryItemsContext(context.Background(), q)
+}
+
+// QueryItemsContext is QueryItems, giving up with ctx's error if ctx is
+// done before the database is read
+func (t *ToDo) QueryItemsContext(ctx context.Context, q Query) ([]ToDoItem, error) {
+	items, err := t.GetAllItemsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("QueryItems: %w", err)
+	}
+
+	if !q.Upcoming.IsZero() {
+		occurrences, err := Occurrences(items, q.Upcoming)
+		if err != nil {
+			return nil, fmt.Errorf("QueryItems: %w", err)
+		}
+		items = append(items, occurrences...)
+	}
+
+	items, err = q.Apply(items)
+	if err != nil {
+		return nil, fmt.Errorf("QueryItems: %w", err)
+	}
+
+	return items, nil
+}
+
+// timeLess orders optional times, with missing times last
+func timeLess(a, b *time.Time) bool {
+	switch {
+	case a == nil:
+		return false
+	case b == nil:
+		return true
+	default:
+		return a.Before(*b)
+	}
+}
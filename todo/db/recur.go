@@ -0,0 +1,221 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence is the rule a repeating item follows.  When an item with a
+// rule is marked done, the next occurrence is added as a new item with
+// the same fields, due on the next date the rule gives.  The rules are:
+//
+//	daily			every day
+//	every:3d, every:2w	every N days or weeks
+//	weekly			every week, on the weekday the item is due
+//	weekly:mon,thu		every week on the given weekdays
+//	monthly			every month, on the day of the month it is due
+//	monthly:15		every month on the given day, or the last day
+//				of months that are shorter
+//
+// The empty rule means the item doesn't repeat.  Like the other item
+// fields, rules are stored as given; check them with ParseRecurrence
+// first.  Marking an item with a bad rule done fails.
+type Recurrence string
+
+// weekdayNames are the names weekly rules use, indexed by time.Weekday
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// maxOccurrences caps how many dates Occurrences works out for one
+// item, so a daily rule asked about the next century stays cheap
+const maxOccurrences = 1000
+
+// ParseRecurrence checks a rule and returns it in its normal form, with
+// weekdays as three letter names in week order.  Full weekday names
+// are accepted too.
+func ParseRecurrence(rule string) (Recurrence, error) {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	kind, arg, hasArg := strings.Cut(rule, ":")
+
+	switch {
+	case rule == "":
+		return "", nil
+	case kind == "daily" && !hasArg:
+		return "daily", nil
+	case kind == "weekly" && !hasArg, kind == "monthly" && !hasArg:
+		return Recurrence(kind), nil
+	case kind == "every":
+		n, unit := arg, "d"
+		if strings.HasSuffix(arg, "d") || strings.HasSuffix(arg, "w") {
+			n, unit = arg[:len(arg)-1], arg[len(arg)-1:]
+		}
+		count, err := strconv.Atoi(n)
+		if err != nil || count < 1 {
+			return "", fmt.Errorf("repeat rule %q: every needs a number of days or weeks, like every:3d", rule)
+		}
+		return Recurrence(fmt.Sprintf("every:%d%s", count, unit)), nil
+	case kind == "weekly":
+		var days [7]bool
+		for _, name := range strings.Split(arg, ",") {
+			day, err := parseWeekday(strings.TrimSpace(name))
+			if err != nil {
+				return "", fmt.Errorf("repeat rule %q: %w", rule, err)
+			}
+			days[day] = true
+		}
+		var names []string
+		for i := 1; i <= 7; i++ {
+			if day := time.Weekday(i % 7); days[day] {
+				names = append(names, weekdayNames[day])
+			}
+		}
+		return Recurrence("weekly:" + strings.Join(names, ",")), nil
+	case kind == "monthly":
+		day, err := strconv.Atoi(arg)
+		if err != nil || day < 1 || day > 31 {
+			return "", fmt.Errorf("repeat rule %q: monthly needs a day of the month from 1 to 31", rule)
+		}
+		return Recurrence(fmt.Sprintf("monthly:%d", day)), nil
+	}
+	return "", fmt.Errorf("unknown repeat rule %q, use daily, every:N, weekly[:days] or monthly[:day]", rule)
+}
+
+// parseWeekday accepts a weekday's three letter or full English name
+func parseWeekday(name string) (time.Weekday, error) {
+	for day, short := range weekdayNames {
+		if name == short || name == strings.ToLower(time.Weekday(day).String()) {
+			return time.Weekday(day), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a weekday", name)
+}
+
+// Next returns the first date the rule falls on after from, at the same
+// time of day.  r must be in normal form, as returned by
+// ParseRecurrence, and not empty.
+func (r Recurrence) Next(from time.Time) (time.Time, error) {
+	kind, arg, _ := strings.Cut(string(r), ":")
+
+	switch {
+	case kind == "daily":
+		return from.AddDate(0, 0, 1), nil
+	case kind == "every" && len(arg) > 1:
+		count, _ := strconv.Atoi(arg[:len(arg)-1])
+		if strings.HasSuffix(arg, "w") {
+			count *= 7
+		}
+		if count < 1 {
+			break
+		}
+		return from.AddDate(0, 0, count), nil
+	case kind == "weekly" && arg == "":
+		return from.AddDate(0, 0, 7), nil
+	case kind == "weekly":
+		for i := 1; i <= 7; i++ {
+			next := from.AddDate(0, 0, i)
+			if strings.Contains(arg, weekdayNames[next.Weekday()]) {
+				return next, nil
+			}
+		}
+	case kind == "monthly":
+		day := from.Day()
+		if arg != "" {
+			day, _ = strconv.Atoi(arg)
+		}
+		if day < 1 {
+			break
+		}
+		year, month, _ := from.Date()
+		next := dayOfMonth(year, month, day, from)
+		if !next.After(from) {
+			next = dayOfMonth(year, month+1, day, from)
+		}
+		return next, nil
+	}
+	return time.Time{}, fmt.Errorf("bad repeat rule %q", r)
+}
+
+// dayOfMonth returns the given day of a month, or its last day if the
+// month is too short, at the time of day of clock
+func dayOfMonth(year int, month time.Month, day int, clock time.Time) time.Time {
+	//Day 0 of the next month is the last day of this one
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, clock.Location()).Day()
+	return time.Date(year, month, min(day, last),
+		clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), clock.Location())
+}
+
+// anchor pins a bare weekly or monthly rule to the weekday or day of
+// due, so that the day doesn't drift when a short month pushes one
+// occurrence back
+func (r Recurrence) anchor(due time.Time) Recurrence {
+	switch r {
+	case "weekly":
+		return Recurrence("weekly:" + weekdayNames[due.Weekday()])
+	case "monthly":
+		return Recurrence(fmt.Sprintf("monthly:%d", due.Day()))
+	}
+	return r
+}
+
+// nextOccurrence returns the item that follows item, which repeats and
+// has just been done.  It is due on the first date the rule gives after
+// item's due date, or after now if it had none, skipping any dates that
+// have already gone by.  The id is left for the caller to fill in.
+func nextOccurrence(item ToDoItem, now time.Time) (ToDoItem, error) {
+	base := now
+	if item.DueDate != nil {
+		base = *item.DueDate
+	}
+	rule := item.Repeat.anchor(base)
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	due, err := rule.Next(base)
+	for err == nil && due.Before(today) {
+		due, err = rule.Next(due)
+	}
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	next := item
+	next.Id = 0
+	next.IsDone = false
+	next.DueDate = &due
+	next.Repeat = rule
+	next.Tags = append([]string(nil), item.Tags...)
+	next.BlockedBy = append([]int(nil), item.BlockedBy...)
+	next.Work = nil
+	next.CreatedAt, next.UpdatedAt, next.CompletedAt = nil, nil, nil
+	return next, nil
+}
+
+// Occurrences returns the occurrences items that repeat will have from
+// now up to until, as they would be added if each were done on time.
+// Only open items repeat.  Each occurrence keeps the id of the item it
+// comes from, as it doesn't have one of its own yet, and has no
+// timestamps.
+func Occurrences(items []ToDoItem, until time.Time) ([]ToDoItem, error) {
+	now := timeNow()
+
+	var occurrences []ToDoItem
+	for _, item := range items {
+		if item.Repeat == "" || item.IsDone {
+			continue
+		}
+
+		for i := 0; i < maxOccurrences; i++ {
+			next, err := nextOccurrence(item, now)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", item.Id, err)
+			}
+			if next.DueDate.After(until) {
+				break
+			}
+			next.Id = item.Id
+			occurrences = append(occurrences, next)
+			item = next
+		}
+	}
+	return occurrences, nil
+}
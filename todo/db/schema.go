@@ -0,0 +1,170 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// SchemaVersion is the version of the on-disk format written by JsonStore.
+// BoltStore records it too, and its items use the same JSON layout.
+//
+// Version history:
+//
+//	1 - a bare JSON array of items, with the id high-water mark (if any)
+//	    kept in a separate "<dbFileName>.meta" file
+//	2 - a JSON object with a version header, the id high-water mark
+//	    and the items array
+const SchemaVersion = 2
+
+// dbFile is the on-disk layout of the current schema version
+type dbFile struct {
+	Version int        `json:"version"`
+	LastId  int        `json:"last_id"`
+	Items   []ToDoItem `json:"items"`
+}
+
+// dbMeta is the layout of the "<dbFileName>.meta" file that version 1
+// databases kept next to the item array.  It is only read during
+// migration now.
+type dbMeta struct {
+	LastId int `json:"last_id"`
+}
+
+// migration upgrades the raw contents of a database file by exactly one
+// schema version.  dbFileName is passed along for migrations that need
+// to pull in data kept outside the main file.
+type migration func(dbFileName string, data []byte) ([]byte, error)
+
+// migrations is indexed by the version a migration upgrades from, so
+// migrations[1] turns a version 1 file into a version 2 file.  To
+// change the format, bump SchemaVersion and add a migration here.
+var migrations = map[int]migration{
+	1: migrateV1ToV2,
+}
+
+// schemaVersionOf works out which schema version the raw database data
+// was written with.  Version 1 files have no header at all, they are
+// just a JSON array.
+func schemaVersionOf(data []byte) (int, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return 1, nil
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return 0, err
+	}
+	if header.Version < 1 {
+		return 0, errors.New("database file has no schema version")
+	}
+
+	return header.Version, nil
+}
+
+// encodeDB lays contents out in the current schema version.  Items are
+// written in id order so the file diffs cleanly from one save to the
+// next.
+func encodeDB(contents Contents) ([]byte, error) {
+	file := dbFile{
+		Version: SchemaVersion,
+		LastId:  contents.LastId,
+		Items:   make([]ToDoItem, 0, len(contents.Items)),
+	}
+	for _, item := range contents.Items {
+		file.Items = append(file.Items, item)
+	}
+	sort.Slice(file.Items, func(i, j int) bool {
+		return file.Items[i].Id < file.Items[j].Id
+	})
+
+	//lets pretty print it, but this is not required
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// decodeDB parses raw database data of any known schema version,
+// running it through the migrations needed to bring it up to the
+// current SchemaVersion.  Migration happens in memory only, the data
+// is written back in the current format by the next save.
+// dbFileName is where the data came from, or "" if it is not a file.
+func decodeDB(dbFileName string, data []byte) (Contents, error) {
+	file, err := decodeFile(dbFileName, data)
+	if err != nil {
+		return Contents{}, err
+	}
+
+	contents := Contents{
+		LastId: file.LastId,
+		Items:  make(DbMap, len(file.Items)),
+	}
+	for _, item := range file.Items {
+		contents.Items[item.Id] = item
+	}
+
+	return contents, nil
+}
+
+// decodeFile is decodeDB, but leaves the items as the list they were
+// stored as, so an id that is in it twice can still be seen
+func decodeFile(dbFileName string, data []byte) (dbFile, error) {
+	version, err := schemaVersionOf(data)
+	if err != nil {
+		return dbFile{}, err
+	}
+	if version > SchemaVersion {
+		return dbFile{}, fmt.Errorf("database schema version %d is newer than the supported version %d",
+			version, SchemaVersion)
+	}
+
+	for ; version < SchemaVersion; version++ {
+		data, err = migrations[version](dbFileName, data)
+		if err != nil {
+			return dbFile{}, fmt.Errorf("migrating schema version %d to %d: %w", version, version+1, err)
+		}
+	}
+
+	var file dbFile
+	err = json.Unmarshal(data, &file)
+	return file, err
+}
+
+// migrateV1ToV2 wraps the bare item array in the version 2 header and
+// folds in the high-water mark from the old ".meta" side file.  Item
+// fields added in version 2 are all optional, so the items themselves
+// carry over untouched.
+func migrateV1ToV2(dbFileName string, data []byte) ([]byte, error) {
+	var items []json.RawMessage
+	err := json.Unmarshal(data, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta dbMeta
+	if dbFileName != "" {
+		metaData, err := os.ReadFile(dbFileName + ".meta")
+		if err == nil {
+			err = json.Unmarshal(metaData, &meta)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+
+	return json.Marshal(struct {
+		Version int               `json:"version"`
+		LastId  int               `json:"last_id"`
+		Items   []json.RawMessage `json:"items"`
+	}{2, meta.LastId, items})
+}
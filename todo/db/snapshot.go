@@ -0,0 +1,378 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is a copy of the whole database taken at one moment.
+// Snapshots live in the directory "<db file>.snapshots", one JSON file
+// each in the same format as a JsonStore, encrypted if it is, named
+// after the time they were taken and whether they were taken
+// automatically, before a write, or by hand with Backup.
+type Snapshot struct {
+	Name   string
+	Time   time.Time
+	Manual bool
+
+	path string
+}
+
+// SnapshotPolicy says how many automatic snapshots are kept.  Every
+// write that changes anything first snapshots the database as it was,
+// and then the oldest automatic snapshots are deleted so no more than
+// Keep remain, and none older than MaxAge.  Snapshots taken by Backup
+// are never deleted automatically.
+type SnapshotPolicy struct {
+	// Keep is how many automatic snapshots to keep, 0 to take none
+	Keep int
+
+	// MaxAge, if set, also deletes automatic snapshots older than this
+	MaxAge time.Duration
+}
+
+// DefaultSnapshotPolicy is the policy a new ToDo starts with
+var DefaultSnapshotPolicy = SnapshotPolicy{Keep: 10}
+
+// snapshotTimeFormat names snapshot files so they sort by time
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+const (
+	autoSnapshotSuffix   = "-auto.json"
+	manualSnapshotSuffix = "-manual.json"
+)
+
+// errNoSnapshots is returned by the snapshot functions when the store
+// has no file to keep snapshots next to
+var errNoSnapshots = errors.New("snapshots need a file database")
+
+// SetSnapshotPolicy changes how automatic snapshots are taken and kept
+// from the next write on
+func (t *ToDo) SetSnapshotPolicy(policy SnapshotPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.snapshotPolicy = policy
+}
+
+// Backup takes a snapshot of the database now, which is kept until it
+// is deleted by hand.
+// Preconditions:   (1) The DB must be kept in a file
+//
+// Postconditions:
+//
+//	 (1) The new snapshot will be returned
+//		(2) If there is an error, it will be returned
+//		(3) The database file will not be modified
+func (t *ToDo) Backup() (Snapshot, error) {
+	return t.BackupContext(context.Background())
+}
+
+// BackupContext is Backup, giving up with ctx's error if ctx is done
+// before the database is read
+func (t *ToDo) BackupContext(ctx context.Context) (Snapshot, error) {
+	var snapshot Snapshot
+	err := t.viewContext(ctx, func() error {
+		var err error
+		snapshot, err = t.takeSnapshot(true, Contents{LastId: t.lastId, Items: t.toDoMap})
+		return err
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("Backup: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// Snapshots returns every snapshot of the database, oldest first
+// Preconditions:   (1) The DB must be kept in a file
+//
+// Postconditions:
+//
+//	 (1) The snapshots will be returned, if any exist
+//		(2) If there is an error, it will be returned
+func (t *ToDo) Snapshots() ([]Snapshot, error) {
+	snapshots, err := t.listSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("Snapshots: %w", err)
+	}
+
+	return snapshots, nil
+}
+
+// SnapshotBefore returns the newest snapshot taken at or before at.  As
+// automatic snapshots are taken just before a write, that is the
+// database as it stood before the first write after the snapshot.
+// Preconditions:   (1) The DB must be kept in a file
+//
+//					(2) There must be a snapshot that old, or an
+//						error is returned
+func (t *ToDo) SnapshotBefore(at time.Time) (Snapshot, error) {
+	snapshots, err := t.listSnapshots()
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("SnapshotBefore: %w", err)
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].Time.After(at) {
+			return snapshots[i], nil
+		}
+	}
+	return Snapshot{}, fmt.Errorf("SnapshotBefore: there is no snapshot from before %s", at.Format(time.RFC3339))
+}
+
+// FindSnapshot returns the snapshot with the given name.  "latest" is
+// the newest snapshot.
+func (t *ToDo) FindSnapshot(name string) (Snapshot, error) {
+	snapshots, err := t.listSnapshots()
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("FindSnapshot: %w", err)
+	}
+
+	if name == "latest" && len(snapshots) > 0 {
+		return snapshots[len(snapshots)-1], nil
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return snapshot, nil
+		}
+	}
+	return Snapshot{}, fmt.Errorf("FindSnapshot: there is no snapshot %q", name)
+}
+
+// BackupFile returns the "<db file>.bak" file that RestoreDB restores,
+// as a Snapshot, so it can be passed to DiffSnapshot and
+// RestoreSnapshot like any other
+func (t *ToDo) BackupFile() (Snapshot, error) {
+	return t.BackupFileContext(context.Background())
+}
+
+// BackupFileContext is BackupFile, giving up with ctx's error if ctx is
+// already done
+func (t *ToDo) BackupFileContext(ctx context.Context) (Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, fmt.Errorf("BackupFile: %w", err)
+	}
+	fileStore, ok := t.store.(FileStore)
+	if !ok {
+		return Snapshot{}, fmt.Errorf("BackupFile: %w", errNoSnapshots)
+	}
+
+	path := fileStore.FileName() + ".bak"
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("BackupFile: %w", err)
+	}
+
+	return Snapshot{Name: filepath.Base(path), Time: info.ModTime().UTC(), Manual: true, path: path}, nil
+}
+
+// DiffSnapshot returns the changes RestoreSnapshot would make to the
+// database as it is now, by id
+func (t *ToDo) DiffSnapshot(snapshot Snapshot) ([]Change, error) {
+	contents, err := t.readSnapshot(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("DiffSnapshot: %w", err)
+	}
+
+	var changes []Change
+	err = t.viewDB(func() error {
+		changes = diffItems(t.toDoMap, contents.Items)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DiffSnapshot: %w", err)
+	}
+
+	return changes, nil
+}
+
+// RestoreSnapshot replaces every item in the database with the items in
+// the snapshot.  It is a write like any other, so the database is
+// snapshotted first and the restore can be undone.
+func (t *ToDo) RestoreSnapshot(snapshot Snapshot) error {
+	return t.RestoreSnapshotContext(context.Background(), snapshot)
+}
+
+// RestoreSnapshotContext is RestoreSnapshot, giving up with ctx's error
+// if ctx is done before the restored database is saved
+func (t *ToDo) RestoreSnapshotContext(ctx context.Context, snapshot Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("RestoreSnapshot: %w", err)
+	}
+	contents, err := t.readSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("RestoreSnapshot: %w", err)
+	}
+
+	err = t.restoreContents(ctx, contents)
+	if err != nil {
+		return fmt.Errorf("RestoreSnapshot: %w", err)
+	}
+
+	return nil
+}
+
+// snapshotDir is where the snapshots are kept, or "" if the store
+// doesn't have a file to keep them next to
+func (t *ToDo) snapshotDir() string {
+	fileStore, ok := t.store.(FileStore)
+	if !ok {
+		return ""
+	}
+	return fileStore.FileName() + ".snapshots"
+}
+
+// takeSnapshot writes contents, which must be what the database holds,
+// to a new snapshot file, encrypted if the database is
+func (t *ToDo) takeSnapshot(manual bool, contents Contents) (Snapshot, error) {
+	dir := t.snapshotDir()
+	if dir == "" {
+		return Snapshot{}, errNoSnapshots
+	}
+
+	data, err := t.snapshotData(contents)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	snapshot := Snapshot{Time: time.Now().UTC(), Manual: manual}
+	snapshot.Name = snapshot.Time.Format(snapshotTimeFormat) + autoSnapshotSuffix
+	if manual {
+		snapshot.Name = snapshot.Time.Format(snapshotTimeFormat) + manualSnapshotSuffix
+	}
+	snapshot.path = filepath.Join(dir, snapshot.Name)
+
+	err = writeFileAtomic(snapshot.path, data, 0644)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// snapshotData returns what a snapshot of contents holds.  A JSON file
+// that is still the version contents was loaded from already is that,
+// encrypted if it should be, so it is copied rather than encoding every
+// item again on each write.  Any other file, such as one that couldn't
+// be read, is not what contents holds.
+func (t *ToDo) snapshotData(contents Contents) ([]byte, error) {
+	if jsonStore, ok := t.store.(*JsonStore); ok && t.version != 0 {
+		if data, ok := jsonStore.dataAt(t.version); ok {
+			return data, nil
+		}
+	}
+
+	data, err := encodeDB(contents)
+	if err == nil {
+		data, err = t.sealing().seal(data)
+	}
+	return data, err
+}
+
+// autoSnapshot takes the snapshot a write takes of the database before
+// it changes it, then deletes the automatic snapshots the policy no
+// longer keeps.  It does nothing for stores without a file.
+func (t *ToDo) autoSnapshot(before Contents) error {
+	if t.snapshotDir() == "" || t.snapshotPolicy.Keep <= 0 {
+		return nil
+	}
+
+	_, err := t.takeSnapshot(false, before)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := t.listSnapshots()
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		if snapshot.Manual {
+			continue
+		}
+		kept++
+		tooOld := t.snapshotPolicy.MaxAge > 0 && time.Since(snapshot.Time) > t.snapshotPolicy.MaxAge
+		if kept > t.snapshotPolicy.Keep || tooOld {
+			err := os.Remove(snapshot.path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listSnapshots reads the snapshot directory, oldest first.  Files that
+// aren't snapshots are ignored.
+func (t *ToDo) listSnapshots() ([]Snapshot, error) {
+	dir := t.snapshotDir()
+	if dir == "" {
+		return nil, errNoSnapshots
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, manual := strings.CutSuffix(name, manualSnapshotSuffix)
+		if !manual {
+			var found bool
+			stamp, found = strings.CutSuffix(name, autoSnapshotSuffix)
+			if !found {
+				continue
+			}
+		}
+		taken, err := time.Parse(snapshotTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:   name,
+			Time:   taken,
+			Manual: manual,
+			path:   filepath.Join(dir, name),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// readSnapshot loads the contents of a snapshot file, which may be in
+// any schema version, and encrypted or not
+func (t *ToDo) readSnapshot(snapshot Snapshot) (Contents, error) {
+	if snapshot.path == "" {
+		return Contents{}, fmt.Errorf("snapshot %q has no file", snapshot.Name)
+	}
+
+	data, err := t.sealing().readFile(snapshot.path)
+	if err != nil {
+		return Contents{}, err
+	}
+	contents, err := decodeDB(snapshot.path, data)
+	if err != nil {
+		return Contents{}, fmt.Errorf("reading snapshot %s: %w", snapshot.Name, err)
+	}
+	return contents, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Contents is everything a Store holds: the items keyed by id, plus
+// the id high-water mark AddItem uses to hand out new ids.
+type Contents struct {
+	LastId int
+	Items  DbMap
+}
+
+// Store is the storage backend behind a ToDo.  A ToDo never touches
+// the disk itself, it loads the whole contents of its Store, works on
+// them in memory and saves them back.
+//
+// Implementations must make Save all or nothing: after a failed or
+// interrupted Save, Load returns either the old or the new contents.
+// Lock must keep every other holder of the lock out, including other
+// Store values (and other processes) using the same underlying
+// storage, until the returned unlock function is called.  A store that
+// has no way to lock, like HttpStore, must instead fail a Save with
+// ErrConflict if anyone else saved since its last Load.
+type Store interface {
+	// Load returns everything currently in the store
+	Load() (Contents, error)
+
+	// Save replaces everything in the store with contents
+	Save(contents Contents) error
+
+	// Lock blocks until the caller has exclusive write access to the
+	// store and returns the function that gives it back
+	Lock() (func() error, error)
+
+	// Close releases anything the store is holding on to
+	Close() error
+}
+
+// VersionedStore is a Store that can tell cheaply whether its contents
+// have changed.  A ToDo holds on to what it loaded from such a store
+// and only loads it again once its version has moved on, so a store
+// that no one else writes to is only read once.
+type VersionedStore interface {
+	Store
+
+	// Version returns a number that changes whenever the contents do.
+	// 0 means the store can't tell, and the contents must be loaded.
+	Version() (uint64, error)
+}
+
+// ContextStore is a Store whose loads and saves can be given up on part
+// way, such as HttpStore's requests.  A ToDo method that takes a
+// context.Context passes it on to them.
+type ContextStore interface {
+	Store
+
+	// LoadContext is Load, giving up with ctx's error once ctx is done
+	LoadContext(ctx context.Context) (Contents, error)
+
+	// SaveContext is Save, giving up with ctx's error once ctx is
+	// done.  A save that is given up on must still be all or nothing.
+	SaveContext(ctx context.Context, contents Contents) error
+}
+
+// FileStore is a Store that keeps its data in a file on disk.  Features
+// that keep extra files next to the database, like the ".bak" backup
+// used by RestoreDB, only work with a FileStore.
+type FileStore interface {
+	Store
+
+	// FileName is the path of the database file
+	FileName() string
+}
+
+// OpenStore creates the Store described by dsn, which takes the form
+// "<scheme>:<location>".  The supported schemes are:
+//
+//	json:./data/todo.json	a JSON file, see JsonStore
+//	bolt:./todo.db		a bbolt key-value database, see BoltStore
+//	mem:			an in-memory store, see MemStore
+//	http://host:port	a todo server, see HttpStore
+//
+// A dsn without a scheme is taken to be the name of a JSON file, so
+// plain file names keep working as they always have.  An http:// or
+// https:// dsn can set how long to wait for the server with a timeout
+// parameter, as in "http://host:port?timeout=30s".
+func OpenStore(dsn string) (Store, error) {
+	scheme, location, found := strings.Cut(dsn, ":")
+	if !found || !isScheme(scheme) {
+		return NewJsonStore(dsn)
+	}
+
+	switch scheme {
+	case "json":
+		return NewJsonStore(location)
+	case "bolt":
+		return NewBoltStore(location)
+	case "mem":
+		if location != "" {
+			return nil, fmt.Errorf("OpenStore: mem: takes no location, got %q", location)
+		}
+		return NewMemStore(), nil
+	case "http", "https":
+		return openHttpStore(dsn)
+	default:
+		return nil, fmt.Errorf("OpenStore: unknown database scheme %q", scheme)
+	}
+}
+
+// isScheme reports whether s looks like a dsn scheme rather than the
+// start of a file name.  A single letter is a Windows drive letter.
+func isScheme(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// openHttpStore is OpenStore for http:// and https:// dsns, which may
+// carry a timeout parameter for the HttpStore
+func openHttpStore(dsn string) (Store, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("OpenStore: %w", err)
+	}
+
+	timeout := DefaultHttpTimeout
+	query := u.Query()
+	if value := query.Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("OpenStore: bad timeout: %w", err)
+		}
+		query.Del("timeout")
+		u.RawQuery = query.Encode()
+	}
+
+	return NewHttpStore(u.String(), timeout)
+}
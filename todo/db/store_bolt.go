@@ -0,0 +1,194 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket and key names used inside the bbolt file
+var (
+	boltItemsBucket = []byte("items")
+	boltMetaBucket  = []byte("meta")
+	boltVersionKey  = []byte("version")
+	boltLastIdKey   = []byte("last_id")
+)
+
+// boltOpenTimeout bounds how long we wait for bbolt's own file lock,
+// which is held by whichever process has the file open right now
+const boltOpenTimeout = 5 * time.Second
+
+// BoltStore keeps the database in a bbolt key-value file.  Each item is
+// stored as JSON under its id in the "items" bucket, and the schema
+// version and id high-water mark live in the "meta" bucket.
+//
+// The file is only opened for the length of a Load or Save, so several
+// processes can take turns using it.  Save rewrites the items bucket in
+// a single bbolt transaction, which makes it all or nothing, and Lock
+// takes an advisory lock on "<fileName>.lock" just like JsonStore.
+type BoltStore struct {
+	fileName string
+}
+
+// NewBoltStore returns a BoltStore for the named file.  If the file
+// doesn't exist, it will be created as an empty database.
+func NewBoltStore(fileName string) (*BoltStore, error) {
+	s := &BoltStore{fileName: fileName}
+
+	bdb, err := s.open(false)
+	if err != nil {
+		return nil, err
+	}
+	defer bdb.Close()
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		if meta.Get(boltVersionKey) == nil {
+			err = meta.Put(boltVersionKey, []byte(strconv.Itoa(SchemaVersion)))
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.CreateBucketIfNotExists(boltItemsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// FileName is the path of the bbolt file
+func (s *BoltStore) FileName() string {
+	return s.fileName
+}
+
+// Load reads every item out of the file
+func (s *BoltStore) Load() (Contents, error) {
+	bdb, err := s.open(true)
+	if err != nil {
+		return Contents{}, err
+	}
+	defer bdb.Close()
+
+	contents := Contents{Items: make(DbMap)}
+
+	err = bdb.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		items := tx.Bucket(boltItemsBucket)
+		if meta == nil || items == nil {
+			return fmt.Errorf("%s is not a todo database", s.fileName)
+		}
+
+		version, err := strconv.Atoi(string(meta.Get(boltVersionKey)))
+		if err != nil {
+			return fmt.Errorf("bad schema version: %w", err)
+		}
+		if version > SchemaVersion {
+			return fmt.Errorf("database schema version %d is newer than the supported version %d",
+				version, SchemaVersion)
+		}
+
+		if lastId := meta.Get(boltLastIdKey); lastId != nil {
+			contents.LastId, err = strconv.Atoi(string(lastId))
+			if err != nil {
+				return fmt.Errorf("bad last id: %w", err)
+			}
+		}
+
+		return items.ForEach(func(k, v []byte) error {
+			var item ToDoItem
+			err := json.Unmarshal(v, &item)
+			if err != nil {
+				return fmt.Errorf("item %d: %w", boltIdFromKey(k), err)
+			}
+			contents.Items[item.Id] = item
+			return nil
+		})
+	})
+	if err != nil {
+		return Contents{}, err
+	}
+
+	return contents, nil
+}
+
+// Save replaces every item in the file in one transaction
+func (s *BoltStore) Save(contents Contents) error {
+	bdb, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	return bdb.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(boltItemsBucket)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		items, err := tx.CreateBucket(boltItemsBucket)
+		if err != nil {
+			return err
+		}
+
+		for id, item := range contents.Items {
+			data, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			err = items.Put(boltKeyFromId(id), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		err = meta.Put(boltVersionKey, []byte(strconv.Itoa(SchemaVersion)))
+		if err != nil {
+			return err
+		}
+		return meta.Put(boltLastIdKey, []byte(strconv.Itoa(contents.LastId)))
+	})
+}
+
+// Lock takes the advisory lock on "<fileName>.lock"
+func (s *BoltStore) Lock() (func() error, error) {
+	return lockFile(s.fileName + ".lock")
+}
+
+// Close does nothing, the file is only open while it is being read or
+// written
+func (s *BoltStore) Close() error {
+	return nil
+}
+
+func (s *BoltStore) open(readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(s.fileName, 0644, &bolt.Options{
+		Timeout:  boltOpenTimeout,
+		ReadOnly: readOnly,
+	})
+}
+
+// boltKeyFromId encodes an id so that bbolt's byte-wise key order is
+// the same as numeric order, negative ids included
+func boltKeyFromId(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id)^(1<<63))
+	return key
+}
+
+func boltIdFromKey(key []byte) int {
+	return int(binary.BigEndian.Uint64(key) ^ (1 << 63))
+}
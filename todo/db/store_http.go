@@ -0,0 +1,364 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrConflict is returned by Store.Save when someone else saved the
+// store after it was last loaded.  ToDo writes retry when they get it.
+var ErrConflict = errors.New("the database was changed by someone else")
+
+// maxConflictRetries is how many times a write is tried before
+// ErrConflict is passed on to the caller
+const maxConflictRetries = 10
+
+// DefaultHttpTimeout is how long an HttpStore waits for each request
+// unless the dsn says otherwise
+const DefaultHttpTimeout = 10 * time.Second
+
+// StorePath is where a todo server serves the whole database for
+// HttpStore, see ToDo.Dump and ToDo.Replace
+const StorePath = "/todo/store"
+
+// OpHeader is the header an HttpStore sends with each save, giving the
+// op the server should journal it under, such as "add" or "done"
+const OpHeader = "X-Todo-Op"
+
+// HttpStore keeps the database on a todo server (see todo serve), so a
+// whole team can share one list.  Load fetches the database from
+// StorePath in the JsonStore file format, and Save sends it back.
+//
+// There is no way to hold a lock across HTTP requests, so Lock does
+// nothing.  Instead every Load remembers the version (the ETag) it got
+// and Save only succeeds if the server still has that version,
+// otherwise it returns ErrConflict and the write is retried on fresh
+// contents.
+type HttpStore struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	version string
+}
+
+// NewHttpStore returns an HttpStore for the todo server at serverURL,
+// such as "http://localhost:1080".  Requests that take longer than
+// timeout fail.
+func NewHttpStore(serverURL string, timeout time.Duration) (*HttpStore, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("NewHttpStore: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("NewHttpStore: %q is not an http:// or https:// server address", serverURL)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("NewHttpStore: the timeout must be positive, got %s", timeout)
+	}
+
+	return &HttpStore{
+		url:    strings.TrimSuffix(u.String(), "/"),
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// URL is the address of the todo server
+func (s *HttpStore) URL() string {
+	return s.url
+}
+
+// Load fetches the database from the server
+func (s *HttpStore) Load() (Contents, error) {
+	return s.LoadContext(context.Background())
+}
+
+// LoadContext is Load, giving up with ctx's error once ctx is done
+func (s *HttpStore) LoadContext(ctx context.Context) (Contents, error) {
+	rsp, err := s.do(ctx, http.MethodGet, nil, "", "")
+	if err != nil {
+		return Contents{}, err
+	}
+	defer rsp.Body.Close()
+
+	data, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return Contents{}, fmt.Errorf("reading the database from %s: %w", s.url, err)
+	}
+	contents, err := decodeDB("", data)
+	if err != nil {
+		return Contents{}, fmt.Errorf("reading the database from %s: %w", s.url, err)
+	}
+
+	s.mu.Lock()
+	s.version = etagVersion(rsp.Header.Get("ETag"))
+	s.mu.Unlock()
+
+	return contents, nil
+}
+
+// Save sends contents to the server, as long as nobody else has saved
+// since the last Load.  If someone has, it returns ErrConflict.
+func (s *HttpStore) Save(contents Contents) error {
+	return s.SaveContext(context.Background(), contents)
+}
+
+// SaveContext is Save, giving up with ctx's error once ctx is done.
+// If the request had already reached the server, the save may have
+// been made all the same.
+func (s *HttpStore) SaveContext(ctx context.Context, contents Contents) error {
+	return s.saveOp(ctx, contents, "")
+}
+
+// saveOp is SaveContext, telling the server the op to journal the save
+// under
+func (s *HttpStore) saveOp(ctx context.Context, contents Contents, op string) error {
+	data, err := encodeDB(contents)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	version := s.version
+	s.mu.Unlock()
+
+	rsp, err := s.do(ctx, http.MethodPut, data, version, op)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	s.mu.Lock()
+	s.version = etagVersion(rsp.Header.Get("ETag"))
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Lock does nothing, conflicting saves are caught by Save instead
+func (s *HttpStore) Lock() (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// Close drops the connections kept open to the server
+func (s *HttpStore) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// do sends one request to StorePath and returns the response if it was
+// a success.  Everything else is turned into an error saying what went
+// wrong in terms of the server, rather than of HTTP.
+func (s *HttpStore) do(ctx context.Context, method string, body []byte, version, op string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+StorePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if version != "" {
+		req.Header.Set("If-Match", `"`+version+`"`)
+	}
+	if op != "" {
+		req.Header.Set(OpHeader, op)
+	}
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			return nil, fmt.Errorf("todo server %s did not answer within %s", s.url, s.client.Timeout)
+		}
+		return nil, fmt.Errorf("can't reach todo server %s: %w", s.url, err)
+	}
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return rsp, nil
+	}
+	defer rsp.Body.Close()
+
+	switch rsp.StatusCode {
+	case http.StatusPreconditionFailed:
+		return nil, ErrConflict
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, fmt.Errorf("%s is not a todo server", s.url)
+	}
+
+	//The API sends errors as {"status": ..., "error": ...}, but anything
+	//could be in the way, so fall back to the status line
+	var result struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(rsp.Body, 4096))
+	if json.Unmarshal(data, &result) != nil || result.Error == "" {
+		result.Error = http.StatusText(rsp.StatusCode)
+	}
+	return nil, fmt.Errorf("todo server %s: %d %s", s.url, rsp.StatusCode, result.Error)
+}
+
+// etagVersion strips the quotes off an ETag
+func etagVersion(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
+
+// Remote reports whether the database is kept by a todo server, see
+// HttpStore.  The server keeps its journal and snapshots to itself.
+func (t *ToDo) Remote() bool {
+	_, ok := t.store.(*HttpStore)
+	return ok
+}
+
+// Dump returns the whole database in the JsonStore file format, and its
+// version, for a todo server to hand to an HttpStore.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The encoded database and its version will be returned
+//		(2) If there is an error, it will be returned
+//		(3) The database file will not be modified
+func (t *ToDo) Dump() ([]byte, string, error) {
+	return t.DumpContext(context.Background())
+}
+
+// DumpContext is Dump, giving up with ctx's error if ctx is done
+// before the database is read
+func (t *ToDo) DumpContext(ctx context.Context) ([]byte, string, error) {
+	var data []byte
+	err := t.viewContext(ctx, func() error {
+		var err error
+		data, err = encodeDB(Contents{LastId: t.lastId, Items: t.toDoMap})
+		return err
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("Dump: %w", err)
+	}
+
+	return data, dataVersion(data), nil
+}
+
+// Replace swaps the whole database for data, which was sent by an
+// HttpStore, as a single write that can be undone.  It is journaled
+// under op, the op the HttpStore's write had, or "remote" if op is
+// empty or not an op.  The items data adds or changes are checked the
+// way AddItem, UpdateItem and ChangeItemDoneStatus check them.  The id
+// high-water mark never goes backwards.
+// Preconditions:   (1) data must be a database in the JsonStore format
+//
+//					(2) version must be the version of the database
+//						now, as returned by Dump, otherwise
+//						ErrConflict is returned
+//
+// Postconditions:
+//
+//	 (1) The version of the new database, as the pre-hooks left
+//		it, will be returned
+//		(2) If there is an error, it will be returned and the
+//			database will not be modified
+func (t *ToDo) Replace(data []byte, version, op string) (string, error) {
+	return t.ReplaceContext(context.Background(), data, version, op)
+}
+
+// ReplaceContext is Replace, giving up with ctx's error if ctx is done
+// before the new database is saved
+func (t *ToDo) ReplaceContext(ctx context.Context, data []byte, version, op string) (string, error) {
+	file, err := decodeFile("", data)
+	if err != nil {
+		return "", fmt.Errorf("Replace: %w", err)
+	}
+	items := make(DbMap, len(file.Items))
+	for _, item := range file.Items {
+		if item.Id <= 0 {
+			return "", fmt.Errorf("Replace: %w", &ItemError{Id: item.Id, Err: ErrBadId})
+		}
+		if _, found := items[item.Id]; found {
+			return "", fmt.Errorf("Replace: %w", &ItemError{Id: item.Id, Err: ErrExists})
+		}
+		items[item.Id] = item
+	}
+	if !remoteOp.MatchString(op) {
+		op = "remote"
+	}
+
+	var newVersion string
+	err = t.writeDBSaved(ctx, &JournalEntry{Op: op}, func() error {
+		current, err := encodeDB(Contents{LastId: t.lastId, Items: t.toDoMap})
+		if err != nil {
+			return err
+		}
+		if dataVersion(current) != version {
+			return ErrConflict
+		}
+		if err := checkReplacement(t.toDoMap, items); err != nil {
+			return err
+		}
+
+		t.toDoMap = maps.Clone(items)
+		t.lastId = max(t.lastId, file.LastId)
+		for id := range t.toDoMap {
+			t.lastId = max(t.lastId, id)
+		}
+		return nil
+	}, func() error {
+		saved, err := encodeDB(Contents{LastId: t.lastId, Items: t.toDoMap})
+		newVersion = dataVersion(saved)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Replace: %w", err)
+	}
+
+	return newVersion, nil
+}
+
+// remoteOp matches the ops Replace journals a write under.  Anything
+// else a client sends is journaled as "remote".
+var remoteOp = regexp.MustCompile(`^[a-z][a-z-]{0,31}$`)
+
+// checkReplacement checks the items that items, sent to Replace, adds
+// or changes in toDoMap: their parents and blockers must be in items
+// and make no loop, and an item can't be done while it is waiting on
+// one that isn't
+func checkReplacement(toDoMap, items DbMap) error {
+	for _, change := range diffItems(toDoMap, items) {
+		if change.After == nil {
+			continue
+		}
+		if err := checkParent(items, *change.After); err != nil {
+			return err
+		}
+		if err := checkBlockers(items, *change.After); err != nil {
+			return err
+		}
+		if change.After.IsDone && (change.Before == nil || !change.Before.IsDone) {
+			if err := checkBlocked(items, change.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dataVersion is the version of an encoded database, which changes
+// whenever anything in it does
+func dataVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JsonStore keeps the database in a single JSON file, laid out as
+// described by SchemaVersion.  Saves replace the file atomically and
+// Lock takes an advisory lock on "<fileName>.lock", so any number of
+// processes can share one file safely.
+//
+// A JsonStore is a VersionedStore.  It remembers the file it last
+// read or wrote, and its version only moves on once the file's inode,
+// size or modification time has changed and its contents hash to
+// something new, so touching the file doesn't make anyone reparse it.
+//
+// The file can be encrypted, see Encrypt.  keys is the passphrase it
+// is read with, and params how it was encrypted when it was last
+// loaded, or nil if it wasn't, which is how saves write it.
+type JsonStore struct {
+	fileName string
+
+	mu      sync.Mutex
+	info    os.FileInfo
+	sum     [sha256.Size]byte
+	version uint64
+	keys    *keyring
+	params  *kdfParams
+}
+
+// NewJsonStore returns a JsonStore for the named file.  If the file
+// doesn't exist, it will be created as an empty database.
+func NewJsonStore(fileName string) (*JsonStore, error) {
+	//Check if the database file exists, if not use initDB to create it
+	//In go, you use the os.Stat function to get information about a file
+	//In this case, we are only checking the error, because if we get an
+	//error we can safely assume that this file does not exist.
+	if _, err := os.Stat(fileName); err != nil {
+		//If the file doesn't exist, create it
+		err := initDB(fileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &JsonStore{fileName: fileName}, nil
+}
+
+// FileName is the path of the JSON file
+func (s *JsonStore) FileName() string {
+	return s.fileName
+}
+
+// SetPassphrase sets the passphrase an encrypted file is read and
+// written with
+func (s *JsonStore) SetPassphrase(passphrase []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = newKeyring(passphrase)
+}
+
+// Encrypted reports whether the file was encrypted when it was last
+// loaded
+func (s *JsonStore) Encrypted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.params != nil
+}
+
+// sealing is how the file is read and written
+func (s *JsonStore) sealing() sealing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sealing{keys: s.keys, params: s.params}
+}
+
+// setSealing changes how the file is written from the next save on
+func (s *JsonStore) setSealing(to sealing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys, s.params = to.keys, to.params
+}
+
+// Load reads and parses the JSON file, decrypting it if it is
+// encrypted and migrating it from an older schema version if needed.
+// A file that can't be parsed gives an error wrapping ErrCorrupt.
+func (s *JsonStore) Load() (Contents, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.read()
+	if err != nil {
+		return Contents{}, err
+	}
+
+	//Not having the passphrase doesn't make the file corrupt
+	data, params, err := s.keys.open(data)
+	if errors.Is(err, ErrNoPassphrase) || errors.Is(err, ErrBadPassphrase) {
+		return Contents{}, err
+	}
+	if err != nil {
+		return Contents{}, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	s.params = params
+
+	//A file from a newer version of todo isn't corrupt, just not ours
+	//to read
+	contents, err := decodeDB(s.fileName, data)
+	if version, versionErr := schemaVersionOf(data); err != nil && (versionErr != nil || version <= SchemaVersion) {
+		return Contents{}, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return contents, err
+}
+
+// Version returns the version of the file, which only takes a stat of
+// it while it hasn't changed
+func (s *JsonStore) Version() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.fileName)
+	if err != nil {
+		return 0, err
+	}
+	if s.info == nil || !sameStat(s.info, info) {
+		if _, err := s.read(); err != nil {
+			return 0, err
+		}
+	}
+
+	return s.version, nil
+}
+
+// read returns what is in the file, moving the version on if it isn't
+// what was there when the file was last read or written
+func (s *JsonStore) read() ([]byte, error) {
+	f, err := os.Open(s.fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	//The file is replaced rather than rewritten by saves, so the open
+	//file stays the one its stat describes
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	s.remember(info, data)
+	return data, nil
+}
+
+// dataAt returns what is in the file, as long as that is still the
+// version given
+func (s *JsonStore) dataAt(version uint64) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.read()
+	return data, err == nil && s.version == version
+}
+
+// remember records info and data as what is in the file now
+func (s *JsonStore) remember(info os.FileInfo, data []byte) {
+	sum := sha256.Sum256(data)
+	if s.info == nil || sum != s.sum {
+		s.version++
+	}
+	s.info, s.sum = info, sum
+}
+
+// Save writes contents to the JSON file in the current schema version,
+// encrypted if the file was
+func (s *JsonStore) Save(contents Contents) error {
+	data, err := encodeDB(contents)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err = s.keys.seal(s.params, data)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(s.fileName, data, 0644)
+	if err != nil {
+		s.info = nil
+		return err
+	}
+	info, err := os.Stat(s.fileName)
+	if err != nil {
+		s.info = nil
+		return err
+	}
+	s.remember(info, data)
+
+	//The high-water mark of a version 1 DB lived in a side file.  It
+	//has been folded into the header we just wrote, so clean it up.
+	err = os.Remove(s.fileName + ".meta")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Lock takes the advisory lock on "<fileName>.lock"
+func (s *JsonStore) Lock() (func() error, error) {
+	return lockFile(s.fileName + ".lock")
+}
+
+// Close does nothing, the file is only open while it is being read or
+// written
+func (s *JsonStore) Close() error {
+	return nil
+}
+
+// sameStat reports whether a and b describe the same file, unchanged
+func sameStat(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// initDB is a helper function that creates a new file with an
+// empty database in the current schema.  This is used to make sure
+// that the DB file exists for operations on our ToDo struct.  This
+// function is called by NewJsonStore() if the DB file doesn't exist.
+func initDB(dbFileName string) error {
+	f, err := os.Create(dbFileName)
+	if err != nil {
+		return err
+	}
+
+	// Our DB is a versioned header wrapped around a json array of
+	// items, so an empty DB is the header with an empty array, which
+	// in json is represented as "[]"
+	data, err := json.Marshal(dbFile{Version: SchemaVersion, Items: []ToDoItem{}})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	f.Close()
+
+	return nil
+}
+
+// writeFileAtomic replaces fileName with data without ever leaving a
+// truncated or half written file behind.  The data is written to a
+// temporary file in the same directory and flushed to disk, then
+// renamed over the original.  rename(2) is atomic, so readers see
+// either the old contents or the new contents, never a mix.
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmpName, err := stageFile(fileName, data, perm)
+	if err != nil {
+		return err
+	}
+	err = renameStaged(tmpName, fileName)
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// stageFile is the first half of writeFileAtomic: it writes data to a
+// temporary file next to fileName, flushed to disk and with perm, and
+// returns its name.  renameStaged puts it in place, or the caller
+// removes it.
+func stageFile(fileName string, data []byte, perm os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+
+	//Make sure the temp file does not outlive a failed write
+	defer func() {
+		if err != nil {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return "", err
+	}
+	return tmpName, nil
+}
+
+// renameStaged is the second half of writeFileAtomic, renaming the
+// file stageFile wrote over fileName
+func renameStaged(tmpName, fileName string) error {
+	err := os.Rename(tmpName, fileName)
+	if err != nil {
+		return err
+	}
+
+	//Flush the directory entry too, so the rename itself survives a
+	//crash.  Not every platform supports syncing a directory, so this
+	//is best effort.
+	if d, derr := os.Open(filepath.Dir(fileName)); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import "sync"
+
+// MemStore keeps the database in memory, which makes it handy for
+// tests and throwaway lists.  Contents are kept encoded in the same
+// layout as a JsonStore file, so callers can never share maps or
+// slices with the store and everything round trips exactly as it
+// would through the disk.  Nothing survives the process.
+type MemStore struct {
+	mu      sync.Mutex
+	lockMu  sync.Mutex
+	data    []byte
+	version uint64
+}
+
+// NewMemStore returns an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{}
+}
+
+// Load decodes the current contents, an empty database if nothing has
+// been saved yet
+func (s *MemStore) Load() (Contents, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data == nil {
+		return Contents{Items: make(DbMap)}, nil
+	}
+
+	return decodeDB("", s.data)
+}
+
+// Save replaces the contents
+func (s *MemStore) Save(contents Contents) error {
+	data, err := encodeDB(contents)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = data
+	s.version++
+	return nil
+}
+
+// Version returns the number of saves so far, which makes MemStore a
+// VersionedStore
+func (s *MemStore) Version() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version, nil
+}
+
+// Lock keeps out other users of this MemStore.  There is nothing to
+// share it with outside the process.
+func (s *MemStore) Lock() (func() error, error) {
+	s.lockMu.Lock()
+
+	return func() error {
+		s.lockMu.Unlock()
+		return nil
+	}, nil
+}
+
+// Close does nothing
+func (s *MemStore) Close() error {
+	return nil
+}
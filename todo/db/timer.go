@@ -0,0 +1,324 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// The time worked on an item is kept on it, in Work, as the intervals
+// between StartTimer and StopTimer.  Only one timer runs at a time, in
+// the whole database, so starting one stops the one that was running.
+// Report sums the intervals by item, tag or day, for timesheets.
+
+// Interval is a stretch of time spent working on an item.  End is nil
+// while its timer is running.
+type Interval struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Duration is how long the interval lasted, up to now if its timer is
+// still running
+func (i Interval) Duration(now time.Time) time.Duration {
+	if i.End != nil {
+		now = *i.End
+	}
+	return max(now.Sub(i.Start), 0)
+}
+
+// ErrNoTimer is returned when there is no timer running to stop
+var ErrNoTimer = errors.New("no timer is running")
+
+// ErrTimerRunning is returned, in an *ItemError, when the timer is
+// started on an item whose timer is already running
+var ErrTimerRunning = errors.New("has a timer already running")
+
+// ErrItemDone is returned, in an *ItemError, when the timer is started
+// on an item that is done
+var ErrItemDone = errors.New("is done")
+
+// WorkGroup is what Report sums the time worked by
+type WorkGroup string
+
+const (
+	ByItem WorkGroup = "item"
+	ByTag  WorkGroup = "tag"
+	ByDay  WorkGroup = "day"
+)
+
+// ParseWorkGroup checks the name of a WorkGroup
+func ParseWorkGroup(name string) (WorkGroup, error) {
+	switch g := WorkGroup(name); g {
+	case ByItem, ByTag, ByDay:
+		return g, nil
+	}
+	return "", fmt.Errorf("unknown report group %q, use item, tag or day", name)
+}
+
+// WorkTotal is the time worked on one item, on the items with one tag,
+// or on one day.  Name is the item's title, the tag, "" for the items
+// without one, or the day as YYYY-MM-DD.  Id is the item's, when
+// grouped by item.
+type WorkTotal struct {
+	Id   int
+	Name string
+	Time time.Duration
+}
+
+// RunningTimer returns the item whose timer is running, and whether
+// there is one.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The item will be returned, if its timer is running
+//		(2) If there is an error, it will be returned
+//		(3) The database file will not be modified
+func (t *ToDo) RunningTimer() (ToDoItem, bool, error) {
+	var item ToDoItem
+	var found bool
+	err := t.viewDB(func() error {
+		if running := runningTimers(t.toDoMap); len(running) > 0 {
+			item, found = t.toDoMap[running[0]], true
+		}
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, false, fmt.Errorf("RunningTimer: %w", err)
+	}
+
+	return item, found, nil
+}
+
+// StartTimer starts timing the work on an item, stopping the timer that
+// was running, if there was one.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB, and be open
+//
+// Postconditions:
+//
+//	 (1) The item will have a running timer, and no other will
+//		(2) The id of the item whose timer was stopped will be
+//			returned, 0 if none was running
+//		(3) If there is an error, it will be returned and no timer
+//			will be started or stopped
+func (t *ToDo) StartTimer(id int) (int, error) {
+	return t.StartTimerContext(context.Background(), id)
+}
+
+// StartTimerContext is StartTimer, giving up with ctx's error if ctx
+// is done before the timer is saved
+func (t *ToDo) StartTimerContext(ctx context.Context, id int) (int, error) {
+	var stopped int
+	err := t.updateContext(ctx, "start", func(tx *Tx) error {
+		var err error
+		stopped, err = tx.StartTimer(id)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("StartTimer: %w", err)
+	}
+
+	return stopped, nil
+}
+
+// StopTimer stops the timer running on an item, or whichever timer is
+// running if id is 0.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item's timer must be running
+//
+// Postconditions:
+//
+//	 (1) The id of the item whose timer was stopped will be
+//			returned, its last interval being the one that ended
+//		(2) If no timer was running, an error wrapping ErrNoTimer
+//			will be returned
+//		(3) If there is an error, it will be returned
+func (t *ToDo) StopTimer(id int) (int, error) {
+	return t.StopTimerContext(context.Background(), id)
+}
+
+// StopTimerContext is StopTimer, giving up with ctx's error if ctx is
+// done before the timer is saved
+func (t *ToDo) StopTimerContext(ctx context.Context, id int) (int, error) {
+	var stopped int
+	err := t.updateContext(ctx, "stop", func(tx *Tx) error {
+		var err error
+		stopped, err = tx.StopTimer(id)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("StopTimer: %w", err)
+	}
+
+	return stopped, nil
+}
+
+// Report sums the time worked between from and to by group, counting
+// only the part of each interval in that time, and a running timer up
+// to now.  A zero from or to leaves that end open.  Days run from
+// midnight to midnight in loc.  An item with several tags counts in
+// full for each of them.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The totals will be returned, by id, tag or day, leaving
+//			out the ones with no time, and the items without a tag
+//			last
+//		(2) If there is an error, it will be returned
+//		(3) The database file will not be modified
+func (t *ToDo) Report(by WorkGroup, from, to time.Time, loc *time.Location) ([]WorkTotal, error) {
+	return t.ReportContext(context.Background(), by, from, to, loc)
+}
+
+// ReportContext is Report, giving up with ctx's error if ctx is done
+// before the database is read
+func (t *ToDo) ReportContext(ctx context.Context, by WorkGroup, from, to time.Time, loc *time.Location) ([]WorkTotal, error) {
+	if _, err := ParseWorkGroup(string(by)); err != nil {
+		return nil, fmt.Errorf("Report: %w", err)
+	}
+
+	now := timeNow()
+	totals := make(map[string]*WorkTotal)
+	add := func(key string, total WorkTotal) {
+		if found, ok := totals[key]; ok {
+			found.Time += total.Time
+			return
+		}
+		totals[key] = &total
+	}
+
+	err := t.viewContext(ctx, func() error {
+		for _, item := range t.toDoMap {
+			for _, interval := range item.Work {
+				start, end := interval.Start, now
+				if interval.End != nil {
+					end = *interval.End
+				}
+				if !from.IsZero() && start.Before(from) {
+					start = from
+				}
+				if !to.IsZero() && end.After(to) {
+					end = to
+				}
+				if !start.Before(end) {
+					continue
+				}
+
+				switch by {
+				case ByItem:
+					add(fmt.Sprint(item.Id), WorkTotal{Id: item.Id, Name: item.Title, Time: end.Sub(start)})
+				case ByTag:
+					if len(item.Tags) == 0 {
+						add("", WorkTotal{Time: end.Sub(start)})
+					}
+					for _, tag := range item.Tags {
+						add(tag, WorkTotal{Name: tag, Time: end.Sub(start)})
+					}
+				case ByDay:
+					for start.Before(end) {
+						y, m, d := start.In(loc).Date()
+						dayEnd := time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+						day := start.In(loc).Format("2006-01-02")
+						if dayEnd.After(end) {
+							dayEnd = end
+						}
+						add(day, WorkTotal{Name: day, Time: dayEnd.Sub(start)})
+						start = dayEnd
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Report: %w", err)
+	}
+
+	report := make([]WorkTotal, 0, len(totals))
+	for _, total := range totals {
+		report = append(report, *total)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		switch {
+		case by == ByItem:
+			return a.Id < b.Id
+		case a.Name == "" || b.Name == "":
+			return a.Name != ""
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
+
+// runningTimers returns the ids of the items in items whose timer is
+// running, in order.  There is only one, unless the file has been
+// edited by hand or an item that was being timed marked done and the
+// change undone.
+func runningTimers(items DbMap) []int {
+	var ids []int
+	for id, item := range items {
+		if timing(item) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// timing reports whether item's timer is running
+func timing(item ToDoItem) bool {
+	return len(item.Work) > 0 && item.Work[len(item.Work)-1].End == nil
+}
+
+// startTimer starts the timer on item id, stopping any other, and
+// returns the id of the item stopped
+func (t *ToDo) startTimer(id int) (int, error) {
+	oldItem, found := t.toDoMap[id]
+	switch {
+	case !found:
+		return 0, notFound(id)
+	case oldItem.IsDone:
+		return 0, &ItemError{Id: id, Err: ErrItemDone}
+	case timing(oldItem):
+		return 0, &ItemError{Id: id, Err: ErrTimerRunning}
+	}
+
+	running := runningTimers(t.toDoMap)
+	for _, other := range running {
+		t.stopTimer(other)
+	}
+
+	item := oldItem
+	item.Work = append(slices.Clip(oldItem.Work), Interval{Start: timeNow()})
+	t.toDoMap[id] = stampUpdate(oldItem, item)
+
+	if len(running) == 0 {
+		return 0, nil
+	}
+	return running[0], nil
+}
+
+// stopTimer ends the running interval on item id, if it has one,
+// reporting whether it did
+func (t *ToDo) stopTimer(id int) bool {
+	oldItem := t.toDoMap[id]
+	if !timing(oldItem) {
+		return false
+	}
+
+	now := timeNow()
+	item := oldItem
+	item.Work = slices.Clone(oldItem.Work)
+	item.Work[len(item.Work)-1].End = &now
+	t.toDoMap[id] = stampUpdate(oldItem, item)
+	return true
+}
@@ -1,17 +1,47 @@
 package db
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"math/rand"
 	"os"
+	"sync"
+	"time"
 )
 
 // ToDoItem is the struct that represents a single ToDo item
+//
+// Everything after IsDone is optional and left out of the JSON when
+// unset.  Priority 0 means no priority, higher numbers are more
+// urgent.  CreatedAt, UpdatedAt and CompletedAt are maintained by the
+// db package: AddItem fills in CreatedAt when it is missing, every
+// write sets UpdatedAt, and CompletedAt is set when the item is marked
+// done and cleared when it is marked not done.  Repeat makes the item
+// come back when it is done, see Recurrence.  ParentId makes the item
+// a subtask of another one, and BlockedBy lists the items that have to
+// be done first.
 type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
+	Id          int        `json:"id"`
+	Title       string     `json:"title"`
+	IsDone      bool       `json:"done"`
+	DueDate     *time.Time `json:"due,omitempty"`
+	Repeat      Recurrence `json:"repeat,omitempty"`
+	Priority    int        `json:"priority,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Notes       string     `json:"notes,omitempty"`
+	Assignee    string     `json:"assignee,omitempty"`
+	List        string     `json:"list,omitempty"`
+	ParentId    int        `json:"parent,omitempty"`
+	BlockedBy   []int      `json:"blocked_by,omitempty"`
+	Work        []Interval `json:"work,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
 
 // DbMap is a type alias for a map of ToDoItems.  The key
@@ -31,41 +61,72 @@ type DbMap map[int]ToDoItem
 // our package mutating the fields after they've been created.  Mutable shared
 // state is the root of all evil.  This forces outside users to treat it as
 // opaque and use it through our provided API alone.
-
+//
+// The mutex serializes access to toDoMap between goroutines sharing
+// one ToDo.  Other processes (and other ToDo values using the same
+// storage) are kept out by the store lock taken in modifyDB.
+//
+// lastId is the high-water mark for item ids handed out by AddItem.
+// It is persisted in the store so ids of deleted items are never
+// given out again.
+//
+// snapshotPolicy says how many of the snapshots taken before each
+// write are kept, see SnapshotPolicy.
+//
+// version is the version of a VersionedStore that toDoMap and lastId
+// hold, 0 if they have to be loaded again.  Anything that leaves them
+// different from what is in the store must reset it.
+//
+// hooksDir is where the hooks run by writes are, see SetHooksDir.
 type ToDo struct {
-	toDoMap    DbMap
-	dbFileName string
+	mu             sync.Mutex
+	toDoMap        DbMap
+	lastId         int
+	version        uint64
+	store          Store
+	snapshotPolicy SnapshotPolicy
+	hooksDir       string
 }
 
 // New is a constructor function that returns a pointer to a new
 // ToDo struct.  It takes a single string argument that is the
-// name of the file that will be used to store the ToDo items.
+// name of the JSON file that will be used to store the ToDo items.
 // If the file doesn't exist, it will be created.  If the file
 // does exist, it will be loaded into the ToDo struct.
 func New(dbFile string) (*ToDo, error) {
+	store, err := NewJsonStore(dbFile)
+	if err != nil {
+		return nil, err
+	}
 
-	//Check if the database file exists, if not use initDB to create it
-	//In go, you use the os.Stat function to get information about a file
-	//In this case, we are only checking the error, because if we get an
-	//error we can safely assume that this file does not exist.
-	if _, err := os.Stat(dbFile); err != nil {
-		//If the file doesn't exist, create it
-		err := initDB(dbFile)
-		if err != nil {
-			return nil, err
-		}
+	return NewWithStore(store), nil
+}
+
+// Open is like New, but takes a dsn naming the storage backend to use,
+// such as "json:./data/todo.json", "bolt:./todo.db" or "mem:".  See
+// OpenStore for the details.
+func Open(dsn string) (*ToDo, error) {
+	store, err := OpenStore(dsn)
+	if err != nil {
+		return nil, err
 	}
 
-	//Now that we know the file exists, at at the minimum we have
-	//a valid empty DB, lets create the ToDo struct
-	toDo := &ToDo{
-		toDoMap:    make(map[int]ToDoItem),
-		dbFileName: dbFile,
+	return NewWithStore(store), nil
+}
+
+// NewWithStore returns a ToDo that keeps its items in store
+func NewWithStore(store Store) *ToDo {
+	return &ToDo{
+		toDoMap:        make(DbMap),
+		store:          store,
+		snapshotPolicy: DefaultSnapshotPolicy,
 	}
+}
 
-	// We should be all set here, the ToDo struct is ready to go
-	// so we can support the public database operations
-	return toDo, nil
+// Close releases the storage backend.  The ToDo must not be used
+// afterwards.
+func (t *ToDo) Close() error {
+	return t.store.Close()
 }
 
 // RestoreDB copies the backup file to the db file. This is useful for testing
@@ -84,10 +145,28 @@ func New(dbFile string) (*ToDo, error) {
 // in the ./data directory.  Note this should overwrite the
 // existing todo.json file if it exists, or create it if it
 // does not exist.
+//
+// The backup is always a JSON file, in any schema version and encrypted
+// or not, and it is loaded into whatever store this ToDo uses.  The store has to be a
+// FileStore, otherwise there is nowhere to look for the backup.
 func (t *ToDo) RestoreDB() error {
+	return t.RestoreDBContext(context.Background())
+}
+
+// RestoreDBContext is RestoreDB, giving up with ctx's error if ctx is
+// done before the restored database is saved
+func (t *ToDo) RestoreDBContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("RestoreDB: %w", err)
+	}
+	fileStore, ok := t.store.(FileStore)
+	if !ok {
+		return errors.New("RestoreDB: the database is not kept in a file, so it has no backup")
+	}
+
 	//Copy the backup file to the db file
-	dbFileName := t.dbFileName
-	backupFileName := t.dbFileName + ".bak"
+	dbFileName := fileStore.FileName()
+	backupFileName := dbFileName + ".bak"
 
 	//Copy the backup file to the db file
 	//HINT: research the os package, specifically the Open, Create, and Copy functions
@@ -106,8 +185,8 @@ func (t *ToDo) RestoreDB() error {
 	//				defer srcFile.Close()
 
 	// DONE: Implement this function
-	fmt.Println("DB File:", dbFileName)
-	fmt.Println("Backup DB File:", backupFileName)
+	fmt.Fprintln(os.Stderr, "DB File:", dbFileName)
+	fmt.Fprintln(os.Stderr, "Backup DB File:", backupFileName)
 
 	backupFile,err := os.Open(backupFileName)
 	if err != nil {
@@ -115,15 +194,23 @@ func (t *ToDo) RestoreDB() error {
 	}
 	defer backupFile.Close()
 
-	dbFile,err := os.Create(dbFileName)
+	data, err := io.ReadAll(backupFile)
+	if err != nil {
+		return fmt.Errorf("RestoreDB: error copying file: %w", err)
+	}
+	data, err = t.sealing().open(data)
+	if err != nil {
+		return fmt.Errorf("RestoreDB: error decrypting backup file: %w", err)
+	}
+
+	backup, err := decodeDB(backupFileName, data)
 	if err != nil {
-		return fmt.Errorf("RestoreDB: error opening db file: %w",err)
+		return fmt.Errorf("RestoreDB: error reading backup file: %w", err)
 	}
-	defer dbFile.Close()
 
-	_,err = io.Copy(dbFile,backupFile)	
+	err = t.restoreContents(ctx, backup)
 	if err != nil {
-		return fmt.Errorf("RestoreDB: error copying file: %w",err)
+		return fmt.Errorf("RestoreDB: %w", err)
 	}
 
 	return nil
@@ -133,7 +220,10 @@ func (t *ToDo) RestoreDB() error {
 // THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
 //------------------------------------------------------------
 
-// AddItem accepts a ToDoItem and adds it to the DB.
+// AddItem accepts a ToDoItem and adds it to the DB.  If item.Id is
+// zero (or was left out of the JSON) the next free id is assigned,
+// one past the highest id ever stored in this DB, so ids of deleted
+// items are never reused.
 // Preconditions:   (1) The database file must exist and be a valid
 //
 //					(2) The item must not already exist in the DB
@@ -145,8 +235,9 @@ func (t *ToDo) RestoreDB() error {
 //
 //	 (1) The item will be added to the DB
 //		(2) The DB file will be saved with the item added
-//		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
+//		(3) The id the item was stored under is returned
+//		(4) If there is an error, it will be returned
+func (t *ToDo) AddItem(item ToDoItem) (int, error) {
 	//DONE: Implement this function
 	//Start by loading the database into the private map in our struct
 	//see the loadDB() helper.  Then make sure the item we want to load
@@ -158,24 +249,23 @@ func (t *ToDo) AddItem(item ToDoItem) error {
 	//If everything there are no errors, this function should return nil
 	//at the end to indicate that the item was properly added to the
 	//database.
-	err := t.loadDB()
-	if err != nil {
-		return fmt.Errorf("AddItem: error loading DB: %w",err)
-	}
-	
-	_,found := t.toDoMap[item.Id]
-	if found {
-		return fmt.Errorf("AddItem: item %d already exists",item.Id)
-	}
-
-	t.toDoMap[item.Id] = item
+	return t.AddItemContext(context.Background(), item)
+}
 
-	err = t.saveDB()
+// AddItemContext is AddItem, giving up with ctx's error if ctx is done
+// before the item is saved
+func (t *ToDo) AddItemContext(ctx context.Context, item ToDoItem) (int, error) {
+	var id int
+	err := t.updateContext(ctx, "add", func(tx *Tx) error {
+		var err error
+		id, err = tx.AddItem(item)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("AddItem: error saving DB: %w",err)
+		return 0, fmt.Errorf("AddItem: %w", err)
 	}
 
-	return nil
+	return id, nil
 }
 
 // DeleteItem accepts an item id and removes it from the DB.
@@ -186,12 +276,21 @@ func (t *ToDo) AddItem(item ToDoItem) error {
 //						function must check if the item already
 //	    				exists in the DB, if not, return an error
 //
+//					(3) The item must have no subtasks, use
+//						DeleteItemMode to delete items that do
+//
 // Postconditions:
 //
 //	 (1) The item will be removed from the DB
 //		(2) The DB file will be saved with the item removed
 //		(3) If there is an error, it will be returned
 func (t *ToDo) DeleteItem(id int) error {
+	return t.DeleteItemContext(context.Background(), id)
+}
+
+// DeleteItemContext is DeleteItem, giving up with ctx's error if ctx is
+// done before the deletion is saved
+func (t *ToDo) DeleteItemContext(ctx context.Context, id int) error {
 	//DONE: Implement this function
 	//Like the add item function, start by loading the database into the
 	//private map in our struct.  Then make sure the item we want to delete
@@ -204,21 +303,11 @@ func (t *ToDo) DeleteItem(id int) error {
 	//return nil at the end to indicate that the item was properly deleted
 	//from the database.
 
-	err := t.loadDB()
-	if err != nil {
-		return fmt.Errorf("DeleteItem: error loading DB: %w",err)
-	}
-	
-	_,found := t.toDoMap[id]
-	if !found {
-		return fmt.Errorf("DeleteItem: item %d does not exist",id)
-	}
-
-	delete(t.toDoMap,id)
-
-	err = t.saveDB()
+	err := t.updateContext(ctx, "delete", func(tx *Tx) error {
+		return tx.DeleteItem(id)
+	})
 	if err != nil {
-		return fmt.Errorf("DeleteItem: error saving DB: %w",err)
+		return fmt.Errorf("DeleteItem: %w", err)
 	}
 
 	return nil
@@ -249,22 +338,18 @@ func (t *ToDo) UpdateItem(item ToDoItem) error {
 	//any errors, return them, as appropriate.  If everything there are
 	//no errors, this function should return nil at the end to indicate
 	//that the item was properly updated in the database.
-	 
-	err := t.loadDB()
-	if err != nil {
-		return fmt.Errorf("UpdateItem: error loading DB: %w",err)
-	}
-	
-	_,found := t.toDoMap[item.Id]
-	if !found {
-		return fmt.Errorf("UpdateItem: item %d does not exist",item.Id)
-	}
 
-	t.toDoMap[item.Id] = item
+	return t.UpdateItemContext(context.Background(), item)
+}
 
-	err = t.saveDB()
+// UpdateItemContext is UpdateItem, giving up with ctx's error if ctx is
+// done before the item is saved
+func (t *ToDo) UpdateItemContext(ctx context.Context, item ToDoItem) error {
+	err := t.updateContext(ctx, "update", func(tx *Tx) error {
+		return tx.UpdateItem(item)
+	})
 	if err != nil {
-		return fmt.Errorf("UpdateItem: error saving DB: %w",err)
+		return fmt.Errorf("UpdateItem: %w", err)
 	}
 
 	return nil
@@ -297,17 +382,26 @@ func (t *ToDo) GetItem(id int) (ToDoItem, error) {
 	//as the error value the end to indicate that the item was
 	//properly returned from the database.
 
-	err := t.loadDB()
+	return t.GetItemContext(context.Background(), id)
+}
+
+// GetItemContext is GetItem, giving up with ctx's error if ctx is done
+// before the database is read
+func (t *ToDo) GetItemContext(ctx context.Context, id int) (ToDoItem, error) {
+	var item ToDoItem
+	err := t.viewContext(ctx, func() error {
+		var found bool
+		item, found = t.toDoMap[id]
+		if !found {
+			return notFound(id)
+		}
+		return nil
+	})
 	if err != nil {
-		return ToDoItem{},fmt.Errorf("GetItem: error loading DB: %w",err)
-	}
-	
-	item,found := t.toDoMap[id]
-	if !found {
-		return ToDoItem{},fmt.Errorf("GetItem: item %d does not exist",item.Id)
+		return ToDoItem{}, fmt.Errorf("GetItem: %w", err)
 	}
 
-	return item,nil
+	return item, nil
 }
 
 // GetAllItems returns all items from the DB.  If successful it
@@ -332,18 +426,24 @@ func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 	//Finally, if there were no errors along the way, return the slice
 	//and nil as the error value.
 
-	err := t.loadDB()
-	if err != nil {
-		return nil, fmt.Errorf("GetAllItems: error loading DB: %w",err)
-	}		
+	return t.GetAllItemsContext(context.Background())
+}
 
+// GetAllItemsContext is GetAllItems, giving up with ctx's error if ctx
+// is done before the database is read
+func (t *ToDo) GetAllItemsContext(ctx context.Context) ([]ToDoItem, error) {
 	var items []ToDoItem
-
-	for _,item := range t.toDoMap {
-		items = append(items, item)
+	err := t.viewContext(ctx, func() error {
+		for _, item := range t.toDoMap {
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetAllItems: %w", err)
 	}
 
-	return items,nil
+	return items, nil
 }
 
 // PrintItem accepts a ToDoItem and prints it to the console
@@ -393,11 +493,22 @@ func (t *ToDo) JsonToItem(jsonString string) (ToDoItem, error) {
 //
 //	 (1) The items status in the database will be updated
 //		(2) If there is an error, it will be returned.
-//		(3) This function MUST use existing functionality for most of its
-//			work.  For example, it should call GetItem() to get the item
-//			from the DB, then it should call UpdateItem() to update the
-//			item in the DB (after the status is changed).
+//		(3) The read of the item and the write of its new status happen
+//			inside a single locked load-modify-save cycle, so a
+//			concurrent writer cannot slip in between them.
+//		(4) If a repeating item is marked done, its next occurrence
+//			is added in the same write.  The repeat rule moves to
+//			the new item, so marking the old one done again
+//			doesn't add another.
+//		(5) An item can't be marked done while items that block it
+//			are open, see ChangeDoneStatus to force it
 func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
+	return t.ChangeItemDoneStatusContext(context.Background(), id, value)
+}
+
+// ChangeItemDoneStatusContext is ChangeItemDoneStatus, giving up with
+// ctx's error if ctx is done before the change is saved
+func (t *ToDo) ChangeItemDoneStatusContext(ctx context.Context, id int, value bool) error {
 	//DONE: Implement this function for EXTRA CREDIT if you want
 	//This function builds on all of the other functions you have
 	//implemented.  It should call GetItem() to get the item from
@@ -405,19 +516,97 @@ func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
 	//in the DB (after the status is changed).  If there are any
 	//errors along the way, return them.  If everything is successful
 	//return nil at the end to indicate that the item was properly
+	//
+	//NOTE: Calling GetItem() and then UpdateItem() takes the lock twice,
+	//so another process could change the item between the two calls and
+	//have its change silently overwritten.  We do both steps inside one
+	//modifyDB() cycle instead.
+
+	err := t.changeDoneStatus(ctx, id, value, false, false)
+	if err != nil {
+		return fmt.Errorf("ChangeItemDoneStatus: %w", err)
+	}
+
+	return nil
+}
+
+// ChangeDoneStatus is ChangeItemDoneStatus with two options: children
+// changes the status of the item's subtasks too, all the way down, and
+// force marks items done even if they are blocked by open items.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//
+// Postconditions:
+//
+//	 (1) The status of the item, and its subtasks if children is
+//			set, will be updated in a single write
+//		(2) Repeating items that are marked done repeat, as with
+//			ChangeItemDoneStatus
+//		(3) Unless force is set, the write fails with ErrBlocked if
+//			an item marked done is blocked by an item left open.
+//			Blockers marked done in the same write don't count.
+//		(4) If there is an error, it will be returned and
+//			nothing will be changed
+func (t *ToDo) ChangeDoneStatus(id int, value, children, force bool) error {
+	return t.ChangeDoneStatusContext(context.Background(), id, value, children, force)
+}
 
-	item,err := t.GetItem(id)
+// ChangeDoneStatusContext is ChangeDoneStatus, giving up with ctx's
+// error if ctx is done before the change is saved
+func (t *ToDo) ChangeDoneStatusContext(ctx context.Context, id int, value, children, force bool) error {
+	err := t.changeDoneStatus(ctx, id, value, children, force)
 	if err != nil {
-		return fmt.Errorf("ChangeItemDoneStatus: %w",err)
+		return fmt.Errorf("ChangeDoneStatus: %w", err)
+	}
+
+	return nil
+}
+
+// changeDoneStatus does the work of ChangeDoneStatusContext, leaving
+// the error for the method called to wrap
+func (t *ToDo) changeDoneStatus(ctx context.Context, id int, value, children, force bool) error {
+	op := "undone"
+	if value {
+		op = "done"
+	}
+	return t.updateContext(ctx, op, func(tx *Tx) error {
+		return tx.ChangeDoneStatus(id, value, children, force)
+	})
+}
+
+// setDone does the work of ChangeItemDoneStatus on t.toDoMap
+func (t *ToDo) setDone(id int, value bool) error {
+	oldItem, found := t.toDoMap[id]
+	if !found {
+		return notFound(id)
 	}
 
+	//Finishing an item stops its timer
+	if value && t.stopTimer(id) {
+		oldItem = t.toDoMap[id]
+	}
+
+	item := oldItem
 	item.IsDone = value
+	if !value || oldItem.IsDone || item.Repeat == "" {
+		t.toDoMap[id] = stampUpdate(oldItem, item)
+		return nil
+	}
 
-	err = t.UpdateItem(item)
+	next, err := nextOccurrence(item, timeNow())
 	if err != nil {
-		return fmt.Errorf("ChangeItemDoneStatus: %w",err)
+		return fmt.Errorf("item %d: %w", id, err)
+	}
+	next.Id, err = nextId(t.lastId)
+	if err != nil {
+		return err
 	}
 
+	item.Repeat = ""
+	t.toDoMap[id] = stampUpdate(oldItem, item)
+	t.toDoMap[next.Id] = stampNew(next)
+	t.lastId = next.Id
 	return nil
 }
 
@@ -425,75 +614,349 @@ func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
 // THESE ARE HELPER FUNCTIONS THAT ARE NOT EXPORTED AKA PRIVATE
 //------------------------------------------------------------
 
-// initDB is a helper function that creates a new file with an
-// empty json array.  This is used to make sure that the DB
-// file exists for operations on our ToDo struct.  This function
-// should be called by the New() function if the DB file doesn't
-// exist.  Notice this function does not have a receiver as its
-// used by New() to create the DB file
-func initDB(dbFileName string) error {
-	f, err := os.Create(dbFileName)
-	if err != nil {
+// modifyDB runs a single load-modify-save cycle.  It holds the ToDo
+// mutex and the store lock from before loadDB until after saveDB, so
+// concurrent writers - goroutines or other todo processes - cannot
+// lose each other's changes.  If fn returns an error nothing is saved
+// and the error is returned as is.  fn is run again if the save
+// conflicts (see ErrConflict), so it must only change t.toDoMap and
+// t.lastId, or results it sets in full each time.  The items fn changes are recorded
+// in the journal under op, see JournalEntry.
+func (t *ToDo) modifyDB(op string, fn func() error) error {
+	return t.writeDB(context.Background(), &JournalEntry{Op: op}, fn)
+}
+
+// writeDB is modifyDB for callers that need to fill in more of the
+// journal entry than its op, or give a context.  It runs the hooks for
+// the changes fn makes, see SetHooksDir.  Once ctx is done, it gives up
+// with ctx's error at the next step: waiting for the store lock,
+// loading, running pre-hooks or saving.  A write that has been saved
+// is never undone.
+func (t *ToDo) writeDB(ctx context.Context, entry *JournalEntry, fn func() error) error {
+	return t.writeDBSaved(ctx, entry, fn, nil)
+}
+
+// writeDBSaved is writeDB, calling saved, if it isn't nil, once the
+// write is saved.  The locks are still held then, so saved sees the
+// items as the pre-hooks left them and nobody has changed them since.
+func (t *ToDo) writeDBSaved(ctx context.Context, entry *JournalEntry, fn func() error, saved func() error) error {
+	//On-hooks run once the write is saved and the locks are released,
+	//so they can use the database themselves
+	var hooks map[string]string
+	var changes []Change
+	defer func() {
+		runOnHooks(hooks, entry.Op, changes)
+	}()
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hooks = findHooks(t.hooksDir)
 
-	// Given we are working with a json array as our DB structure
-	// we should initialize the file with an empty array, which
-	// in json is represented as "[]
-	_, err = f.Write([]byte("[]"))
+	unlock, err := lockStore(ctx, t.store)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	//Stores that can't lock, like HttpStore, report a save that lost a
+	//race with ErrConflict instead.  Then the whole cycle is run again
+	//on what the store holds now, after a short, random wait so two
+	//writers don't keep colliding.
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err = t.loadDB(ctx)
+		if err != nil {
+			return &StoreError{Op: "loading", Err: err}
+		}
 
-	f.Close()
+		//A failed write leaves the map as it was loaded, so nothing fn
+		//did before failing is saved by a later write
+		before := Contents{LastId: t.lastId, Items: maps.Clone(t.toDoMap)}
+		err = fn()
+		if err == nil {
+			err = t.runPreHooks(ctx, hooks, entry.Op, before.Items)
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			t.toDoMap, t.lastId = before.Items, before.LastId
+			return err
+		}
 
-	return nil
+		//If the commit fails it isn't clear what the store holds, so
+		//the next load reads it again rather than trusting the map
+		changes, err = t.commitDB(ctx, entry, before)
+		if err != nil {
+			t.version = 0
+		}
+		if err == nil && saved != nil {
+			return saved()
+		}
+		if !errors.Is(err, ErrConflict) || attempt == maxConflictRetries {
+			return err
+		}
+
+		time.Sleep(time.Duration(rand.Int63n(int64(attempt) * int64(10*time.Millisecond))))
+	}
 }
 
-func (t *ToDo) saveDB() error {
-	//1. Convert our map into a slice
-	//2. Marshal the slice into json
-	//3. Write the json to our file
+// commitDB finishes a write that turned before into t.toDoMap, and
+// returns the changes it made.  The store lock must be held.  If
+// anything changed, the database is snapshotted as it was, then saved,
+// and the changes are journaled.
+func (t *ToDo) commitDB(ctx context.Context, entry *JournalEntry, before Contents) ([]Change, error) {
+	changes := diffItems(before.Items, t.toDoMap)
+	if len(changes) > 0 {
+		err := t.autoSnapshot(before)
+		if err != nil {
+			return nil, fmt.Errorf("error taking snapshot: %w", err)
+		}
+	}
 
-	//1. Convert our map into a slice
-	var toDoList []ToDoItem
-	for _, item := range t.toDoMap {
-		toDoList = append(toDoList, item)
+	err := t.saveDB(ctx, entry.Op)
+	if err != nil {
+		return nil, &StoreError{Op: "saving", Err: err}
+	}
+
+	//What was just saved is what the map holds, so there is no need to
+	//load it again
+	if versioned, ok := t.store.(VersionedStore); ok {
+		t.version, err = versioned.Version()
+		if err != nil {
+			t.version = 0
+		}
 	}
 
-	//2. Marshal the slice into json, lets pretty print it, but
-	//   this is not required
-	data, err := json.MarshalIndent(toDoList, "", "  ")
+	//The change is made once it is saved, so a journal that can't be
+	//written only costs the undo, and the caller must still be told the
+	//write succeeded, or it might make it again
+	err = t.journalWrite(entry, changes)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the change was saved but can't be undone, error writing journal: %v\n", err)
+	}
+
+	return changes, nil
+}
+
+// restoreContents replaces everything in the DB with backup.  Restoring
+// is a write like any other, so it takes the same locks as modifyDB.
+// The current id high-water mark is kept if it is ahead of the
+// backup's, so ids handed out since the backup was taken are not
+// reused.  A database that can't be read is a good reason to restore,
+// so that is not treated as an error here.
+func (t *ToDo) restoreContents(ctx context.Context, backup Contents) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	//3. Write the json to our file
-	err = os.WriteFile(t.dbFileName, data, 0644)
+	unlock, err := lockStore(ctx, t.store)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	return nil
-}
+	before := Contents{Items: make(DbMap)}
+	current, err := t.store.Load()
+	if err == nil {
+		t.lastId = max(t.lastId, current.LastId)
+		before = current
+	}
+
+	t.toDoMap = backup.Items
+	t.lastId = max(t.lastId, backup.LastId)
+	for id := range t.toDoMap {
+		t.lastId = max(t.lastId, id)
+	}
 
-func (t *ToDo) loadDB() error {
-	data, err := os.ReadFile(t.dbFileName)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err = t.commitDB(ctx, &JournalEntry{Op: "restore"}, before)
 	if err != nil {
+		t.version = 0
+	}
+	return err
+}
+
+// viewDB is the read-only counterpart of modifyDB.  It loads the DB
+// and runs fn while holding the ToDo mutex, but takes no store lock and
+// saves nothing, so fn must leave t.toDoMap and t.lastId alone.
+func (t *ToDo) viewDB(fn func() error) error {
+	return t.viewContext(context.Background(), fn)
+}
+
+// viewContext is viewDB, giving up with ctx's error if ctx is done
+// before the DB is loaded
+func (t *ToDo) viewContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	//Now let's unmarshal the data into our map
-	var toDoList []ToDoItem
-	err = json.Unmarshal(data, &toDoList)
+	err := t.loadDB(ctx)
 	if err != nil {
+		return &StoreError{Op: "loading", Err: err}
+	}
+
+	return fn()
+}
+
+// lockStore takes the store lock, like store.Lock, unless ctx is done
+// first.  Then the lock is given back as soon as it is granted, which
+// for a file lock held by another process may be a while.
+func lockStore(ctx context.Context, store Store) (func() error, error) {
+	type locked struct {
+		unlock func() error
+		err    error
+	}
+	granted := make(chan locked, 1)
+	go func() {
+		unlock, err := store.Lock()
+		granted <- locked{unlock, err}
+	}()
+
+	select {
+	case lock := <-granted:
+		if lock.err != nil {
+			return nil, &StoreError{Op: "locking", Err: lock.err}
+		}
+		return lock.unlock, nil
+	case <-ctx.Done():
+		go func() {
+			if lock := <-granted; lock.err == nil {
+				lock.unlock()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// saveDB saves the map for a write journaled under op.  An HttpStore
+// passes op on, so the server journals the write as what it was.  A
+// ContextStore gives up on the save once ctx is done.
+func (t *ToDo) saveDB(ctx context.Context, op string) error {
+	contents := Contents{LastId: t.lastId, Items: t.toDoMap}
+	switch store := t.store.(type) {
+	case *HttpStore:
+		return store.saveOp(ctx, contents, op)
+	case ContextStore:
+		return store.SaveContext(ctx, contents)
+	}
+	return t.store.Save(contents)
+}
+
+// timeNow is the clock used to stamp items.  It is truncated to whole
+// seconds in UTC so timestamps stay readable in the JSON file and
+// compare equal after a round trip through it.
+func timeNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// nextId returns the id to give a new item when lastId is the highest
+// id handed out so far
+func nextId(lastId int) (int, error) {
+	if lastId == math.MaxInt {
+		return 0, errors.New("no free item ids left")
+	}
+	return lastId + 1, nil
+}
+
+// stampNew returns item, which is about to be added, with any missing
+// timestamps filled in
+func stampNew(item ToDoItem) ToDoItem {
+	now := timeNow()
+	if item.CreatedAt == nil {
+		item.CreatedAt = &now
+	}
+	if item.UpdatedAt == nil {
+		item.UpdatedAt = &now
+	}
+	if item.IsDone && item.CompletedAt == nil {
+		item.CompletedAt = &now
+	}
+	return item
+}
+
+// stampUpdate returns newItem, the replacement for oldItem, with the
+// timestamps the db package owns brought up to date.  The time worked
+// is kept if newItem leaves it out, as it is StartTimer and StopTimer
+// that record it.
+func stampUpdate(oldItem, newItem ToDoItem) ToDoItem {
+	now := timeNow()
+
+	if newItem.CreatedAt == nil {
+		newItem.CreatedAt = oldItem.CreatedAt
+	}
+	if newItem.Work == nil {
+		newItem.Work = oldItem.Work
+	}
+	newItem.UpdatedAt = &now
+
+	switch {
+	case !newItem.IsDone:
+		newItem.CompletedAt = nil
+	case oldItem.IsDone && newItem.CompletedAt == nil:
+		newItem.CompletedAt = oldItem.CompletedAt
+	case newItem.CompletedAt == nil:
+		newItem.CompletedAt = &now
+	}
+
+	return newItem
+}
+
+// loadDB makes t.toDoMap and t.lastId match what is in the store.  A
+// VersionedStore is only loaded if it has changed since the last time,
+// and a ContextStore gives up on the load once ctx is done.
+func (t *ToDo) loadDB(ctx context.Context) error {
+	var version uint64
+	if versioned, ok := t.store.(VersionedStore); ok {
+		var err error
+		version, err = versioned.Version()
+		if err != nil {
+			return err
+		}
+		if version != 0 && version == t.version {
+			return nil
+		}
+	}
+
+	//The version is taken before loading, so if the store changes in
+	//between, the next load just reads it again
+	var contents Contents
+	var err error
+	if store, ok := t.store.(ContextStore); ok {
+		contents, err = store.LoadContext(ctx)
+	} else {
+		contents, err = t.store.Load()
+	}
+	if err != nil {
+		t.version = 0
 		return err
 	}
 
-	//Now let's iterate over our slice and add each item to our map
-	for _, item := range toDoList {
-		t.toDoMap[item.Id] = item
+	//The map is replaced, not added to, so items another process
+	//deleted don't linger
+	t.toDoMap = contents.Items
+	if t.toDoMap == nil {
+		t.toDoMap = make(DbMap)
+	}
+
+	//Finally pick up the id high-water mark.  A DB that predates it,
+	//or whose mark is behind, falls back to the highest id in use.
+	t.lastId = contents.LastId
+	for id := range t.toDoMap {
+		t.lastId = max(t.lastId, id)
 	}
 
+	t.version = version
 	return nil
 }
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Items form a tree through ParentId: an item with a ParentId is a
+// subtask of that item, and one without is at the top level.  Every
+// write that sets a parent checks that it exists and that the item
+// isn't made an ancestor of itself.
+
+// ErrBadParent is returned when an item's parent doesn't exist or
+// would make the item its own ancestor
+var ErrBadParent = errors.New("bad parent")
+
+// ErrHasChildren is returned by DeleteRefuse deletes of items that
+// still have subtasks
+var ErrHasChildren = errors.New("item has subtasks")
+
+// DeleteMode says what happens to the subtasks of a deleted item
+type DeleteMode string
+
+const (
+	// DeleteRefuse won't delete an item that has subtasks
+	DeleteRefuse DeleteMode = "refuse"
+
+	// DeleteCascade deletes the subtasks too, all the way down
+	DeleteCascade DeleteMode = "cascade"
+
+	// DeleteReparent moves the subtasks up to the deleted item's parent
+	DeleteReparent DeleteMode = "reparent"
+)
+
+// ParseDeleteMode returns the DeleteMode with the given name
+func ParseDeleteMode(name string) (DeleteMode, error) {
+	switch m := DeleteMode(name); m {
+	case DeleteRefuse, DeleteCascade, DeleteReparent:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown delete mode %q, use refuse, cascade or reparent", name)
+}
+
+// DeleteItemMode deletes an item, dealing with its subtasks as mode
+// says.  DeleteItem is DeleteItemMode with DeleteRefuse.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//
+// Postconditions:
+//
+//	 (1) The ids of every item deleted will be returned, the
+//			item's own first
+//		(2) Items that were blocked by a deleted item no longer are
+//		(3) If there is an error, it will be returned and
+//			nothing will be deleted
+func (t *ToDo) DeleteItemMode(id int, mode DeleteMode) ([]int, error) {
+	return t.DeleteItemModeContext(context.Background(), id, mode)
+}
+
+// DeleteItemModeContext is DeleteItemMode, giving up with ctx's error if
+// ctx is done before the deletion is saved
+func (t *ToDo) DeleteItemModeContext(ctx context.Context, id int, mode DeleteMode) ([]int, error) {
+	if _, err := ParseDeleteMode(string(mode)); err != nil {
+		return nil, fmt.Errorf("DeleteItemMode: %w", err)
+	}
+
+	var deleted []int
+	err := t.updateContext(ctx, "delete", func(tx *Tx) error {
+		var err error
+		deleted, err = tx.DeleteItemMode(id, mode)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DeleteItemMode: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// ChangeTreeDoneStatus is ChangeItemDoneStatus for an item and all of
+// its subtasks, all the way down, in a single write.  It is
+// ChangeDoneStatus with children set.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//
+// Postconditions:
+//
+//	 (1) The status of the item and its subtasks will be updated
+//		(2) Repeating items that are marked done repeat, as with
+//			ChangeItemDoneStatus
+//		(3) If there is an error, it will be returned and
+//			nothing will be changed
+func (t *ToDo) ChangeTreeDoneStatus(id int, value bool) error {
+	err := t.changeDoneStatus(context.Background(), id, value, true, false)
+	if err != nil {
+		return fmt.Errorf("ChangeTreeDoneStatus: %w", err)
+	}
+
+	return nil
+}
+
+// deleteItem does the work of DeleteItemMode on t.toDoMap
+func (t *ToDo) deleteItem(id int, mode DeleteMode) ([]int, error) {
+	item, found := t.toDoMap[id]
+	if !found {
+		return nil, notFound(id)
+	}
+
+	kids := children(t.toDoMap, id)
+	deleted := []int{id}
+	switch {
+	case len(kids) == 0:
+	case mode == DeleteCascade:
+		deleted = append(deleted, descendants(t.toDoMap, id)...)
+	case mode == DeleteReparent:
+		for _, kid := range kids {
+			moved := t.toDoMap[kid]
+			moved.ParentId = item.ParentId
+			t.toDoMap[kid] = stampUpdate(t.toDoMap[kid], moved)
+		}
+	default:
+		return nil, fmt.Errorf("%w: item %d has %d, delete them too or move them up", ErrHasChildren, id, len(kids))
+	}
+
+	for _, gone := range deleted {
+		delete(t.toDoMap, gone)
+	}
+	t.unblock(deleted)
+	return deleted, nil
+}
+
+// checkParent returns an error wrapping ErrBadParent if item's parent,
+// if it has one, is not in toDoMap or is item itself or one of its
+// subtasks
+func checkParent(toDoMap DbMap, item ToDoItem) error {
+	if item.ParentId == 0 {
+		return nil
+	}
+
+	seen := map[int]bool{}
+	for ancestor := item.ParentId; ancestor != 0; ancestor = toDoMap[ancestor].ParentId {
+		if ancestor == item.Id {
+			return fmt.Errorf("%w: item %d can't be a subtask of itself", ErrBadParent, item.Id)
+		}
+		if _, found := toDoMap[ancestor]; !found {
+			return fmt.Errorf("%w: parent item %d does not exist", ErrBadParent, ancestor)
+		}
+		//A loop that doesn't take in item was there before, and isn't
+		//this write's to fix
+		if seen[ancestor] {
+			break
+		}
+		seen[ancestor] = true
+	}
+	return nil
+}
+
+// children returns the ids of the direct subtasks of id, in id order
+func children(toDoMap DbMap, id int) []int {
+	var kids []int
+	for kidId, kid := range toDoMap {
+		if kid.ParentId == id && kidId != id {
+			kids = append(kids, kidId)
+		}
+	}
+	sort.Ints(kids)
+	return kids
+}
+
+// descendants returns the ids of every subtask of id, all the way down,
+// parents before their children
+func descendants(toDoMap DbMap, id int) []int {
+	seen := map[int]bool{id: true}
+	var found []int
+	for queue := []int{id}; len(queue) > 0; queue = queue[1:] {
+		for _, kid := range children(toDoMap, queue[0]) {
+			if !seen[kid] {
+				seen[kid] = true
+				found = append(found, kid)
+				queue = append(queue, kid)
+			}
+		}
+	}
+	return found
+}
+
+// TreeOrder puts items in tree order: each item is followed by its
+// subtasks, which keep the order they had among themselves.  Items
+// whose parent isn't among items are put at the top level.  It also
+// returns the depth of each item in the tree, 0 for the top level.
+func TreeOrder(items []ToDoItem) ([]ToDoItem, []int) {
+	present := make(map[int]bool, len(items))
+	for _, item := range items {
+		present[item.Id] = true
+	}
+	kids := make(map[int][]int)
+	var roots []int
+	for i, item := range items {
+		if item.ParentId != 0 && item.ParentId != item.Id && present[item.ParentId] {
+			kids[item.ParentId] = append(kids[item.ParentId], i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+
+	ordered := make([]ToDoItem, 0, len(items))
+	depths := make([]int, 0, len(items))
+	placed := make([]bool, len(items))
+	var walk func(i, depth int)
+	walk = func(i, depth int) {
+		if placed[i] {
+			return
+		}
+		placed[i] = true
+		ordered = append(ordered, items[i])
+		depths = append(depths, depth)
+		for _, kid := range kids[items[i].Id] {
+			walk(kid, depth+1)
+		}
+	}
+	for _, i := range roots {
+		walk(i, 0)
+	}
+
+	//Items in a loop have no way up to the top level, so they would
+	//be lost.  Show them at the top instead.
+	for i := range items {
+		walk(i, 0)
+	}
+	return ordered, depths
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Tx is a transaction: a group of changes to the DB that are saved
+// together, in one write, or not at all.  Transactions are run by
+// Update, which hands fn a Tx.  Its methods work like the ToDo methods
+// of the same name, but on the items as the transaction has left them
+// so far, and nothing is saved until fn returns.
+//
+// Once one of a Tx's changes fails, the transaction is rolled back
+// whatever fn returns, and every change after that fails too.  A Tx
+// must not be used after fn returns.
+type Tx struct {
+	t      *ToDo
+	err    error
+	closed bool
+}
+
+// errTxClosed is returned by a Tx used after its transaction is over
+var errTxClosed = errors.New("transaction is over")
+
+// Update runs fn in a transaction, loading the DB once and saving it
+// once, after fn has made all its changes.  The changes are journaled
+// as a single entry, so one undo takes them all back.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) If fn returns nil and all its changes succeeded, they
+//			will all be saved in a single write
+//		(2) Otherwise nothing will be saved and the error will be
+//			returned
+//		(3) fn may be run more than once, if the save conflicts
+//			with another writer (see ErrConflict), so it should
+//			have no effects outside of tx
+func (t *ToDo) Update(fn func(tx *Tx) error) error {
+	return t.UpdateContext(context.Background(), fn)
+}
+
+// UpdateContext is Update, giving up with ctx's error, and saving
+// nothing, if ctx is done before the transaction is saved
+func (t *ToDo) UpdateContext(ctx context.Context, fn func(tx *Tx) error) error {
+	err := t.updateContext(ctx, "batch", fn)
+	if err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+
+	return nil
+}
+
+// update is Update with the op the journal records the transaction
+// under.  The ToDo write methods are single change transactions.
+func (t *ToDo) update(op string, fn func(tx *Tx) error) error {
+	return t.updateContext(context.Background(), op, fn)
+}
+
+// updateContext is update with a context, see writeDB
+func (t *ToDo) updateContext(ctx context.Context, op string, fn func(tx *Tx) error) error {
+	return t.writeDB(ctx, &JournalEntry{Op: op}, func() error {
+		tx := &Tx{t: t}
+		defer func() {
+			tx.closed = true
+		}()
+
+		err := fn(tx)
+		if err == nil {
+			err = tx.err
+		}
+		return err
+	})
+}
+
+// GetItem returns the item with the given id
+func (tx *Tx) GetItem(id int) (ToDoItem, error) {
+	if tx.closed {
+		return ToDoItem{}, errTxClosed
+	}
+
+	item, found := tx.t.toDoMap[id]
+	if !found {
+		return ToDoItem{}, notFound(id)
+	}
+	return item, nil
+}
+
+// QueryItems returns the items selected by q, in the order it asks for
+func (tx *Tx) QueryItems(q Query) ([]ToDoItem, error) {
+	if tx.closed {
+		return nil, errTxClosed
+	}
+
+	return q.Apply(tx.t.items())
+}
+
+// AddItem adds item, giving it the next free id if its id is 0, and
+// returns the id it was stored under
+func (tx *Tx) AddItem(item ToDoItem) (int, error) {
+	var id int
+	err := tx.change(func(t *ToDo) error {
+		if item.Id < 0 {
+			return &ItemError{Id: item.Id, Err: ErrBadId}
+		}
+		if item.Id == 0 {
+			next, err := nextId(t.lastId)
+			if err != nil {
+				return err
+			}
+			item.Id = next
+		}
+
+		_, found := t.toDoMap[item.Id]
+		if found {
+			return &ItemError{Id: item.Id, Err: ErrExists}
+		}
+		if err := checkParent(t.toDoMap, item); err != nil {
+			return err
+		}
+		if err := checkBlockers(t.toDoMap, item); err != nil {
+			return err
+		}
+
+		t.toDoMap[item.Id] = stampNew(item)
+		t.lastId = max(t.lastId, item.Id)
+		id = item.Id
+		return nil
+	})
+	return id, err
+}
+
+// UpdateItem replaces the item with item's id with item
+func (tx *Tx) UpdateItem(item ToDoItem) error {
+	return tx.change(func(t *ToDo) error {
+		if item.Id < 0 {
+			return &ItemError{Id: item.Id, Err: ErrBadId}
+		}
+		oldItem, found := t.toDoMap[item.Id]
+		if !found {
+			return notFound(item.Id)
+		}
+		if err := checkParent(t.toDoMap, item); err != nil {
+			return err
+		}
+		if err := checkBlockers(t.toDoMap, item); err != nil {
+			return err
+		}
+
+		t.toDoMap[item.Id] = stampUpdate(oldItem, item)
+		return nil
+	})
+}
+
+// DeleteItem deletes an item that has no subtasks
+func (tx *Tx) DeleteItem(id int) error {
+	_, err := tx.DeleteItemMode(id, DeleteRefuse)
+	return err
+}
+
+// DeleteItemMode deletes an item, dealing with its subtasks as mode
+// says, and returns the ids of every item deleted
+func (tx *Tx) DeleteItemMode(id int, mode DeleteMode) ([]int, error) {
+	var deleted []int
+	err := tx.change(func(t *ToDo) error {
+		if _, err := ParseDeleteMode(string(mode)); err != nil {
+			return err
+		}
+
+		var err error
+		deleted, err = t.deleteItem(id, mode)
+		return err
+	})
+	return deleted, err
+}
+
+// ChangeItemDoneStatus marks an item done (value true) or not done
+func (tx *Tx) ChangeItemDoneStatus(id int, value bool) error {
+	return tx.ChangeDoneStatus(id, value, false, false)
+}
+
+// ChangeDoneStatus marks an item done or not done, along with its
+// subtasks if children is set, refusing to mark items blocked by open
+// items done unless force is set
+func (tx *Tx) ChangeDoneStatus(id int, value, children, force bool) error {
+	return tx.change(func(t *ToDo) error {
+		return t.markDone([]int{id}, value, children, force)
+	})
+}
+
+// MoveItem puts an item in another list, along with its subtasks if
+// children is set, and returns the ids of the items moved
+func (tx *Tx) MoveItem(id int, list string, children bool) ([]int, error) {
+	var moved []int
+	err := tx.change(func(t *ToDo) error {
+		var err error
+		moved, err = t.moveItem(id, list, children)
+		return err
+	})
+	return moved, err
+}
+
+// StartTimer starts the timer on an item, stopping the one that was
+// running, and returns the id of the item stopped, 0 if none was
+func (tx *Tx) StartTimer(id int) (int, error) {
+	var stopped int
+	err := tx.change(func(t *ToDo) error {
+		var err error
+		stopped, err = t.startTimer(id)
+		return err
+	})
+	return stopped, err
+}
+
+// StopTimer stops the timer on an item, or whichever is running if id
+// is 0, and returns the id of the item stopped
+func (tx *Tx) StopTimer(id int) (int, error) {
+	var stopped int
+	err := tx.change(func(t *ToDo) error {
+		if id != 0 {
+			if _, found := t.toDoMap[id]; !found {
+				return notFound(id)
+			}
+			if !t.stopTimer(id) {
+				return fmt.Errorf("%w on item %d", ErrNoTimer, id)
+			}
+			stopped = id
+			return nil
+		}
+
+		running := runningTimers(t.toDoMap)
+		if len(running) == 0 {
+			return ErrNoTimer
+		}
+		for _, id := range running {
+			t.stopTimer(id)
+		}
+		stopped = running[0]
+		return nil
+	})
+	return stopped, err
+}
+
+// change runs one of the transaction's changes, failing the whole
+// transaction if it fails
+func (tx *Tx) change(fn func(t *ToDo) error) error {
+	switch {
+	case tx.closed:
+		return errTxClosed
+	case tx.err != nil:
+		return fmt.Errorf("an earlier change failed: %w", tx.err)
+	}
+
+	err := fn(tx.t)
+	if err != nil {
+		tx.err = err
+	}
+	return err
+}
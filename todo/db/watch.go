@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// EventKind says what happened to an item between two versions of the
+// database
+type EventKind string
+
+const (
+	EventAdded     EventKind = "added"
+	EventUpdated   EventKind = "updated"
+	EventCompleted EventKind = "completed"
+	EventDeleted   EventKind = "deleted"
+)
+
+// Event is what happened to one item.  Item is the item as it is now,
+// or as it was for one that was deleted, and Fields the JSON names of
+// the fields that changed, for an item that was updated or completed.
+// An item marked done is completed, whatever else changed with it.
+type Event struct {
+	Kind   EventKind `json:"event"`
+	Id     int       `json:"id"`
+	Item   ToDoItem  `json:"item"`
+	Fields []string  `json:"fields,omitempty"`
+}
+
+// Diff returns the events that turn the items in before into the items
+// in after, by id
+func Diff(before, after DbMap) []Event {
+	changes := diffItems(before, after)
+	events := make([]Event, 0, len(changes))
+	for _, change := range changes {
+		events = append(events, changeEvent(change))
+	}
+	return events
+}
+
+// changeEvent returns the event for a change
+func changeEvent(change Change) Event {
+	switch {
+	case change.Before == nil:
+		return Event{Kind: EventAdded, Id: change.Id, Item: *change.After}
+	case change.After == nil:
+		return Event{Kind: EventDeleted, Id: change.Id, Item: *change.Before}
+	case !change.Before.IsDone && change.After.IsDone:
+		return Event{Kind: EventCompleted, Id: change.Id, Item: *change.After, Fields: change.Fields()}
+	default:
+		return Event{Kind: EventUpdated, Id: change.Id, Item: *change.After, Fields: change.Fields()}
+	}
+}
+
+// watchDelay is how long Watch waits for the file to settle after it
+// changes, so a burst of writes is read once
+const watchDelay = 50 * time.Millisecond
+
+// WatchFunc is called by Watch with the events for each change to the
+// database.  If the database can't be read after a change, it is called
+// with the error instead.  Returning nil carries on watching, returning
+// an error stops Watch, which returns it.
+type WatchFunc func(events []Event, err error) error
+
+// Watch watches the database file, and calls fn with the events for
+// every change made to it, by this process or any other, until ctx is
+// done.
+// Preconditions:   (1) The DB must be kept in a file
+//
+// Postconditions:
+//
+//	 (1) fn will be called once for each change, though changes
+//			made close together may be seen as one
+//		(2) Watch returns nil once ctx is done, or the error fn
+//			returns
+//		(3) The database file will not be modified
+func (t *ToDo) Watch(ctx context.Context, fn WatchFunc) error {
+	fileStore, ok := t.store.(FileStore)
+	if !ok {
+		return errors.New("Watch: only file databases can be watched")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Watch: %w", err)
+	}
+	defer watcher.Close()
+
+	//Saves replace the file rather than write to it, so it is the
+	//directory that is watched, for the file to turn up in it again
+	fileName := filepath.Clean(fileStore.FileName())
+	err = watcher.Add(filepath.Dir(fileName))
+	if err != nil {
+		return fmt.Errorf("Watch: %w", err)
+	}
+
+	items, err := t.copyItems()
+	if err != nil {
+		return fmt.Errorf("Watch: %w", err)
+	}
+
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) == fileName && !event.Has(fsnotify.Chmod) {
+				settled = time.After(watchDelay)
+			}
+
+		case err := <-watcher.Errors:
+			return fmt.Errorf("Watch: %w", err)
+
+		case <-settled:
+			settled = nil
+			current, err := t.copyItems()
+			if err != nil {
+				if err := fn(nil, err); err != nil {
+					return err
+				}
+				continue
+			}
+			events := Diff(items, current)
+			items = current
+			if len(events) == 0 {
+				continue
+			}
+			if err := fn(events, nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyItems returns a copy of the items in the database as it is now
+func (t *ToDo) copyItems() (DbMap, error) {
+	var items DbMap
+	err := t.viewDB(func() error {
+		items = maps.Clone(t.toDoMap)
+		return nil
+	})
+	return items, err
+}
@@ -5,138 +5,148 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 
 	"drexel.edu/todo/db"
 )
 
-// Global variables to hold the command line flags to drive the todo CLI
-// application
+// Global variables to hold the command line flags that apply to every
+// subcommand of the todo CLI application
 var (
-	dbFileNameFlag string
-	restoreDbFlag  bool
-	listFlag       bool
-	itemStatusFlag bool
-	queryFlag      int
-	addFlag        string
-	updateFlag     string
-	deleteFlag     int
+	dbFileNameFlag     string
+	outputFlag         string
+	snapshotsFlag      int
+	snapshotMaxAgeFlag string
+	keyFileFlag        string
+	hooksFlag          string
 )
 
-type AppOptType int
+// errUsage is returned by a subcommand when it was called with bad
+// flags or arguments.  main prints the subcommand's help and exits
+// with status 2 for it, like the flag package does.
+var errUsage = errors.New("invalid usage")
 
-// To make the code a little more clean we will use the following
-// constants as basically enumerations for different options.  This
-// allows us to use a switch statement in main to process the command line
-// flags effectively
+// errKeyFile is returned when the file a passphrase is read from can't
+// be, which exits with the status for a missing passphrase
+var errKeyFile = errors.New("can't read the passphrase")
+
+// The exit statuses for the errors a subcommand returns, so scripts can
+// tell what went wrong without reading the message, see exitStatus
 const (
-	LIST_DB_ITEM AppOptType = iota
-	RESTORE_DB_ITEM
-	QUERY_DB_ITEM
-	ADD_DB_ITEM
-	UPDATE_DB_ITEM
-	DELETE_DB_ITEM
-	CHANGE_ITEM_STATUS
-	NOT_IMPLEMENTED
-	INVALID_APP_OPT
+	exitError      = 1 // anything not listed below
+	exitUsage      = 2 // bad flags or arguments, or a command remote mode doesn't support
+	exitNotFound   = 3 // an item that isn't in the database
+	exitConflict   = 4 // an id that is taken, blockers, subtasks or a timer in the way, or a lost race
+	exitCorrupt    = 5 // the database file is corrupt
+	exitPassphrase = 6 // a missing or wrong passphrase
+	exitVetoed     = 7 // a hook stopped the change
+	exitStore      = 8 // the database couldn't be locked, read or written
+	exitInvalid    = 9 // a negative id, or a parent or blocker that doesn't exist or makes a loop
 )
 
-// processCmdLineFlags parses the command line flags for our CLI
+// exitStatus returns the exit status for err.  An error that joins
+// several, one for each id given, gets the lowest status any of them
+// has, other than 1.  A corrupt file or a bad passphrase is found
+// loading the store, so it is checked for before other store errors.
+func exitStatus(err error) int {
+	var storeErr *db.StoreError
+	var pathErr *os.PathError
+	switch {
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, db.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, db.ErrExists), errors.Is(err, db.ErrBlocked), errors.Is(err, db.ErrHasChildren),
+		errors.Is(err, db.ErrConflict), errors.Is(err, db.ErrNoTimer), errors.Is(err, db.ErrTimerRunning),
+		errors.Is(err, db.ErrItemDone):
+		return exitConflict
+	case errors.Is(err, db.ErrCorrupt):
+		return exitCorrupt
+	case errors.Is(err, db.ErrNoPassphrase), errors.Is(err, db.ErrBadPassphrase), errors.Is(err, errKeyFile):
+		return exitPassphrase
+	case errors.Is(err, db.ErrVetoed):
+		return exitVetoed
+	case errors.As(err, &storeErr), errors.As(err, &pathErr):
+		return exitStore
+	case errors.Is(err, db.ErrBadId), errors.Is(err, db.ErrBadParent), errors.Is(err, db.ErrBadBlocker):
+		return exitInvalid
+	}
+	return exitError
+}
+
+// processCmdLineFlags parses the global command line flags for our CLI
+// and works out which subcommand to run.
 //
-// DONE: This function uses the flag package to parse the command line
-//		 flags.  The flag package is not very flexible and can lead to
-//		 some confusing code.
-
-//			 REQUIRED:     Study the code below, and make sure you understand
-//						   how it works.  Go online and readup on how the
-//						   flag package works.  Then, write a nice comment
-//				  		   block to document this function that highights that
-//						   you understand how it works.
+// The command line looks like
 //
-//			EXTRA CREDIT: The best CLI and command line processor for
-//						   go is called Cobra.  Refactor this function to
-//						   use it.  See github.com/spf13/cobra for information
-//						   on how to use it.
+//	todo [global flags] <command> [command flags] [arguments]
 //
-//	 YOUR ANSWER: The StringVar, BoolVar, and IntVar functions in the flag package take the name of a flag,
-//                    a help string to be printed in the usage text for the flag, and a reference to a variable
-//                    where the flag's value should be written.  It is reminiscent of getoptslong or some of the
-//                    nicer command line parsers from other languages.  flag.Parse() actually parses the
-//                    command line provided, looking for the flags that were previously defined and stores the
-//                    result in some kind of global variable.  It should be fine because we really only ought to
-//                    parse flags once at the beginning of execution, so anybody who tries to use it in a thread
-//                    shouldn't be too surprised to run into issues.  After Parse()ing, we can use the Visit()
-//                    function to loop over all the flags that have been set, running a lambda on each.  In this
-//                    case, the lambda determines which action to take and decides which action to perform.
-func processCmdLineFlags() (AppOptType, error) {
-	flag.StringVar(&dbFileNameFlag, "db", "./data/todo.json", "Name of the database file")
-	flag.BoolVar(&restoreDbFlag, "restore", false, "Restore the database from the backup file")
-	flag.BoolVar(&listFlag, "l", false, "List all the items in the database")
-	flag.IntVar(&queryFlag, "q", 0, "Query an item in the database")
-	flag.StringVar(&addFlag, "a", "", "Add an item to the database")
-	flag.StringVar(&updateFlag, "u", "", "Update an item in the database")
-	flag.IntVar(&deleteFlag, "d", 0, "Delete an item from the database")
-	flag.BoolVar(&itemStatusFlag, "s", false, "Change item 'done' status to true or false")
+// The StringVar, BoolVar, and IntVar functions in the flag package take
+// the name of a flag, a help string to be printed in the usage text for
+// the flag, and a reference to a variable where the flag's value should
+// be written.  flag.Parse() parses the command line looking for those
+// flags and stops at the first argument that isn't one, which here is
+// the name of the subcommand.  Everything from there on is left in
+// flag.Args() for the subcommand, which parses its own flags with its
+// own flag.FlagSet (see commands.go).
+func processCmdLineFlags() (*command, []string, error) {
+	flag.StringVar(&dbFileNameFlag, "db", "./data/todo.json",
+		"Database to use: a JSON file name, one of json:<file>, bolt:<file> or mem:, or http://host:port for a todo server")
+	flag.StringVar(&outputFlag, "output", string(db.FormatTable),
+		"How list and show print items: table, csv, jsonl, json, markdown or todotxt")
+	flag.IntVar(&snapshotsFlag, "snapshots", db.DefaultSnapshotPolicy.Keep,
+		"How many automatic snapshots, taken before each change, to keep; 0 to take none")
+	flag.StringVar(&snapshotMaxAgeFlag, "snapshot-max-age", "",
+		"Also delete automatic snapshots older than this, such as 30d or 12h")
+	flag.StringVar(&hooksFlag, "hooks", "",
+		"Directory of hooks to run when items change, such as pre-add and on-complete; none are run without it")
+	flag.StringVar(&keyFileFlag, "key-file", "",
+		"File holding the passphrase of an encrypted database, instead of $"+passphraseEnv)
+	flag.Usage = usage
 
 	flag.Parse()
 
-	var appOpt AppOptType = INVALID_APP_OPT
-
-	//show help if no flags are set
-	if len(os.Args) == 1 {
+	//show help if no command was given
+	if flag.NArg() == 0 {
 		flag.Usage()
-		return appOpt, errors.New("no flags were set")
+		return nil, nil, errors.New("no command was given")
 	}
 
-	// Loop over the flags and check which ones are set, set appOpt
-	// accordingly
-	flag.Visit(func(f *flag.Flag) {
-		switch f.Name {
-		case "l":
-			appOpt = LIST_DB_ITEM
-		case "restore":
-			appOpt = RESTORE_DB_ITEM
-		case "q":
-			appOpt = QUERY_DB_ITEM
-		case "a":
-			appOpt = ADD_DB_ITEM
-		case "u":
-			appOpt = UPDATE_DB_ITEM
-		case "d":
-			appOpt = DELETE_DB_ITEM
-
-		//DONE: EXTRA CREDIT - Implment the -s flag that changes the
-		//done status of an item in the database.  For example -s=true
-		//will set the done status for a particular item to true, and
-		//-s=false will set the done states for a particular item to
-		//false.
-		//
-		//HINT FOR EXTRA CREDIT
-		//Note the -s option also requires an id for the item to that
-		//you want to change.  I recommend you use the -q option to
-		//specify the item id.  Therefore, the -s option is only valid
-		//if the -q option is also set
-		case "s":
-			//For extra credit you will need to change some things here
-			//and also in main under the CHANGE_ITEM_STATUS case
-			appOpt = CHANGE_ITEM_STATUS
-		default:
-			appOpt = INVALID_APP_OPT
+	name := flag.Arg(0)
+	if name == "help" {
+		if flag.NArg() > 1 {
+			if cmd := findCommand(flag.Arg(1)); cmd != nil {
+				fs := cmd.flagSet()
+				cmd.setup(fs)
+				fs.Usage()
+				os.Exit(0)
+			}
 		}
-	})
-
-	if appOpt == INVALID_APP_OPT || appOpt == NOT_IMPLEMENTED {
-		fmt.Println("Invalid option set or the desired option is not currently implemented")
 		flag.Usage()
-		return appOpt, errors.New("no flags or unimplemented were set")
+		os.Exit(0)
 	}
 
-	if appOpt == CHANGE_ITEM_STATUS && queryFlag == 0 {
-		fmt.Println("-q flag must be provided when -s is set")
+	cmd := findCommand(name)
+	if cmd == nil {
 		flag.Usage()
-		return appOpt, errors.New("-s provided without -q")
+		return nil, nil, fmt.Errorf("unknown command %q", name)
 	}
 
-	return appOpt, nil
+	return cmd, flag.Args()[1:], nil
+}
+
+// usage prints the top level help: the global flags and a one line
+// summary of every subcommand
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: todo [global flags] <command> [command flags] [arguments]\n\n")
+	fmt.Fprintf(out, "Global flags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nRun 'todo help <command>' for the flags and arguments of a command.\n")
 }
 
 // main is the entry point for our todo CLI application.  It processes
@@ -145,107 +155,74 @@ func processCmdLineFlags() (AppOptType, error) {
 func main() {
 
 	//Process the command line flags
-	opts, err := processCmdLineFlags()
+	cmd, args, err := processCmdLineFlags()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	//Create a new db object
-	todo, err := db.New(dbFileNameFlag)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if _, err := db.ParseFormat(outputFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-
-	//Switch over the command line flags and call the appropriate
-	//function in the db package
-	switch opts {
-	case RESTORE_DB_ITEM:
-		fmt.Println("Running RESTORE_DB_ITEM...")
-		if err := todo.RestoreDB(); err != nil {
-			fmt.Println("Error: ", err)
-			break
-		}
-		fmt.Println("Database restored from backup file")
-	case LIST_DB_ITEM:
-		fmt.Println("Running QUERY_DB_ITEM...")
-		todoList, err := todo.GetAllItems()
+	snapshotPolicy := db.SnapshotPolicy{Keep: snapshotsFlag}
+	if snapshotMaxAgeFlag != "" {
+		snapshotPolicy.MaxAge, err = parseAge(snapshotMaxAgeFlag)
 		if err != nil {
-			fmt.Println("Error: ", err)
-			break
-		}
-		for _, item := range todoList {
-			todo.PrintItem(item)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		fmt.Println("THERE ARE", len(todoList), "ITEMS IN THE DB")
-		fmt.Println("Ok")
+	}
 
-	case QUERY_DB_ITEM:
-		fmt.Println("Running QUERY_DB_ITEM...")
-		item, err := todo.GetItem(queryFlag)
-		if err != nil {
-			fmt.Println("Error: ", err)
-			break
-		}
-		todo.PrintItem(item)
-		fmt.Println("Ok")
-	case ADD_DB_ITEM:
-		fmt.Println("Running ADD_DB_ITEM...")
-		item, err := todo.JsonToItem(addFlag)
-		if err != nil {
-			fmt.Println("Add option requires a valid JSON todo item string")
-			fmt.Println("Error: ", err)
-			break
-		}
-		if err := todo.AddItem(item); err != nil {
-			fmt.Println("Error: ", err)
-			break
-		}
-		fmt.Println("Ok")
-	case UPDATE_DB_ITEM:
-		fmt.Println("Running UPDATE_DB_ITEM...")
-		item, err := todo.JsonToItem(updateFlag)
-		if err != nil {
-			fmt.Println("Update option requires a valid JSON todo item string")
-			fmt.Println("Error: ", err)
-			break
-		}
-		if err := todo.UpdateItem(item); err != nil {
-			fmt.Println("Error: ", err)
-			break
+	//Parse the subcommand's own flags before we touch the database
+	fs := cmd.flagSet()
+	run := cmd.setup(fs)
+	args, err = parseArgs(fs, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	//Create a new db object, the -db flag picks the storage backend
+	todo, err := db.Open(dbFileNameFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitStatus(err))
+	}
+	defer todo.Close()
+	if todo.Remote() && slices.Contains(fileCommands, cmd.name) {
+		fmt.Fprintf(os.Stderr, "Error:  todo %s is not supported in remote mode, run it on the server\n", cmd.name)
+		todo.Close()
+		os.Exit(exitUsage)
+	}
+	todo.SetSnapshotPolicy(snapshotPolicy)
+	passphrase, err := readPassphrase(keyFileFlag, passphraseEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		todo.Close()
+		os.Exit(exitStatus(err))
+	}
+	todo.SetPassphrase(passphrase)
+	todo.SetHooksDir(hooksFlag)
+
+	err = run(todo, args)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(fs.Output(), "Error: ", err)
+		fs.Usage()
+		todo.Close()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		if errors.Is(err, db.ErrCorrupt) && cmd.name != "fsck" {
+			fmt.Fprintln(os.Stderr, "Run todo fsck to see what is wrong with it, and todo fsck -repair to fix it")
 		}
-		fmt.Println("Ok")
-	case DELETE_DB_ITEM:
-		fmt.Println("Running DELETE_DB_ITEM...")
-		err := todo.DeleteItem(deleteFlag)
-		if err != nil {
-			fmt.Println("Error: ", err)
-			break
+		if errors.Is(err, db.ErrNoPassphrase) {
+			fmt.Fprintf(os.Stderr, "Set %s to its passphrase, or give a file holding it with -key-file\n", passphraseEnv)
 		}
-		fmt.Println("Ok")
-	case CHANGE_ITEM_STATUS:
-		//For the CHANGE_ITEM_STATUS extra credit you will also
-		//need to add some code here
-		fmt.Println("Running CHANGE_ITEM_STATUS...")
-
-		item,err := todo.GetItem(queryFlag)
-		if err != nil {
-			fmt.Println("Error: ", err)
-			break
-		}	
-
-		item.IsDone = itemStatusFlag
-
-		err = todo.UpdateItem(item)
-		if err != nil {
-			fmt.Println("Error: ", err)
-			break
-		}	
-	
-
-		fmt.Println("Ok")
-	default:
-		fmt.Println("INVALID_APP_OPT")
+		todo.Close()
+		os.Exit(exitStatus(err))
 	}
 }
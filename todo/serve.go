@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+)
+
+// setupServe is the serve subcommand, which runs the REST API in the
+// api package until it is interrupted
+func setupServe(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	//Note some networking lingo, 0.0.0.0 instructs the network stack
+	//to listen on all interfaces, so other machines can reach the
+	//server, not just this one
+	hostFlag := fs.String("host", "0.0.0.0", "Interface to listen on")
+	portFlag := fs.Uint("port", 1080, "Port to listen on")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: serve takes no arguments", errUsage)
+		}
+
+		app := fiber.New(fiber.Config{
+			ErrorHandler:          api.ErrorHandler,
+			DisableStartupMessage: true,
+		})
+		app.Use(cors.New())
+		app.Use(recover.New())
+		app.Use(logger.New())
+
+		api.New(todo).AddRoutes(app)
+
+		//Stop taking requests on Ctrl-C or a kill, and let the ones in
+		//flight finish before main closes the database
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			app.Shutdown()
+		}()
+
+		serverPath := fmt.Sprintf("%s:%d", *hostFlag, *portFlag)
+		log.Println("Starting server on ", serverPath)
+		return app.Listen(serverPath)
+	}
+}
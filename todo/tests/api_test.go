@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestApp returns the API app over a fresh file DB, along with the
+// file name so tests can share it with a second ToDo, as the CLI would
+func newTestApp(t *testing.T) (*fiber.App, string) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	t.Cleanup(func() { todo.Close() })
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	api.New(todo).AddRoutes(app)
+	return app, dbFile
+}
+
+// call makes one request against app and decodes the JSON response
+// into result, unless it is nil
+func call(t *testing.T, app *fiber.App, method, path, body string, result any) int {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := app.Test(req, -1)
+	require.NoError(t, err, "%s %s", method, path)
+	defer rsp.Body.Close()
+
+	if result != nil {
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(result), "Decoding %s %s", method, path)
+	}
+	return rsp.StatusCode
+}
+
+func TestApiCrud(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	var items []db.ToDoItem
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo", "", &items))
+	assert.NotNil(t, items, "An empty list is [] not null")
+	assert.Empty(t, items)
+
+	var item db.ToDoItem
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"first","priority":2}`, &item))
+	assert.Equal(t, 1, item.Id, "Ids are assigned when left out")
+	assert.Equal(t, "first", item.Title)
+	assert.NotNil(t, item.CreatedAt, "The item is returned as stored")
+
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo/1", "", &item))
+	assert.Equal(t, "first", item.Title)
+
+	var renamed db.ToDoItem
+	assert.Equal(t, http.StatusOK, call(t, app, "PUT", "/todo/1", `{"title":"renamed"}`, &renamed))
+	assert.Equal(t, "renamed", renamed.Title)
+	assert.Equal(t, 0, renamed.Priority, "PUT replaces the whole item")
+
+	assert.Equal(t, http.StatusOK, call(t, app, "PUT", "/todo/1/done", "", &item))
+	assert.True(t, item.IsDone)
+	assert.NotNil(t, item.CompletedAt)
+	assert.Equal(t, http.StatusOK, call(t, app, "DELETE", "/todo/1/done", "", &item))
+	assert.False(t, item.IsDone)
+
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"id":7,"title":"second","done":true}`, &item))
+	assert.Equal(t, 7, item.Id)
+
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo?done=true", "", &items))
+	require.Len(t, items, 1)
+	assert.Equal(t, 7, items[0].Id)
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo?sort=-id&limit=1", "", &items))
+	require.Len(t, items, 1)
+	assert.Equal(t, 7, items[0].Id)
+
+	assert.Equal(t, http.StatusNoContent, call(t, app, "DELETE", "/todo/1", "", nil))
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo", "", &items))
+	assert.Len(t, items, 1)
+}
+
+func TestApiErrors(t *testing.T) {
+	app, _ := newTestApp(t)
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"id":1,"title":"exists"}`, nil))
+
+	cases := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/todo/2", "", http.StatusNotFound},
+		{"PUT", "/todo/2", `{"title":"x"}`, http.StatusNotFound},
+		{"DELETE", "/todo/2", "", http.StatusNotFound},
+		{"PUT", "/todo/2/done", "", http.StatusNotFound},
+		{"POST", "/todo", `{"id":1,"title":"duplicate"}`, http.StatusConflict},
+		{"POST", "/todo", `not json`, http.StatusBadRequest},
+		{"POST", "/todo", `{"done":true}`, http.StatusBadRequest},
+		{"POST", "/todo", `{"title":"x","repeat":"sometimes"}`, http.StatusBadRequest},
+		{"PUT", "/todo/1", `{"id":3,"title":"wrong id"}`, http.StatusBadRequest},
+		{"GET", "/todo?sort=nosuchfield", "", http.StatusBadRequest},
+		{"GET", "/todo?done=maybe", "", http.StatusBadRequest},
+		{"GET", "/todo/abc", "", http.StatusNotFound},
+		{"GET", "/nothing/here", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		var result api.ErrorResult
+		status := call(t, app, c.method, c.path, c.body, &result)
+		assert.Equal(t, c.status, status, "%s %s", c.method, c.path)
+		assert.Equal(t, c.status, result.Status, "The error body repeats the status")
+		assert.NotEmpty(t, result.Error, "The error body says what went wrong")
+	}
+
+	var health api.HealthCheckResult
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo/health", "", &health))
+	assert.Equal(t, "ok", health.Status)
+	assert.Equal(t, uint64(len(cases)-2+1), health.Transactions, "Unrouted requests aren't counted")
+	assert.Equal(t, uint64(len(cases)-2), health.Errors)
+}
+
+// The server and the CLI each have their own ToDo on the same file, so
+// only the db package's locking keeps their writes apart
+func TestApiSharesFileWithCli(t *testing.T) {
+	app, dbFile := newTestApp(t)
+	cli, err := db.New(dbFile)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	const writes = 20
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			call(t, app, "POST", "/todo", `{"title":"from the server"}`, nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			_, err := cli.AddItem(db.ToDoItem{Title: "from the cli"})
+			assert.NoError(t, err)
+		}
+	}()
+	wg.Wait()
+
+	var items []db.ToDoItem
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo", "", &items))
+	assert.Len(t, items, 2*writes, "No write should be lost")
+
+	cliItems, err := cli.GetAllItems()
+	require.NoError(t, err)
+	assert.Len(t, cliItems, 2*writes)
+}
+
+func TestApiSubtasks(t *testing.T) {
+	app, _ := newTestApp(t)
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"parent"}`, nil))
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"child","parent":1}`, nil))
+
+	var result api.ErrorResult
+	assert.Equal(t, http.StatusBadRequest, call(t, app, "POST", "/todo", `{"title":"orphan","parent":9}`, &result))
+	assert.Equal(t, http.StatusBadRequest, call(t, app, "PUT", "/todo/1", `{"title":"loop","parent":2}`, &result))
+	assert.Equal(t, http.StatusConflict, call(t, app, "DELETE", "/todo/1", "", &result), "Subtasks aren't orphaned")
+	assert.Equal(t, http.StatusBadRequest, call(t, app, "DELETE", "/todo/1?mode=sideways", "", &result))
+
+	var child db.ToDoItem
+	assert.Equal(t, http.StatusOK, call(t, app, "PUT", "/todo/1/done?children=true", "", nil))
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo/2", "", &child))
+	assert.True(t, child.IsDone)
+
+	assert.Equal(t, http.StatusNoContent, call(t, app, "DELETE", "/todo/1?mode=cascade", "", nil))
+	var items []db.ToDoItem
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo", "", &items))
+	assert.Empty(t, items)
+}
+
+func TestApiBlockers(t *testing.T) {
+	app, _ := newTestApp(t)
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"first"}`, nil))
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"second","blocked_by":[1]}`, nil))
+
+	var result api.ErrorResult
+	assert.Equal(t, http.StatusBadRequest, call(t, app, "PUT", "/todo/1", `{"title":"loop","blocked_by":[2]}`, &result))
+
+	var items []db.ToDoItem
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo/next", "", &items))
+	require.Len(t, items, 1)
+	assert.Equal(t, 1, items[0].Id)
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo/next?all=true", "", &items))
+	assert.Len(t, items, 2)
+
+	assert.Equal(t, http.StatusConflict, call(t, app, "PUT", "/todo/2/done", "", &result))
+	assert.Contains(t, result.Error, "waiting on 1")
+	assert.Equal(t, http.StatusOK, call(t, app, "PUT", "/todo/2/done?force=true", "", nil))
+}
+
+func TestApiLists(t *testing.T) {
+	app, _ := newTestApp(t)
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"call mom"}`, nil))
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"write report","list":"work"}`, nil))
+	assert.Equal(t, http.StatusCreated, call(t, app, "POST", "/todo", `{"title":"fix sink","list":"home"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, call(t, app, "POST", "/todo", `{"title":"bad","list":"a b"}`, nil))
+
+	var items []db.ToDoItem
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo?list=work,default", "", &items))
+	assert.Equal(t, []int{1, 2}, ids(items))
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo/next?list=home", "", &items))
+	assert.Equal(t, []int{3}, ids(items))
+
+	assert.Equal(t, http.StatusOK, call(t, app, "PUT", "/todo/1", `{"title":"call mom","list":"home"}`, nil))
+	var lists []api.ListResult
+	assert.Equal(t, http.StatusOK, call(t, app, "GET", "/todo/lists", "", &lists))
+	assert.Equal(t, []api.ListResult{{Name: "home", Open: 2, Total: 2}, {Name: "work", Open: 1, Total: 1}}, lists)
+}
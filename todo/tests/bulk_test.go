@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBulkDb returns a DB holding
+//
+//	1 paint fence, done 60 days ago
+//	2 paint shed
+//	3 buy brushes, done 60 days ago
+//	4   clean brushes, open subtask of 3
+//	5 call mom, done today
+func newBulkDb(t *testing.T) *db.ToDo {
+	longAgo := time.Now().UTC().Truncate(time.Second).AddDate(0, 0, -60)
+	return newDbWith(t,
+		db.ToDoItem{Title: "paint fence", IsDone: true, CompletedAt: &longAgo},
+		db.ToDoItem{Title: "paint shed"},
+		db.ToDoItem{Title: "buy brushes", IsDone: true, CompletedAt: &longAgo},
+		db.ToDoItem{Title: "clean brushes", ParentId: 3},
+		db.ToDoItem{Title: "call mom", IsDone: true},
+	)
+}
+
+func journalLen(t *testing.T, todo *db.ToDo) int {
+	entries, err := todo.Journal()
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestMarkMatching(t *testing.T) {
+	todo := newBulkDb(t)
+	writes := journalLen(t, todo)
+
+	changed, err := todo.MarkMatching(db.Query{TitleContains: "paint"}, true, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, ids(changed), "Items that are already done are left alone")
+	assert.True(t, changed[0].IsDone, "Changed items are returned as they would be")
+	item, err := todo.GetItem(2)
+	require.NoError(t, err)
+	assert.False(t, item.IsDone, "A preview changes nothing")
+	assert.Equal(t, writes, journalLen(t, todo))
+
+	open := false
+	changed, err = todo.MarkMatching(db.Query{Done: &open}, true, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4}, ids(changed))
+	assert.Equal(t, writes+1, journalLen(t, todo), "One write for all of them")
+
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	item, err = todo.GetItem(4)
+	require.NoError(t, err)
+	assert.False(t, item.IsDone)
+}
+
+func TestMarkMatchingBlocked(t *testing.T) {
+	todo := newBulkDb(t)
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 2, Title: "paint shed", BlockedBy: []int{4}}))
+
+	_, err := todo.MarkMatching(db.Query{TitleContains: "shed"}, true, false, false)
+	assert.ErrorIs(t, err, db.ErrBlocked)
+
+	_, err = todo.MarkMatching(db.Query{TitleContains: "s"}, true, false, false)
+	assert.NoError(t, err, "The blocker is marked done in the same write")
+}
+
+func TestDeleteMatching(t *testing.T) {
+	todo := newBulkDb(t)
+
+	done := true
+	_, err := todo.DeleteMatching(db.Query{Done: &done}, db.DeleteRefuse, false)
+	assert.ErrorIs(t, err, db.ErrHasChildren, "3 has an open subtask")
+	assert.Len(t, titles(t, todo), 5)
+
+	deleted, err := todo.DeleteMatching(db.Query{TitleContains: "brushes"}, db.DeleteRefuse, true)
+	require.NoError(t, err, "Subtasks that match go first, so refuse doesn't")
+	assert.ElementsMatch(t, []int{3, 4}, ids(deleted))
+	assert.Len(t, titles(t, todo), 5, "A preview deletes nothing")
+
+	deleted, err = todo.DeleteMatching(db.Query{TitleContains: "buy"}, db.DeleteCascade, false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{3, 4}, ids(deleted))
+	assert.Equal(t, map[int]string{1: "paint fence", 2: "paint shed", 5: "call mom"}, titles(t, todo))
+}
+
+func TestMarkItems(t *testing.T) {
+	todo := newBulkDb(t)
+	entries := journalLen(t, todo)
+
+	assert.ErrorIs(t, todo.MarkItems([]int{2, 42}, true, false, false), db.ErrNotFound)
+	item, err := todo.GetItem(2)
+	require.NoError(t, err)
+	assert.False(t, item.IsDone, "Nothing is marked if one id is missing")
+
+	require.NoError(t, todo.MarkItems([]int{2, 4}, true, false, false))
+	for _, id := range []int{2, 4} {
+		item, err := todo.GetItem(id)
+		require.NoError(t, err)
+		assert.True(t, item.IsDone)
+	}
+	assert.Equal(t, entries+1, journalLen(t, todo), "The ids are marked in one write")
+}
+
+func TestDeleteItems(t *testing.T) {
+	todo := newBulkDb(t)
+	entries := journalLen(t, todo)
+
+	_, err := todo.DeleteItems([]int{1, 3}, db.DeleteRefuse)
+	assert.ErrorIs(t, err, db.ErrHasChildren)
+	assert.Len(t, titles(t, todo), 5, "Nothing is deleted if one id can't be")
+
+	deleted, err := todo.DeleteItems([]int{3, 4, 1}, db.DeleteRefuse)
+	require.NoError(t, err, "Subtasks that are listed go first, so refuse doesn't")
+	assert.ElementsMatch(t, []int{1, 3, 4}, ids(deleted))
+	assert.Equal(t, map[int]string{2: "paint shed", 5: "call mom"}, titles(t, todo))
+	assert.Equal(t, entries+1, journalLen(t, todo), "The ids are deleted in one write")
+}
+
+func TestPurge(t *testing.T) {
+	todo := newBulkDb(t)
+
+	monthAgo := time.Now().AddDate(0, 0, -30)
+	deleted, err := todo.Purge(db.Query{CompletedBefore: monthAgo}, true)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, ids(deleted), "Only items finished before the cutoff")
+	assert.Len(t, titles(t, todo), 5)
+
+	open := false
+	deleted, err = todo.Purge(db.Query{Done: &open, CompletedBefore: monthAgo}, false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, ids(deleted), "Purge only ever deletes done items")
+
+	item, err := todo.GetItem(4)
+	require.NoError(t, err)
+	assert.Equal(t, 0, item.ParentId, "Open subtasks move up instead of going too")
+
+	deleted, err = todo.Purge(db.Query{}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5}, ids(deleted))
+	assert.Equal(t, map[int]string{2: "paint shed", 4: "clean brushes"}, titles(t, todo))
+
+	_, err = todo.Purge(db.Query{Sort: []db.SortKey{{Field: "colour"}}}, false)
+	assert.EqualError(t, err, `Purge: can't sort on unknown field "colour"`)
+}
+
+func TestQueryCompletedBefore(t *testing.T) {
+	cutoff := time.Date(2031, time.January, 1, 0, 0, 0, 0, time.UTC)
+	before := cutoff.Add(-time.Hour)
+	q := db.Query{CompletedBefore: cutoff}
+
+	assert.True(t, q.Matches(db.ToDoItem{IsDone: true, CompletedAt: &before}))
+	assert.False(t, q.Matches(db.ToDoItem{IsDone: true, CompletedAt: &cutoff}))
+	assert.False(t, q.Matches(db.ToDoItem{IsDone: true}), "Items with no completion time aren't old")
+}
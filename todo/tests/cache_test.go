@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletedElsewhereStaysDeleted(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	mine, err := db.New(dbFile)
+	require.NoError(t, err)
+	defer mine.Close()
+	theirs, err := db.New(dbFile)
+	require.NoError(t, err)
+	defer theirs.Close()
+
+	for _, title := range []string{"keep", "drop"} {
+		_, err := mine.AddItem(db.ToDoItem{Title: title})
+		require.NoError(t, err)
+	}
+	require.NoError(t, theirs.DeleteItem(2))
+
+	assert.Equal(t, map[int]string{1: "keep"}, titles(t, mine), "The map matches the file, not what was loaded before")
+
+	//And a write from here doesn't bring it back
+	require.NoError(t, mine.ChangeItemDoneStatus(1, true))
+	assert.Equal(t, map[int]string{1: "keep"}, titles(t, theirs))
+}
+
+func TestJsonStoreVersion(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	store, err := db.NewJsonStore(dbFile)
+	require.NoError(t, err)
+
+	first, err := store.Version()
+	require.NoError(t, err)
+	again, err := store.Version()
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(dbFile, later, later))
+	touched, err := store.Version()
+	require.NoError(t, err)
+	assert.Equal(t, first, touched, "Touching the file doesn't change what is in it")
+
+	require.NoError(t, store.Save(db.Contents{Items: db.DbMap{1: {Id: 1, Title: "saved"}}}))
+	saved, err := store.Version()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, saved)
+
+	//An edit in place, by hand say, is noticed too
+	other, err := db.NewJsonStore(dbFile)
+	require.NoError(t, err)
+	require.NoError(t, other.Save(db.Contents{Items: db.DbMap{1: {Id: 1, Title: "edited"}}}))
+	edited, err := store.Version()
+	require.NoError(t, err)
+	assert.NotEqual(t, saved, edited)
+
+	contents, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "edited", contents.Items[1].Title)
+}
+
+func TestCachedItemsAreCopies(t *testing.T) {
+	todo := newJournalDb(t)
+	_, err := todo.AddItem(db.ToDoItem{Title: "tagged", Tags: []string{"a"}})
+	require.NoError(t, err)
+
+	item, err := todo.GetItem(1)
+	require.NoError(t, err)
+	item.Title = "changed by the caller"
+	items, err := todo.GetAllItems()
+	require.NoError(t, err)
+	items[0].Title = "also changed by the caller"
+
+	item, err = todo.GetItem(1)
+	require.NoError(t, err)
+	assert.Equal(t, "tagged", item.Title)
+}
+
+// newBigDb returns a file DB holding n items, written in one go
+func newBigDb(b *testing.B, n int) (*db.ToDo, string) {
+	dir := b.TempDir()
+	dbFile := dir + "/todo.json"
+	todo, err := db.New(dbFile)
+	require.NoError(b, err)
+	b.Cleanup(func() { todo.Close() })
+
+	due := time.Date(2031, time.January, 1, 0, 0, 0, 0, time.UTC)
+	err = todo.Update(func(tx *db.Tx) error {
+		for i := 1; i <= n; i++ {
+			_, err := tx.AddItem(db.ToDoItem{Title: fmt.Sprintf("item %d", i), DueDate: &due,
+				Priority: i % 5, Tags: []string{"bench"}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(b, err)
+	return todo, dbFile
+}
+
+// BenchmarkGetItem100k reads an item from a 100,000 item DB that nothing
+// else is writing, which only takes a stat of the file
+func BenchmarkGetItem100k(b *testing.B) {
+	todo, _ := newBigDb(b, 100_000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := todo.GetItem(1 + i%100_000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetItem100kTouched touches the file before every read, so it
+// is read and hashed each time, but not parsed
+func BenchmarkGetItem100kTouched(b *testing.B) {
+	todo, dbFile := newBigDb(b, 100_000)
+	start := time.Now()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		touched := start.Add(time.Duration(i+1) * time.Second)
+		if err := os.Chtimes(dbFile, touched, touched); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := todo.GetItem(1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetItem100kChanged has another ToDo change the file before
+// every read, so it is parsed in full each time, which is what every
+// read cost before loads were cached
+func BenchmarkGetItem100kChanged(b *testing.B) {
+	todo, dbFile := newBigDb(b, 100_000)
+	other, err := db.New(dbFile)
+	require.NoError(b, err)
+	b.Cleanup(func() { other.Close() })
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := other.ChangeItemDoneStatus(1, i%2 == 0); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		if _, err := todo.GetItem(1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkChangeItem100k is one write to a 100,000 item DB, which has
+// to encode and save the whole file
+func BenchmarkChangeItem100k(b *testing.B) {
+	todo, _ := newBigDb(b, 100_000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := todo.ChangeItemDoneStatus(1+i%100_000, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
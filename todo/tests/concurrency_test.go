@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// These tests hammer one database file from many writers at once and
+// then check that every single write made it to disk.  Each test gets
+// its own database in a temp directory so they don't disturb the
+// shared ../data/todo.json used by the rest of the suite.
+
+const (
+	WRITERS          = 8
+	ITEMS_PER_WRITER = 25
+)
+
+func newTempDbFile(t *testing.T) string {
+	dbFile := filepath.Join(t.TempDir(), "todo.json")
+	todo, err := db.New(dbFile)
+	if assert.NoError(t, err, "Creating temp DB") {
+		todo.Close()
+	}
+	return dbFile
+}
+
+// Every goroutine gets its own ToDo, just like separate CLI invocations
+// would, so only the file lock keeps them from clobbering each other.
+func TestConcurrentAddItemGoroutines(t *testing.T) {
+	dbFile := newTempDbFile(t)
+
+	var wg sync.WaitGroup
+	for w := 0; w < WRITERS; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			todo, err := db.New(dbFile)
+			if !assert.NoError(t, err, "Opening DB in writer %d", w) {
+				return
+			}
+			for i := 1; i <= ITEMS_PER_WRITER; i++ {
+				item := db.ToDoItem{
+					Id:    w*1000 + i,
+					Title: fmt.Sprintf("writer %d item %d", w, i),
+				}
+				_, err := todo.AddItem(item)
+				assert.NoError(t, err, "Adding item %d", item.Id)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	checkAllWritesLanded(t, dbFile)
+}
+
+// Goroutines sharing one ToDo are serialized by its mutex.
+func TestConcurrentUpdatesSharedToDo(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err, "Opening DB")
+
+	for i := 1; i <= WRITERS*ITEMS_PER_WRITER; i++ {
+		_, err := todo.AddItem(db.ToDoItem{Id: i, Title: "new"})
+		assert.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= WRITERS*ITEMS_PER_WRITER; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			assert.NoError(t, todo.ChangeItemDoneStatus(id, true), "Marking %d done", id)
+		}(i)
+	}
+	wg.Wait()
+
+	fresh, err := db.New(dbFile)
+	assert.NoError(t, err, "Reopening DB")
+	items, err := fresh.GetAllItems()
+	assert.NoError(t, err, "Getting all items")
+	assert.Equal(t, WRITERS*ITEMS_PER_WRITER, len(items))
+	for _, item := range items {
+		assert.True(t, item.IsDone, "Item %d should be done", item.Id)
+	}
+}
+
+// TestConcurrentAddItemProcesses re-runs this test binary as several
+// child processes that all add items to the same file, which is how
+// scripts and cron jobs drive the CLI.
+func TestConcurrentAddItemProcesses(t *testing.T) {
+	dbFile := newTempDbFile(t)
+
+	var cmds []*exec.Cmd
+	for w := 0; w < WRITERS; w++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperAddItems$")
+		cmd.Env = append(os.Environ(),
+			"TODO_HELPER_DB="+dbFile,
+			"TODO_HELPER_WRITER="+strconv.Itoa(w))
+		assert.NoError(t, cmd.Start(), "Starting writer process %d", w)
+		cmds = append(cmds, cmd)
+	}
+	for w, cmd := range cmds {
+		assert.NoError(t, cmd.Wait(), "Writer process %d failed", w)
+	}
+
+	checkAllWritesLanded(t, dbFile)
+}
+
+// TestHelperAddItems is not a real test, it is the body of the child
+// processes started by TestConcurrentAddItemProcesses.
+func TestHelperAddItems(t *testing.T) {
+	dbFile := os.Getenv("TODO_HELPER_DB")
+	if dbFile == "" {
+		t.Skip("only runs as a helper process")
+	}
+	w, _ := strconv.Atoi(os.Getenv("TODO_HELPER_WRITER"))
+
+	todo, err := db.New(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= ITEMS_PER_WRITER; i++ {
+		item := db.ToDoItem{Id: w*1000 + i, Title: fmt.Sprintf("writer %d item %d", w, i)}
+		if _, err := todo.AddItem(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkAllWritesLanded(t *testing.T, dbFile string) {
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err, "Reopening DB")
+
+	items, err := todo.GetAllItems()
+	assert.NoError(t, err, "Getting all items")
+	assert.Equal(t, WRITERS*ITEMS_PER_WRITER, len(items), "No write should be lost")
+
+	for w := 0; w < WRITERS; w++ {
+		for i := 1; i <= ITEMS_PER_WRITER; i++ {
+			_, err := todo.GetItem(w*1000 + i)
+			assert.NoError(t, err, "Item %d should be present", w*1000+i)
+		}
+	}
+
+	//The atomic save must not leave temp files lying around
+	leftovers, _ := filepath.Glob(dbFile + ".tmp-*")
+	assert.Empty(t, leftovers, "Temp files left behind")
+}
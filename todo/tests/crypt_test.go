@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSecretDb returns an encrypted DB holding one item, a snapshot of
+// it and a plaintext backup file
+func newSecretDb(t *testing.T, passphrase string) (*db.ToDo, string) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "call Acme about the outage"})
+	require.NoError(t, err)
+	_, err = todo.Backup()
+	require.NoError(t, err)
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dbFile+".bak", data, 0644))
+
+	todo.SetPassphrase([]byte(passphrase))
+	require.NoError(t, todo.Encrypt())
+	return todo, dbFile
+}
+
+// leaks returns every file next to dbFile that has secret in it
+func leaks(t *testing.T, dbFile, secret string) []string {
+	files, err := filepath.Glob(dbFile + "*")
+	require.NoError(t, err)
+	snapshots, err := filepath.Glob(filepath.Join(dbFile+".snapshots", "*"))
+	require.NoError(t, err)
+
+	var found []string
+	for _, file := range append(files, snapshots...) {
+		data, err := os.ReadFile(file)
+		if err == nil && strings.Contains(string(data), secret) {
+			found = append(found, file)
+		}
+	}
+	return found
+}
+
+func TestEncrypt(t *testing.T) {
+	todo, dbFile := newSecretDb(t, "s3cret")
+	_, err := todo.AddItem(db.ToDoItem{Title: "refund Acme"})
+	require.NoError(t, err)
+	assert.Empty(t, leaks(t, dbFile, "Acme"), "Everything with items in it is encrypted")
+
+	other, err := db.New(dbFile)
+	require.NoError(t, err)
+	_, err = other.GetAllItems()
+	assert.ErrorIs(t, err, db.ErrNoPassphrase)
+	assert.NotErrorIs(t, err, db.ErrCorrupt)
+	other.SetPassphrase([]byte("wrong"))
+	_, err = other.GetAllItems()
+	assert.ErrorIs(t, err, db.ErrBadPassphrase)
+	other.SetPassphrase([]byte("s3cret"))
+	assert.Equal(t, map[int]string{1: "call Acme about the outage", 2: "refund Acme"}, titles(t, other))
+
+	_, err = other.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "call Acme about the outage"}, titles(t, other),
+		"The journal can still be read")
+	assert.ErrorContains(t, other.Encrypt(), "already encrypted")
+
+	require.NoError(t, other.Decrypt())
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "call Acme about the outage")
+	plain, err := db.New(dbFile)
+	require.NoError(t, err)
+	entries, err := plain.Journal()
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestRekey(t *testing.T) {
+	todo, dbFile := newSecretDb(t, "old")
+	require.NoError(t, todo.Rekey([]byte("new")))
+	_, err := todo.AddItem(db.ToDoItem{Title: "after rekey"})
+	require.NoError(t, err)
+	assert.Empty(t, leaks(t, dbFile, "Acme"))
+
+	old, err := db.New(dbFile)
+	require.NoError(t, err)
+	old.SetPassphrase([]byte("old"))
+	_, err = old.GetAllItems()
+	assert.ErrorIs(t, err, db.ErrBadPassphrase)
+
+	rekeyed, err := db.New(dbFile)
+	require.NoError(t, err)
+	rekeyed.SetPassphrase([]byte("new"))
+	snapshot, err := rekeyed.FindSnapshot("latest")
+	require.NoError(t, err)
+	require.NoError(t, rekeyed.RestoreSnapshot(snapshot))
+	assert.Equal(t, map[int]string{1: "call Acme about the outage"}, titles(t, rekeyed),
+		"Snapshots are encrypted with the new key too")
+}
+
+func TestRestoreEncryptedBackup(t *testing.T) {
+	todo, dbFile := newSecretDb(t, "s3cret")
+	require.NoError(t, todo.DeleteItem(1))
+
+	require.NoError(t, todo.RestoreDB())
+	assert.Equal(t, map[int]string{1: "call Acme about the outage"}, titles(t, todo))
+
+	todo.SetPassphrase(nil)
+	assert.ErrorIs(t, todo.RestoreDB(), db.ErrNoPassphrase)
+	assert.Empty(t, leaks(t, dbFile, "Acme"))
+}
+
+func TestRepairEncrypted(t *testing.T) {
+	todo, dbFile := newSecretDb(t, "s3cret")
+	_, err := todo.Backup()
+	require.NoError(t, err)
+
+	//Change a character of the ciphertext, and leave a newer backup
+	//that isn't encrypted
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	i := strings.Index(string(data), `"data":"`) + len(`"data":"`)
+	if data[i] == 'A' {
+		data[i] = 'B'
+	} else {
+		data[i] = 'A'
+	}
+	require.NoError(t, os.WriteFile(dbFile, data, 0644))
+	planted := `{"version": 2, "last_id": 1, "items": [{"id": 1, "title": "planted", "done": false}]}`
+	require.NoError(t, os.WriteFile(dbFile+".bak", []byte(planted), 0644))
+	_, err = todo.GetAllItems()
+	assert.ErrorIs(t, err, db.ErrBadPassphrase)
+
+	report, err := todo.Repair(false)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Fix{db.FixRestore}, fixes(report.Problems))
+	assert.Contains(t, report.Backup, "manual", "Only an encrypted backup shows the passphrase is right")
+	assert.Equal(t, map[int]string{1: "call Acme about the outage"}, titles(t, todo))
+	assert.Equal(t, []string{dbFile + ".bak"}, leaks(t, dbFile, "planted"))
+	assert.Empty(t, leaks(t, dbFile, "Acme"), "The restored file is encrypted, and so is the quarantine")
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptFailedSave(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "call Acme about the outage"})
+	require.NoError(t, err)
+	_, err = todo.Backup()
+	require.NoError(t, err)
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dbFile+".bak", data, 0644))
+
+	//The database is made much bigger than the files next to it, so a
+	//file size limit between the two lets those be written but not it
+	notes := strings.Repeat("Acme ", 64<<10)
+	big := `{"version": 2, "last_id": 1, "items": [{"id": 1, "title": "call Acme", "notes": "` + notes + `"}]}`
+	require.NoError(t, os.WriteFile(dbFile, []byte(big), 0644))
+	var limit syscall.Rlimit
+	require.NoError(t, syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit))
+	require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: 64 << 10, Max: limit.Max}))
+	todo.SetPassphrase([]byte("s3cret"))
+	err = todo.Encrypt()
+	require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit))
+	require.Error(t, err)
+
+	//Everything is still in the clear, so the database and its backups
+	//can all be read without the passphrase
+	plain, err := db.New(dbFile)
+	require.NoError(t, err)
+	item, err := plain.GetItem(1)
+	require.NoError(t, err)
+	assert.Equal(t, "call Acme", item.Title)
+	snapshots, err := plain.Snapshots()
+	require.NoError(t, err)
+	backup, err := plain.BackupFile()
+	require.NoError(t, err)
+	for _, snapshot := range append(snapshots, backup) {
+		_, err = plain.DiffSnapshot(snapshot)
+		assert.NoError(t, err, snapshot.Name)
+	}
+	entries, err := plain.Journal()
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	leftovers, err := filepath.Glob(dbFile + "*.tmp-*")
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDepsDb returns a DB where
+//
+//	1 buy paint
+//	2 sand walls
+//	3 paint walls, blocked by 1 and 2
+//	4 hang pictures, blocked by 3
+//	5 call mom, priority 2
+func newDepsDb(t *testing.T) *db.ToDo {
+	return newDbWith(t,
+		db.ToDoItem{Title: "buy paint"},
+		db.ToDoItem{Title: "sand walls"},
+		db.ToDoItem{Title: "paint walls", BlockedBy: []int{1, 2}},
+		db.ToDoItem{Title: "hang pictures", BlockedBy: []int{3}},
+		db.ToDoItem{Title: "call mom", Priority: 2},
+	)
+}
+
+func TestBlockerChecks(t *testing.T) {
+	todo := newDepsDb(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "waiting on nothing", BlockedBy: []int{99}})
+	assert.ErrorIs(t, err, db.ErrBadBlocker, "Blockers must exist")
+
+	err = todo.UpdateItem(db.ToDoItem{Id: 1, Title: "buy paint", BlockedBy: []int{1}})
+	assert.ErrorIs(t, err, db.ErrBadBlocker, "An item can't block itself")
+	err = todo.UpdateItem(db.ToDoItem{Id: 1, Title: "buy paint", BlockedBy: []int{4}})
+	assert.ErrorIs(t, err, db.ErrBadBlocker, "4 waits on 3, which waits on 1")
+
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 5, Title: "call mom", BlockedBy: []int{4}}))
+
+	_, err = todo.ImportItems([]db.ToDoItem{{Id: 1, Title: "loop", BlockedBy: []int{5}}}, db.CollisionOverwrite, false)
+	assert.ErrorIs(t, err, db.ErrBadBlocker, "Imports are checked too")
+}
+
+func TestNextItems(t *testing.T) {
+	todo := newDepsDb(t)
+
+	next, err := todo.NextItems()
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 1, 2}, ids(next), "Only unblocked items, the most urgent first")
+
+	items, err := todo.GetAllItems()
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 1, 2, 3, 4}, ids(db.DependencyOrder(items)))
+
+	require.NoError(t, todo.ChangeItemDoneStatus(1, true))
+	require.NoError(t, todo.ChangeItemDoneStatus(2, true))
+	next, err = todo.NextItems()
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 3}, ids(next), "Done blockers don't block")
+}
+
+func TestDependencyOrderLoop(t *testing.T) {
+	items := []db.ToDoItem{
+		{Id: 1, Title: "a", BlockedBy: []int{2}},
+		{Id: 2, Title: "b", BlockedBy: []int{1}},
+		{Id: 3, Title: "c", BlockedBy: []int{4}},
+		{Id: 4, Title: "gone", IsDone: true},
+	}
+	assert.Equal(t, []int{3, 1, 2}, ids(db.DependencyOrder(items)), "A loop from a hand edited file isn't lost")
+}
+
+func TestDoneBlockedItem(t *testing.T) {
+	todo := newDepsDb(t)
+
+	err := todo.ChangeItemDoneStatus(3, true)
+	assert.ErrorIs(t, err, db.ErrBlocked)
+	assert.Contains(t, err.Error(), "waiting on 1,2")
+	item, err := todo.GetItem(3)
+	require.NoError(t, err)
+	assert.False(t, item.IsDone)
+
+	require.NoError(t, todo.ChangeDoneStatus(3, true, false, true), "force marks it done anyway")
+	require.NoError(t, todo.ChangeItemDoneStatus(3, false), "Undone is never blocked")
+
+	//Blockers done in the same write count as done
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 1, Title: "buy paint", ParentId: 3}))
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 2, Title: "sand walls", ParentId: 3}))
+	require.NoError(t, todo.ChangeTreeDoneStatus(3, true))
+}
+
+func TestDeleteBlocker(t *testing.T) {
+	todo := newDepsDb(t)
+
+	require.NoError(t, todo.DeleteItem(1))
+	item, err := todo.GetItem(3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, item.BlockedBy, "Deleted items stop blocking")
+}
+
+func TestBlockersRoundTrip(t *testing.T) {
+	items := []db.ToDoItem{{Id: 3, Title: "paint walls", BlockedBy: []int{1, 2}}}
+
+	for _, format := range []db.Format{db.FormatCSV, db.FormatTodoTxt, db.FormatJSON} {
+		read, err := db.ReadItems(strings.NewReader(writeItems(t, format, items)), format)
+		require.NoError(t, err, format)
+		require.Len(t, read, 1, format)
+		assert.Equal(t, items[0].BlockedBy, read[0].BlockedBy, format)
+	}
+
+	todo := newDepsDb(t)
+	actions, err := todo.ImportItems([]db.ToDoItem{
+		{Id: 1, Title: "imported blocker"},
+		{Id: 10, Title: "imported item", BlockedBy: []int{1, 5}},
+	}, db.CollisionRenumber, false)
+	require.NoError(t, err)
+	assert.Equal(t, []int{actions[0].Item.Id, 5}, actions[1].Item.BlockedBy, "Blockers follow renumbering")
+}
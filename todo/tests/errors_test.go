@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestItemErrors(t *testing.T) {
+	todo, err := db.New(newTempDbFile(t))
+	require.NoError(t, err)
+	id, err := todo.AddItem(db.ToDoItem{Title: "there"})
+	require.NoError(t, err)
+
+	_, err = todo.GetItem(42)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	assert.ErrorContains(t, err, "item 42 does not exist", "The message has the id asked for")
+	var itemErr *db.ItemError
+	require.ErrorAs(t, err, &itemErr)
+	assert.Equal(t, 42, itemErr.Id)
+
+	assert.ErrorIs(t, todo.UpdateItem(db.ToDoItem{Id: 42, Title: "missing"}), db.ErrNotFound)
+	assert.ErrorIs(t, todo.DeleteItem(42), db.ErrNotFound)
+	err = todo.ChangeItemDoneStatus(42, true)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	assert.EqualError(t, err, "ChangeItemDoneStatus: item 42 does not exist", "The method name is there once")
+	assert.EqualError(t, todo.ChangeTreeDoneStatus(42, true), "ChangeTreeDoneStatus: item 42 does not exist")
+	_, err = todo.StartTimer(42)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	_, err = todo.AddItem(db.ToDoItem{Id: id, Title: "again"})
+	assert.ErrorIs(t, err, db.ErrExists)
+	assert.NotErrorIs(t, err, db.ErrNotFound)
+
+	//Ids start at 1, as fsck checks
+	_, err = todo.AddItem(db.ToDoItem{Id: -5, Title: "negative"})
+	assert.ErrorIs(t, err, db.ErrBadId)
+	assert.ErrorContains(t, err, "item -5 has a negative id")
+	assert.ErrorIs(t, todo.UpdateItem(db.ToDoItem{Id: -5, Title: "negative"}), db.ErrBadId)
+	_, err = todo.ImportItems([]db.ToDoItem{{Id: -5, Title: "negative"}}, db.CollisionRenumber, false)
+	assert.ErrorIs(t, err, db.ErrBadId)
+	assert.Equal(t, map[int]string{id: "there"}, titles(t, todo))
+}
+
+func TestStoreErrors(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dbFile, []byte(`{"items": [`), 0644))
+
+	_, err = todo.GetAllItems()
+	assert.ErrorIs(t, err, db.ErrCorrupt)
+	var storeErr *db.StoreError
+	require.ErrorAs(t, err, &storeErr)
+	assert.Equal(t, "loading", storeErr.Op)
+	assert.NotErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestContextDone(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = todo.AddItemContext(ctx, db.ToDoItem{Title: "too late"})
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.GetItemContext(ctx, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	err = todo.UpdateContext(ctx, func(tx *db.Tx) error {
+		_, err := tx.AddItem(db.ToDoItem{Title: "too late"})
+		return err
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.NextItemsContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, _, err = todo.DumpContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.ReplaceContext(ctx, []byte(`{"version": 2, "items": []}`), "", "")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.UndoContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.ImportItemsContext(ctx, []db.ToDoItem{{Title: "too late"}}, db.CollisionRenumber, false)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, todo.DeleteItemContext(ctx, 1), context.Canceled)
+	assert.ErrorIs(t, todo.ChangeItemDoneStatusContext(ctx, 1, true), context.Canceled)
+	assert.ErrorIs(t, todo.MarkItemsContext(ctx, []int{1}, true, false, false), context.Canceled)
+	_, err = todo.DeleteItemsContext(ctx, []int{1}, db.DeleteRefuse)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.PurgeContext(ctx, db.Query{}, false)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.ReportContext(ctx, db.ByItem, time.Time{}, time.Time{}, time.UTC)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.RepairContext(ctx, false)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.BackupContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = todo.BackupFileContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, todo.RestoreDBContext(ctx), context.Canceled)
+	assert.Empty(t, titles(t, todo))
+
+	//A write waiting for another process to let go of the lock gives up
+	//when its context does, and the lock still works afterwards
+	store, err := db.NewJsonStore(dbFile)
+	require.NoError(t, err)
+	unlock, err := store.Lock()
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = todo.AddItemContext(ctx, db.ToDoItem{Title: "waited"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	snapshot, err := todo.Backup()
+	require.NoError(t, err)
+	assert.ErrorIs(t, todo.RestoreSnapshotContext(ctx, snapshot), context.DeadlineExceeded)
+	require.NoError(t, unlock())
+
+	id, err := todo.AddItemContext(context.Background(), db.ToDoItem{Title: "in time"})
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{id: "in time"}, titles(t, todo))
+}
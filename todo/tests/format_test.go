@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formatItems() []db.ToDoItem {
+	due := time.Date(2031, time.March, 4, 0, 0, 0, 0, time.UTC)
+	return []db.ToDoItem{
+		{Id: 1, Title: "plain"},
+		{Id: 2, Title: "with, a comma", IsDone: true, DueDate: &due, Priority: 3,
+			Tags: []string{"a", "b"}, Notes: "said \"hi\"", Assignee: "sam"},
+	}
+}
+
+func writeItems(t *testing.T, format db.Format, items []db.ToDoItem) string {
+	var buf bytes.Buffer
+	require.NoError(t, db.WriteItems(&buf, format, items), "Writing %s", format)
+	return buf.String()
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range db.Formats {
+		parsed, err := db.ParseFormat(string(format))
+		assert.NoError(t, err)
+		assert.Equal(t, format, parsed)
+	}
+
+	parsed, err := db.ParseFormat("md")
+	assert.NoError(t, err)
+	assert.Equal(t, db.FormatMarkdown, parsed)
+
+	_, err = db.ParseFormat("xml")
+	assert.Error(t, err, "Unknown formats should be rejected")
+}
+
+func TestWriteTable(t *testing.T) {
+	lines := strings.Split(strings.TrimRight(writeItems(t, db.FormatTable, formatItems()), "\n"), "\n")
+	require.Len(t, lines, 3, "A header and one row per item")
+
+	assert.True(t, strings.HasPrefix(lines[0], "ID"))
+	assert.Contains(t, lines[2], "[x]")
+	assert.Contains(t, lines[2], "2031-03-04")
+	assert.Contains(t, lines[2], "a,b")
+
+	//The columns line up, so the titles all start at the same place
+	col := strings.Index(lines[0], "TITLE")
+	assert.Equal(t, col, strings.Index(lines[1], "plain"))
+	assert.Equal(t, col, strings.Index(lines[2], "with, a comma"))
+}
+
+func TestWriteCSV(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(writeItems(t, db.FormatCSV, formatItems()))).ReadAll()
+	require.NoError(t, err, "The output should be valid CSV")
+	require.Len(t, records, 3)
+
+	assert.Equal(t, []string{"id", "title", "done", "due", "repeat", "priority", "tags", "notes", "assignee",
+		"list", "parent", "blocked_by", "created_at", "updated_at", "completed_at"}, records[0])
+	assert.Equal(t, []string{"2", "with, a comma", "true", "2031-03-04T00:00:00Z", "", "3", "a,b",
+		"said \"hi\"", "sam", "", "", "", "", "", ""}, records[2])
+}
+
+func TestWriteJSONL(t *testing.T) {
+	items := formatItems()
+	lines := strings.Split(strings.TrimRight(writeItems(t, db.FormatJSONL, items), "\n"), "\n")
+	require.Len(t, lines, len(items), "One line per item")
+
+	for i, line := range lines {
+		var item db.ToDoItem
+		require.NoError(t, json.Unmarshal([]byte(line), &item))
+		assert.Equal(t, items[i], item)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	items := formatItems()
+	var decoded []db.ToDoItem
+	require.NoError(t, json.Unmarshal([]byte(writeItems(t, db.FormatJSON, items)), &decoded))
+	assert.Equal(t, items, decoded)
+
+	assert.Equal(t, "[]\n", writeItems(t, db.FormatJSON, nil), "No items is still an array")
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	assert.Equal(t, "- [ ] plain\n- [x] with, a comma\n", writeItems(t, db.FormatMarkdown, formatItems()))
+}
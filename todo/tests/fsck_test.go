@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const brokenItemsDb = `{
+  "version": 2,
+  "last_id": 2,
+  "items": [
+    {"id": 1, "title": "first", "done": false},
+    {"id": 2, "title": "bad done", "done": "yes"},
+    {"id": 1, "title": "second", "done": false, "blocked_by": [9]},
+    {"title": "no id"},
+    {"id": 3, "title": "orphan", "parent": 2},
+    7
+  ]
+}`
+
+// quarantineLines reads the quarantine file next to dbFile
+func quarantineLines(t *testing.T, dbFile string) []map[string]any {
+	f, err := os.Open(dbFile + ".quarantine")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+func fixes(problems []db.Problem) []db.Fix {
+	var found []db.Fix
+	for _, problem := range problems {
+		found = append(found, problem.Fix)
+	}
+	return found
+}
+
+func TestRepairItems(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	require.NoError(t, os.WriteFile(dbFile, []byte(brokenItemsDb), 0644))
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+
+	_, err = todo.GetAllItems()
+	assert.ErrorIs(t, err, db.ErrCorrupt)
+
+	report, err := todo.Repair(true)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Fix{db.FixQuarantine, db.FixQuarantine, db.FixQuarantine,
+		db.FixRewrite, db.FixRenumber, db.FixClear, db.FixClear}, fixes(report.Problems))
+	assert.Equal(t, "line 6, column 5", report.Problems[0].Location)
+	assert.Contains(t, report.Problems[0].Message, "done")
+	assert.False(t, report.Repaired)
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	assert.Equal(t, brokenItemsDb, string(data), "A preview changes nothing")
+
+	report, err = todo.Repair(false)
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+	assert.Equal(t, dbFile+".quarantine", report.Quarantine)
+
+	assert.Equal(t, map[int]string{1: "second", 3: "orphan", 4: "first"}, titles(t, todo),
+		"The last item with an id keeps it, as it is the one that loaded")
+	item, err := todo.GetItem(1)
+	require.NoError(t, err)
+	assert.Empty(t, item.BlockedBy)
+	item, err = todo.GetItem(3)
+	require.NoError(t, err)
+	assert.Equal(t, 0, item.ParentId, "Its parent was quarantined")
+
+	lines := quarantineLines(t, dbFile)
+	require.Len(t, lines, 3)
+	assert.Equal(t, map[string]any{"id": 2.0, "title": "bad done", "done": "yes"}, lines[0]["record"])
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "new"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, id)
+
+	report, err = todo.Repair(true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+}
+
+func TestRepairParentLoop(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	require.NoError(t, os.WriteFile(dbFile, []byte(`{"version": 2, "last_id": 4, "items": [
+    {"id": 1, "title": "one", "parent": 2},
+    {"id": 2, "title": "two", "parent": 3},
+    {"id": 3, "title": "three", "parent": 1},
+    {"id": 4, "title": "four", "parent": 3}
+  ]}`), 0644))
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+
+	report, err := todo.Repair(true)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, db.FixClear, report.Problems[0].Fix)
+	assert.Equal(t, 1, report.Problems[0].Id)
+	assert.Equal(t, "line 2, column 5", report.Problems[0].Location)
+	assert.Contains(t, report.Problems[0].Message, "through 2,3")
+
+	report, err = todo.Repair(false)
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+	item, err := todo.GetItem(1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, item.ParentId, "The lowest id in the loop becomes a top-level item")
+	item, err = todo.GetItem(4)
+	require.NoError(t, err)
+	assert.Equal(t, 3, item.ParentId, "Items hanging off the loop stay where they are")
+	report, err = todo.Repair(true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+
+	//A repair is a write like any other, so it can be undone
+	snapshots, err := todo.Snapshots()
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	entry, err := todo.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, "repair", entry.Op)
+	item, err = todo.GetItem(1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, item.ParentId)
+}
+
+func TestRepairRestoresBackup(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	for _, title := range []string{"kept", "lost"} {
+		_, err := todo.AddItem(db.ToDoItem{Title: title})
+		require.NoError(t, err)
+	}
+
+	broken := `{"version": 2, "items": [{"id": 1,, `
+	require.NoError(t, os.WriteFile(dbFile, []byte(broken), 0644))
+
+	report, err := todo.Repair(true)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, db.FixRestore, report.Problems[0].Fix)
+	assert.Equal(t, "line 1, column 35", report.Problems[0].Location)
+	assert.NotEmpty(t, report.Backup)
+
+	report, err = todo.Repair(false)
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+	assert.Equal(t, map[int]string{1: "kept"}, titles(t, todo), "The newest snapshot is from before the last add")
+
+	lines := quarantineLines(t, dbFile)
+	require.Len(t, lines, 1)
+	assert.Equal(t, broken, lines[0]["text"], "The broken file is kept whole")
+}
+
+func TestRepairWithoutBackup(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	require.NoError(t, os.WriteFile(dbFile, []byte(`{"version": 2, "items": [`), 0644))
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+
+	report, err := todo.Repair(false)
+	assert.Error(t, err)
+	assert.Equal(t, []db.Fix{db.FixNone}, fixes(report.Problems))
+	assert.False(t, report.Repaired)
+	_, err = os.Stat(dbFile + ".quarantine")
+	assert.ErrorIs(t, err, os.ErrNotExist, "Nothing is touched if not everything can be fixed")
+}
+
+func TestRepairNewerSchema(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	require.NoError(t, os.WriteFile(dbFile, []byte(`{"version": 99, "items": []}`), 0644))
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+
+	_, err = todo.GetAllItems()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, db.ErrCorrupt, "A newer file isn't a corrupt one")
+
+	report, err := todo.Repair(false)
+	assert.Error(t, err)
+	assert.Equal(t, []db.Fix{db.FixNone}, fixes(report.Problems))
+}
+
+func TestRepairOldSchema(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	require.NoError(t, os.WriteFile(dbFile, []byte(`[{"id": 1, "title": "old", "done": false}]`), 0644))
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+
+	report, err := todo.Repair(true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems, "Version 1 files are fine as they are")
+}
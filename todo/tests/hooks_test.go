@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHooksDb returns an empty DB with a hooks directory, and the
+// directory
+func newHooksDb(t *testing.T) (*db.ToDo, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are shell scripts")
+	}
+
+	todo, err := db.New(newTempDbFile(t))
+	require.NoError(t, err)
+	dir := t.TempDir()
+	todo.SetHooksDir(dir)
+	return todo, dir
+}
+
+// writeHook adds a shell script hook to dir
+func writeHook(t *testing.T, dir, name, script string) {
+	err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	require.NoError(t, err)
+}
+
+func TestOnHooks(t *testing.T) {
+	todo, dir := newHooksDb(t)
+	log := filepath.Join(dir, "log")
+	for _, name := range []string{"on-add", "on-update", "on-complete", "on-delete"} {
+		writeHook(t, dir, name, `echo "$TODO_HOOK $TODO_OP $TODO_ID $(cat)" >> `+log)
+	}
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "hooked"})
+	require.NoError(t, err)
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: id, Title: "hooked again"}))
+	require.NoError(t, todo.ChangeItemDoneStatus(id, true))
+	require.NoError(t, todo.DeleteItem(id))
+	_, err = todo.Undo()
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(log)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 5)
+	for i, prefix := range []string{"on-add add 1 ", "on-update update 1 ", "on-complete done 1 ",
+		"on-delete delete 1 ", "on-add undo 1 "} {
+		assert.True(t, strings.HasPrefix(lines[i], prefix), lines[i])
+	}
+	assert.Contains(t, lines[1], `"title":"hooked again"`)
+	assert.Contains(t, lines[3], `"title":"hooked again"`, "A deleted item is sent as it was")
+
+	//A hook that isn't executable doesn't run
+	require.NoError(t, os.Chmod(filepath.Join(dir, "on-add"), 0644))
+	_, err = todo.AddItem(db.ToDoItem{Title: "quiet"})
+	require.NoError(t, err)
+	data, err = os.ReadFile(log)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 5)
+}
+
+func TestPreHookVeto(t *testing.T) {
+	todo, dir := newHooksDb(t)
+	id, err := todo.AddItem(db.ToDoItem{Title: "keep me"})
+	require.NoError(t, err)
+	writeHook(t, dir, "pre-delete", `echo "nothing is ever deleted here" >&2; exit 1`)
+
+	err = todo.DeleteItem(id)
+	assert.ErrorIs(t, err, db.ErrVetoed)
+	assert.ErrorContains(t, err, "nothing is ever deleted here")
+	_, err = todo.GetItem(id)
+	assert.NoError(t, err, "The delete didn't happen")
+
+	//One veto stops the whole write
+	err = todo.Update(func(tx *db.Tx) error {
+		if _, err := tx.AddItem(db.ToDoItem{Title: "new"}); err != nil {
+			return err
+		}
+		return tx.DeleteItem(id)
+	})
+	assert.ErrorIs(t, err, db.ErrVetoed)
+	assert.Equal(t, map[int]string{id: "keep me"}, titles(t, todo))
+}
+
+func TestPreHookChangesItem(t *testing.T) {
+	todo, dir := newHooksDb(t)
+	writeHook(t, dir, "pre-add", `sed 's/"title":"/"title":"OPS: /'`)
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "rotate certificates"})
+	require.NoError(t, err)
+	item, err := todo.GetItem(id)
+	require.NoError(t, err)
+	assert.Equal(t, "OPS: rotate certificates", item.Title)
+
+	writeHook(t, dir, "pre-add", `echo '{"id": 99, "title": "stolen"}'`)
+	_, err = todo.AddItem(db.ToDoItem{Title: "renumbered"})
+	assert.ErrorContains(t, err, "changed the id")
+	assert.Equal(t, map[int]string{id: "OPS: rotate certificates"}, titles(t, todo))
+}
+
+func TestPreHookBlocked(t *testing.T) {
+	todo, dir := newHooksDb(t)
+	blocker, err := todo.AddItem(db.ToDoItem{Title: "order parts"})
+	require.NoError(t, err)
+	id, err := todo.AddItem(db.ToDoItem{Title: "fit parts", BlockedBy: []int{blocker}})
+	require.NoError(t, err)
+
+	//A hook marking the item done is held to its blockers like the
+	//write itself
+	writeHook(t, dir, "pre-update", `echo '{"done": true}'`)
+	err = todo.UpdateItem(db.ToDoItem{Id: id, Title: "fit the parts", BlockedBy: []int{blocker}})
+	assert.ErrorIs(t, err, db.ErrBlocked)
+	item, err := todo.GetItem(id)
+	require.NoError(t, err)
+	assert.Equal(t, "fit parts", item.Title)
+	assert.False(t, item.IsDone)
+
+	require.NoError(t, todo.ChangeItemDoneStatus(blocker, true))
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: id, Title: "fit the parts", BlockedBy: []int{blocker}}))
+	item, err = todo.GetItem(id)
+	require.NoError(t, err)
+	assert.True(t, item.IsDone, "Once the blocker is done the hook can finish the item")
+}
+
+func TestPreHookPartialItem(t *testing.T) {
+	todo, dir := newHooksDb(t)
+	id, err := todo.AddItem(db.ToDoItem{Title: "ship it", Priority: 3, Tags: []string{"release"}})
+	require.NoError(t, err)
+	_, err = todo.StartTimer(id)
+	require.NoError(t, err)
+	before, err := todo.GetItem(id)
+	require.NoError(t, err)
+
+	//A hook that prints only the fields it changes leaves the rest alone
+	writeHook(t, dir, "pre-complete", `echo '{"title": "RENAMED"}'`)
+	require.NoError(t, todo.ChangeItemDoneStatus(id, true))
+	item, err := todo.GetItem(id)
+	require.NoError(t, err)
+	assert.Equal(t, "RENAMED", item.Title)
+	assert.True(t, item.IsDone)
+	assert.Equal(t, 3, item.Priority)
+	assert.Equal(t, []string{"release"}, item.Tags)
+	assert.Equal(t, before.CreatedAt, item.CreatedAt)
+	assert.NotNil(t, item.UpdatedAt)
+	assert.NotNil(t, item.CompletedAt)
+	require.Len(t, item.Work, 1)
+	assert.NotNil(t, item.Work[0].End, "Marking it done stopped the timer")
+}
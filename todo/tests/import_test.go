@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadItemsRoundTrip(t *testing.T) {
+	created := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	items := formatItems()
+	items[0].CreatedAt = &created
+	items[0].UpdatedAt = &created
+
+	for _, format := range []db.Format{db.FormatCSV, db.FormatJSONL, db.FormatJSON} {
+		read, err := db.ReadItems(strings.NewReader(writeItems(t, format, items)), format)
+		if assert.NoError(t, err, "Reading %s", format) {
+			assert.Equal(t, items, read, "%s should round trip every field", format)
+		}
+	}
+
+	_, err := db.ReadItems(strings.NewReader(""), db.FormatTable)
+	assert.Error(t, err, "Tables are not meant to be read back")
+}
+
+func TestReadCSVColumns(t *testing.T) {
+	input := "Title,Extra,Done,Due\nfirst,ignored,true,2031-03-04\nsecond,,,\n"
+	items, err := db.ReadItems(strings.NewReader(input), db.FormatCSV)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+
+	due := time.Date(2031, time.March, 4, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, db.ToDoItem{Title: "first", IsDone: true, DueDate: &due}, items[0])
+	assert.Equal(t, db.ToDoItem{Title: "second"}, items[1])
+
+	_, err = db.ReadItems(strings.NewReader("id,done\n1,true\n"), db.FormatCSV)
+	assert.Error(t, err, "A CSV file without titles should be rejected")
+
+	_, err = db.ReadItems(strings.NewReader("title,done\nx,maybe\n"), db.FormatCSV)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestReadMarkdown(t *testing.T) {
+	input := "# Release\n\nSome text\n- [ ] Write docs\n  - [x] Fix bug\n* [X] Tag it\n- not a task\n- [ ]\n"
+	items, err := db.ReadItems(strings.NewReader(input), db.FormatMarkdown)
+	require.NoError(t, err)
+	assert.Equal(t, []db.ToDoItem{
+		{Title: "Write docs"},
+		{Title: "Fix bug", IsDone: true},
+		{Title: "Tag it", IsDone: true},
+	}, items)
+}
+
+func TestReadTodoTxt(t *testing.T) {
+	input := "(A) 2024-01-01 Call mom +family @phone due:2024-02-01 id:7\n" +
+		"\n" +
+		"x 2024-01-05 2024-01-02 Pay bills https://bank.example pri:Y assignee:sam\n" +
+		"Plain task\n"
+	items, err := db.ReadItems(strings.NewReader(input), db.FormatTodoTxt)
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+
+	date := func(month time.Month, day int) *time.Time {
+		d := time.Date(2024, month, day, 0, 0, 0, 0, time.UTC)
+		return &d
+	}
+	assert.Equal(t, db.ToDoItem{Id: 7, Title: "Call mom", Priority: 26, Tags: []string{"family", "@phone"},
+		DueDate: date(time.February, 1), CreatedAt: date(time.January, 1)}, items[0])
+	assert.Equal(t, db.ToDoItem{Title: "Pay bills https://bank.example", IsDone: true, Priority: 2,
+		Assignee: "sam", CompletedAt: date(time.January, 5), CreatedAt: date(time.January, 2)}, items[1])
+	assert.Equal(t, db.ToDoItem{Title: "Plain task"}, items[2])
+
+	_, err = db.ReadItems(strings.NewReader("ok\nbad due:soon\n"), db.FormatTodoTxt)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestTodoTxtRoundTrip(t *testing.T) {
+	//todo.txt only keeps dates, and has nowhere to put notes
+	day := time.Date(2031, time.March, 4, 0, 0, 0, 0, time.UTC)
+	items := []db.ToDoItem{
+		{Id: 1, Title: "open", Priority: 3, Tags: []string{"a", "@home"}, DueDate: &day, Assignee: "sam", CreatedAt: &day},
+		{Id: 2, Title: "done", IsDone: true, Priority: 1, CompletedAt: &day, CreatedAt: &day},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, db.WriteItems(&buf, db.FormatTodoTxt, items))
+	assert.Equal(t, "(X) 2031-03-04 open +a @home due:2031-03-04 assignee:sam id:1\n"+
+		"x 2031-03-04 2031-03-04 done pri:Z id:2\n", buf.String())
+
+	read, err := db.ReadItems(&buf, db.FormatTodoTxt)
+	require.NoError(t, err)
+	assert.Equal(t, items, read)
+}
+
+func newImportDb(t *testing.T) *db.ToDo {
+	todo := db.NewWithStore(db.NewMemStore())
+	t.Cleanup(func() { todo.Close() })
+	for _, title := range []string{"one", "two"} {
+		_, err := todo.AddItem(db.ToDoItem{Title: title})
+		require.NoError(t, err)
+	}
+	return todo
+}
+
+func TestImportCollisions(t *testing.T) {
+	imported := []db.ToDoItem{
+		{Id: 2, Title: "clashes"},
+		{Title: "no id"},
+		{Id: 10, Title: "free id"},
+	}
+
+	cases := []struct {
+		rule    db.Collision
+		actions []string
+		ids     []int
+		two     string
+		count   int
+	}{
+		{db.CollisionSkip, []string{"skip", "add", "add"}, []int{2, 3, 10}, "two", 4},
+		{db.CollisionOverwrite, []string{"overwrite", "add", "add"}, []int{2, 3, 10}, "clashes", 4},
+		{db.CollisionRenumber, []string{"renumber", "add", "add"}, []int{3, 4, 10}, "two", 5},
+	}
+	for _, c := range cases {
+		t.Run(string(c.rule), func(t *testing.T) {
+			todo := newImportDb(t)
+			actions, err := todo.ImportItems(imported, c.rule, false)
+			require.NoError(t, err)
+			require.Len(t, actions, len(imported))
+
+			for i, action := range actions {
+				assert.Equal(t, c.actions[i], action.Action, "Action for item %d", i)
+				assert.Equal(t, imported[i].Id, action.FromId)
+				assert.Equal(t, c.ids[i], action.Item.Id, "Id of item %d", i)
+				assert.Equal(t, imported[i].Title, action.Item.Title)
+			}
+
+			item, err := todo.GetItem(2)
+			require.NoError(t, err)
+			assert.Equal(t, c.two, item.Title)
+
+			items, err := todo.GetAllItems()
+			require.NoError(t, err)
+			assert.Len(t, items, c.count)
+			for _, item := range items {
+				assert.NotNil(t, item.CreatedAt, "Imported items should be stamped")
+			}
+
+			id, err := todo.AddItem(db.ToDoItem{Title: "after"})
+			require.NoError(t, err)
+			assert.Equal(t, 11, id, "Imported ids should move the high-water mark")
+		})
+	}
+}
+
+func TestImportCollidesWithItself(t *testing.T) {
+	todo := newImportDb(t)
+	actions, err := todo.ImportItems([]db.ToDoItem{{Id: 5, Title: "a"}, {Id: 5, Title: "b"}}, db.CollisionRenumber, false)
+	require.NoError(t, err)
+	assert.Equal(t, 5, actions[0].Item.Id)
+	assert.Equal(t, "renumber", actions[1].Action)
+	assert.Equal(t, 6, actions[1].Item.Id)
+}
+
+func TestImportPreview(t *testing.T) {
+	todo := newImportDb(t)
+	before, err := todo.GetAllItems()
+	require.NoError(t, err)
+
+	actions, err := todo.ImportItems([]db.ToDoItem{{Title: "new"}, {Id: 1, Title: "clash"}}, db.CollisionOverwrite, true)
+	require.NoError(t, err)
+	assert.Equal(t, "add", actions[0].Action)
+	assert.Equal(t, 3, actions[0].Item.Id)
+	assert.Equal(t, "overwrite", actions[1].Action)
+
+	after, err := todo.GetAllItems()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, before, after, "A preview must not change the DB")
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "after"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, id, "A preview must not use up ids")
+
+	_, err = todo.ImportItems(nil, "clobber", false)
+	assert.Error(t, err, "Unknown collision rules should be rejected")
+}
@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newJournalDb(t *testing.T) *db.ToDo {
+	todo, err := db.New(newTempDbFile(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { todo.Close() })
+	return todo
+}
+
+func titles(t *testing.T, todo *db.ToDo) map[int]string {
+	items, err := todo.GetAllItems()
+	require.NoError(t, err)
+	byId := make(map[int]string)
+	for _, item := range items {
+		byId[item.Id] = item.Title
+	}
+	return byId
+}
+
+func TestJournalRecordsEveryWrite(t *testing.T) {
+	todo := newJournalDb(t)
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "first"})
+	require.NoError(t, err)
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: id, Title: "renamed"}))
+	require.NoError(t, todo.ChangeItemDoneStatus(id, true))
+	_, err = todo.ImportItems([]db.ToDoItem{{Title: "a"}, {Title: "b"}}, db.CollisionSkip, false)
+	require.NoError(t, err)
+	require.NoError(t, todo.DeleteItem(id))
+
+	entries, err := todo.Journal()
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+
+	var ops []string
+	for i, entry := range entries {
+		ops = append(ops, entry.Op)
+		assert.Equal(t, i+1, entry.Seq)
+		assert.False(t, entry.Time.IsZero())
+	}
+	assert.Equal(t, []string{"add", "update", "done", "import", "delete"}, ops)
+
+	add := entries[0].Changes
+	require.Len(t, add, 1)
+	assert.Nil(t, add[0].Before, "An add has no before image")
+	assert.Equal(t, "first", add[0].After.Title)
+
+	update := entries[1].Changes[0]
+	assert.Equal(t, "first", update.Before.Title)
+	assert.Equal(t, "renamed", update.After.Title)
+
+	assert.Len(t, entries[3].Changes, 2, "One entry covers the whole import")
+
+	del := entries[4].Changes[0]
+	assert.True(t, del.Before.IsDone)
+	assert.Nil(t, del.After, "A delete has no after image")
+}
+
+func TestUndoRedo(t *testing.T) {
+	todo := newJournalDb(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "two"})
+	require.NoError(t, err)
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 2, Title: "TWO"}))
+	require.NoError(t, todo.DeleteItem(1))
+	assert.Equal(t, map[int]string{2: "TWO"}, titles(t, todo))
+
+	entry, err := todo.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, "delete", entry.Op, "Undo returns the entry it reversed")
+	assert.Equal(t, map[int]string{1: "one", 2: "TWO"}, titles(t, todo))
+
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "one", 2: "two"}, titles(t, todo))
+
+	_, err = todo.Redo()
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "one", 2: "TWO"}, titles(t, todo))
+
+	for i := 0; i < 3; i++ {
+		_, err = todo.Undo()
+		require.NoError(t, err)
+	}
+	assert.Empty(t, titles(t, todo))
+	_, err = todo.Undo()
+	assert.ErrorContains(t, err, "nothing to undo")
+
+	for i := 0; i < 4; i++ {
+		_, err = todo.Redo()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, map[int]string{2: "TWO"}, titles(t, todo))
+	_, err = todo.Redo()
+	assert.ErrorContains(t, err, "nothing to redo")
+
+	entries, err := todo.Journal()
+	require.NoError(t, err)
+	last := entries[len(entries)-1]
+	assert.Equal(t, "redo", last.Op)
+	assert.Equal(t, 4, last.Redoes, "The last redo replays the delete")
+}
+
+func TestNewWriteClearsRedo(t *testing.T) {
+	todo := newJournalDb(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	_, err = todo.Undo()
+	require.NoError(t, err)
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "two"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, id, "Undoing an add must not free its id")
+
+	_, err = todo.Redo()
+	assert.ErrorContains(t, err, "nothing to redo")
+	assert.Equal(t, map[int]string{2: "two"}, titles(t, todo))
+}
+
+func TestUndoRefusesChangedItems(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	defer todo.Close()
+
+	_, err = todo.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 1, Title: "edited"}))
+
+	//Change the item behind the journal's back, straight through the
+	//store, so the update's after image no longer matches it
+	store, err := db.NewJsonStore(dbFile)
+	require.NoError(t, err)
+	contents, err := store.Load()
+	require.NoError(t, err)
+	contents.Items[1] = db.ToDoItem{Id: 1, Title: "written straight to the store"}
+	require.NoError(t, store.Save(contents))
+
+	_, err = todo.Undo()
+	assert.ErrorContains(t, err, "item 1 has changed")
+
+	item, err := todo.GetItem(1)
+	require.NoError(t, err)
+	assert.Equal(t, "written straight to the store", item.Title, "A refused undo must change nothing")
+	entries, err := todo.Journal()
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "A refused undo must not be journaled")
+}
+
+func TestBadJournalLine(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	t.Cleanup(func() { todo.Close() })
+	_, err = todo.AddItem(db.ToDoItem{Title: "before"})
+	require.NoError(t, err)
+
+	//A line cut short, with no newline after it
+	f, err := os.OpenFile(dbFile+".journal", os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq": 2, "op": "add", "chan`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	//Writes still work, and say so
+	for _, title := range []string{"one", "two"} {
+		id, err := todo.AddItem(db.ToDoItem{Title: title})
+		require.NoError(t, err)
+		assert.NotZero(t, id)
+	}
+	entries, err := todo.Journal()
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "The bad line is skipped")
+	for i, entry := range entries {
+		assert.Equal(t, i+1, entry.Seq, "Entries are numbered on from the last good one")
+	}
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "before", 2: "one"}, titles(t, todo))
+
+	report, err := todo.Repair(false)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, "journal line 2", report.Problems[0].Location)
+	assert.Equal(t, db.FixQuarantine, report.Problems[0].Fix)
+	assert.True(t, strings.Contains(quarantineLines(t, dbFile)[0]["text"].(string), `"chan`))
+
+	report, err = todo.Repair(true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	entries, err = todo.Journal()
+	require.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+func TestJournalNeedsAFile(t *testing.T) {
+	todo := db.NewWithStore(db.NewMemStore())
+	defer todo.Close()
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "not journaled"})
+	require.NoError(t, err, "Writes still work without a journal")
+
+	_, err = todo.Undo()
+	assert.Error(t, err)
+	_, err = todo.Journal()
+	assert.Error(t, err)
+}
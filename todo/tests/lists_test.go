@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newListsDb returns a DB holding
+//
+//	1 call mom, default list
+//	2 write report, work, done
+//	3   proofread report, work, subtask of 2
+//	4 fix sink, home
+func newListsDb(t *testing.T) *db.ToDo {
+	return newDbWith(t,
+		db.ToDoItem{Title: "call mom"},
+		db.ToDoItem{Title: "write report", List: "work", IsDone: true},
+		db.ToDoItem{Title: "proofread report", List: "work", ParentId: 2},
+		db.ToDoItem{Title: "fix sink", List: "home"},
+	)
+}
+
+func TestParseList(t *testing.T) {
+	for _, name := range []string{"", "default", " default "} {
+		list, err := db.ParseList(name)
+		require.NoError(t, err)
+		assert.Equal(t, "", list, "%q is the default list", name)
+	}
+	list, err := db.ParseList("team/q3-launch")
+	require.NoError(t, err)
+	assert.Equal(t, "team/q3-launch", list)
+
+	for _, name := range []string{"two words", "a,b", "semi;colon"} {
+		_, err := db.ParseList(name)
+		assert.Error(t, err, name)
+	}
+
+	lists, err := db.ParseLists("work, default,work")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"work", ""}, lists)
+}
+
+func TestLists(t *testing.T) {
+	todo := newListsDb(t)
+
+	lists, err := todo.Lists()
+	require.NoError(t, err)
+	assert.Equal(t, []db.ListCount{
+		{Name: db.DefaultList, Open: 1},
+		{Name: "home", Open: 1},
+		{Name: "work", Open: 1, Done: 1},
+	}, lists)
+
+	items, err := todo.QueryItems(db.Query{Lists: []string{"work"}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, ids(items))
+	items, err = todo.QueryItems(db.Query{Lists: []string{"", "home"}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 4}, ids(items))
+	items, err = todo.QueryItems(db.Query{})
+	require.NoError(t, err)
+	assert.Len(t, items, 4, "No lists means all of them")
+}
+
+func TestMoveItems(t *testing.T) {
+	todo := newListsDb(t)
+	writes := journalLen(t, todo)
+
+	moved, err := todo.MoveItems("home", []int{2, 1}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3, 1}, moved)
+	assert.Equal(t, writes+1, journalLen(t, todo), "One write for all of them")
+
+	items, err := todo.QueryItems(db.Query{Lists: []string{"home"}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4}, ids(items))
+
+	moved, err = todo.MoveItems("home", []int{4}, false)
+	require.NoError(t, err)
+	assert.Empty(t, moved, "Items already in the list stay put")
+
+	_, err = todo.MoveItems("", []int{1, 99}, false)
+	assert.Error(t, err)
+	item, err := todo.GetItem(1)
+	require.NoError(t, err)
+	assert.Equal(t, "home", item.List, "Nothing moves if any of them can't")
+
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	lists, err := todo.Lists()
+	require.NoError(t, err)
+	assert.Len(t, lists, 3)
+}
+
+func TestListsRoundTrip(t *testing.T) {
+	items := []db.ToDoItem{{Id: 1, Title: "write report", List: "work"}}
+
+	for _, format := range []db.Format{db.FormatCSV, db.FormatTodoTxt, db.FormatJSON} {
+		read, err := db.ReadItems(strings.NewReader(writeItems(t, format, items)), format)
+		require.NoError(t, err, format)
+		require.Len(t, read, 1, format)
+		assert.Equal(t, "work", read[0].List, format)
+	}
+
+	todo := newListsDb(t)
+	_, err := todo.ImportItems([]db.ToDoItem{{Title: "bad", List: "no spaces"}}, db.CollisionSkip, false)
+	assert.Error(t, err, "List names are checked on the way in")
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newQueryDb returns an in-memory DB holding a handful of items with
+// enough variety to filter and sort on
+func newQueryDb(t *testing.T) *db.ToDo {
+	todo := db.NewWithStore(db.NewMemStore())
+
+	soon := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	later := soon.AddDate(0, 6, 0)
+	items := []db.ToDoItem{
+		{Id: 1, Title: "Learn Go", Priority: 2, DueDate: &later},
+		{Id: 2, Title: "learn Kubernetes", Priority: 5, IsDone: true},
+		{Id: 3, Title: "Write report", Priority: 5, DueDate: &soon},
+		{Id: 4, Title: "Buy milk"},
+		{Id: 5, Title: "Call the plumber", Priority: 1, IsDone: true},
+	}
+	for _, item := range items {
+		_, err := todo.AddItem(item)
+		require.NoError(t, err)
+	}
+	return todo
+}
+
+func ids(items []db.ToDoItem) []int {
+	ids := []int{}
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func TestQueryDefaultsToIdOrder(t *testing.T) {
+	items, err := newQueryDb(t).QueryItems(db.Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids(items))
+}
+
+func TestQueryFilters(t *testing.T) {
+	todo := newQueryDb(t)
+	done, open := true, false
+
+	cases := map[string]struct {
+		q    db.Query
+		want []int
+	}{
+		"done":           {db.Query{Done: &done}, []int{2, 5}},
+		"open":           {db.Query{Done: &open}, []int{1, 3, 4}},
+		"title contains": {db.Query{TitleContains: "LEARN"}, []int{1, 2}},
+		"title regexp":   {db.Query{TitleRegexp: regexp.MustCompile(`^[A-Z]\w+ \w+$`)}, []int{1, 3, 4}},
+		"combined":       {db.Query{Done: &open, TitleContains: "learn"}, []int{1}},
+	}
+	for name, c := range cases {
+		items, err := todo.QueryItems(c.q)
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.want, ids(items), name)
+	}
+}
+
+func TestQuerySort(t *testing.T) {
+	todo := newQueryDb(t)
+
+	cases := map[string][]int{
+		"title":          {4, 5, 1, 2, 3},
+		"-id":            {5, 4, 3, 2, 1},
+		"-priority":      {2, 3, 1, 5, 4},
+		"-priority,-due": {2, 3, 1, 5, 4},
+		"-priority,due":  {3, 2, 1, 5, 4},
+		"due":            {3, 1, 2, 4, 5},
+		"done,title":     {4, 1, 3, 5, 2},
+	}
+	for spec, want := range cases {
+		keys, err := db.ParseSort(spec)
+		if assert.NoError(t, err, spec) {
+			items, err := todo.QueryItems(db.Query{Sort: keys})
+			assert.NoError(t, err, spec)
+			assert.Equal(t, want, ids(items), spec)
+		}
+	}
+
+	_, err := db.ParseSort("title,nosuchfield")
+	assert.Error(t, err, "Unknown sort fields should be rejected")
+}
+
+func TestQueryPaging(t *testing.T) {
+	todo := newQueryDb(t)
+
+	items, err := todo.QueryItems(db.Query{Offset: 1, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, ids(items))
+
+	items, err = todo.QueryItems(db.Query{Offset: 4, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5}, ids(items))
+
+	items, err = todo.QueryItems(db.Query{Offset: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseRecurrence(t *testing.T) {
+	valid := map[string]db.Recurrence{
+		"":                     "",
+		"daily":                "daily",
+		" Weekly ":             "weekly",
+		"weekly:thu,MON":       "weekly:mon,thu",
+		"weekly:sunday,monday": "weekly:mon,sun",
+		"monthly":              "monthly",
+		"monthly:31":           "monthly:31",
+		"every:3":              "every:3d",
+		"every:2w":             "every:2w",
+	}
+	for rule, want := range valid {
+		got, err := db.ParseRecurrence(rule)
+		assert.NoError(t, err, rule)
+		assert.Equal(t, want, got, rule)
+	}
+
+	for _, rule := range []string{"sometimes", "daily:2", "every:0d", "every:xd", "every:", "weekly:someday",
+		"weekly:", "monthly:0", "monthly:32", "yearly"} {
+		_, err := db.ParseRecurrence(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	thursday := date(2031, time.January, 2)
+	cases := []struct {
+		rule db.Recurrence
+		from time.Time
+		want time.Time
+	}{
+		{"daily", thursday, date(2031, time.January, 3)},
+		{"every:3d", thursday, date(2031, time.January, 5)},
+		{"every:2w", thursday, date(2031, time.January, 16)},
+		{"weekly", thursday, date(2031, time.January, 9)},
+		{"weekly:mon,thu", thursday, date(2031, time.January, 6)},
+		{"weekly:thu", thursday, date(2031, time.January, 9)},
+		{"monthly", thursday, date(2031, time.February, 2)},
+		{"monthly:15", thursday, date(2031, time.January, 15)},
+		{"monthly:2", thursday, date(2031, time.February, 2)},
+		{"monthly:31", date(2031, time.January, 31), date(2031, time.February, 28)},
+		{"monthly:31", date(2031, time.February, 28), date(2031, time.March, 31)},
+		{"monthly:1", date(2031, time.December, 5), date(2032, time.January, 1)},
+	}
+	for _, c := range cases {
+		got, err := c.rule.Next(c.from)
+		assert.NoError(t, err, c.rule)
+		assert.Equal(t, c.want, got, "%s after %s", c.rule, c.from.Format("2006-01-02"))
+	}
+
+	_, err := db.Recurrence("every").Next(thursday)
+	assert.Error(t, err, "Rules that were never checked can't panic")
+}
+
+func TestDoneRepeatingItem(t *testing.T) {
+	todo := newJournalDb(t)
+
+	due := date(2031, time.January, 31)
+	_, err := todo.AddItem(db.ToDoItem{Title: "pay rent", DueDate: &due, Repeat: "monthly",
+		Tags: []string{"home"}, Priority: 2})
+	require.NoError(t, err)
+
+	require.NoError(t, todo.ChangeItemDoneStatus(1, true))
+	done, err := todo.GetItem(1)
+	require.NoError(t, err)
+	assert.True(t, done.IsDone)
+	assert.Empty(t, done.Repeat, "The rule moves on to the next occurrence")
+
+	next, err := todo.GetItem(2)
+	require.NoError(t, err)
+	assert.Equal(t, "pay rent", next.Title)
+	assert.Equal(t, []string{"home"}, next.Tags)
+	assert.Equal(t, 2, next.Priority)
+	assert.False(t, next.IsDone)
+	assert.Nil(t, next.CompletedAt)
+	assert.Equal(t, date(2031, time.February, 28), *next.DueDate)
+	assert.Equal(t, db.Recurrence("monthly:31"), next.Repeat, "The day is pinned so short months don't shift it")
+
+	//Marking the finished one done again doesn't add another
+	require.NoError(t, todo.ChangeItemDoneStatus(1, false))
+	require.NoError(t, todo.ChangeItemDoneStatus(1, true))
+	assert.Len(t, titles(t, todo), 2)
+
+	require.NoError(t, todo.ChangeItemDoneStatus(2, true))
+	third, err := todo.GetItem(3)
+	require.NoError(t, err)
+	assert.Equal(t, date(2031, time.March, 31), *third.DueDate)
+
+	//Both halves are one write, so one undo takes back both
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	assert.Len(t, titles(t, todo), 2)
+	second, err := todo.GetItem(2)
+	require.NoError(t, err)
+	assert.False(t, second.IsDone)
+}
+
+func TestDoneOverdueRepeatingItem(t *testing.T) {
+	todo := db.NewWithStore(db.NewMemStore())
+	defer todo.Close()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	overdue := today.AddDate(0, 0, -10)
+	_, err := todo.AddItem(db.ToDoItem{Title: "water plants", DueDate: &overdue, Repeat: "every:3d"})
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "stretch", Repeat: "daily"})
+	require.NoError(t, err)
+
+	require.NoError(t, todo.ChangeItemDoneStatus(1, true))
+	next, err := todo.GetItem(3)
+	require.NoError(t, err)
+	assert.False(t, next.DueDate.Before(today), "Dates that have gone by are skipped")
+	assert.True(t, next.DueDate.Before(today.AddDate(0, 0, 3)))
+	assert.Equal(t, 0, int(next.DueDate.Sub(overdue).Hours())%72, "Skipping keeps to the rule")
+
+	require.NoError(t, todo.ChangeItemDoneStatus(2, true))
+	next, err = todo.GetItem(4)
+	require.NoError(t, err)
+	assert.NotNil(t, next.DueDate, "Items without a due date repeat from when they are done")
+}
+
+func TestDoneBadRepeatRule(t *testing.T) {
+	todo := db.NewWithStore(db.NewMemStore())
+	defer todo.Close()
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "odd", Repeat: "sometimes"})
+	require.NoError(t, err, "Rules are checked at the edges, like titles")
+
+	assert.Error(t, todo.ChangeItemDoneStatus(1, true))
+	item, err := todo.GetItem(1)
+	require.NoError(t, err)
+	assert.False(t, item.IsDone, "A failed write changes nothing")
+}
+
+func TestUpcomingOccurrences(t *testing.T) {
+	todo := db.NewWithStore(db.NewMemStore())
+	defer todo.Close()
+
+	due := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	_, err := todo.AddItem(db.ToDoItem{Title: "every other day", DueDate: &due, Repeat: "every:2d"})
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "done already", DueDate: &due, Repeat: "daily", IsDone: true})
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "one off", DueDate: &due})
+	require.NoError(t, err)
+
+	sort, err := db.ParseSort("due,id")
+	require.NoError(t, err)
+	open := false
+	items, err := todo.QueryItems(db.Query{Done: &open, Sort: sort, Upcoming: due.AddDate(0, 0, 6)})
+	require.NoError(t, err)
+
+	var dues []time.Time
+	for _, item := range items {
+		if item.Id == 1 {
+			dues = append(dues, *item.DueDate)
+		}
+	}
+	assert.Equal(t, []time.Time{due, due.AddDate(0, 0, 2), due.AddDate(0, 0, 4), due.AddDate(0, 0, 6)}, dues,
+		"The item itself, then its occurrences, in due order")
+	assert.Len(t, items, 5, "Done items don't repeat")
+
+	stored, err := todo.GetAllItems()
+	require.NoError(t, err)
+	assert.Len(t, stored, 3, "Occurrences are only shown, not added")
+}
+
+func TestRepeatRoundTrips(t *testing.T) {
+	due := date(2031, time.March, 4)
+	items := []db.ToDoItem{{Id: 1, Title: "chore", DueDate: &due, Repeat: "weekly:mon,thu"}}
+
+	for _, format := range []db.Format{db.FormatCSV, db.FormatTodoTxt, db.FormatJSON} {
+		read, err := db.ReadItems(strings.NewReader(writeItems(t, format, items)), format)
+		require.NoError(t, err, format)
+		require.Len(t, read, 1, format)
+		assert.Equal(t, items[0].Repeat, read[0].Repeat, format)
+	}
+
+	todo := db.NewWithStore(db.NewMemStore())
+	defer todo.Close()
+	_, err := todo.ImportItems([]db.ToDoItem{{Title: "bad", Repeat: "sometimes"}}, db.CollisionSkip, false)
+	assert.Error(t, err, "Imports are an edge, so their rules are checked")
+}
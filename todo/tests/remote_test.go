@@ -0,0 +1,260 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRemoteServer starts an in-process todo server over a fresh file
+// DB.  It returns the server and the server's own ToDo.
+func newRemoteServer(t *testing.T) (*httptest.Server, *db.ToDo) {
+	todo := newJournalDb(t)
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	api.New(todo).AddRoutes(app)
+
+	server := httptest.NewServer(adaptor.FiberApp(app))
+	t.Cleanup(server.Close)
+	return server, todo
+}
+
+// openRemote opens a ToDo on the server at dsn, as todo -db would
+func openRemote(t *testing.T, dsn string) *db.ToDo {
+	todo, err := db.Open(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { todo.Close() })
+	return todo
+}
+
+func TestRemoteStore(t *testing.T) {
+	server, serverDb := newRemoteServer(t)
+	remote := openRemote(t, server.URL)
+	assert.True(t, remote.Remote())
+	assert.False(t, serverDb.Remote())
+
+	items, err := remote.GetAllItems()
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	id, err := remote.AddItem(db.ToDoItem{Title: "shared", Priority: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+	require.NoError(t, remote.ChangeItemDoneStatus(id, true))
+
+	//The server sees what the client wrote...
+	item, err := serverDb.GetItem(id)
+	require.NoError(t, err)
+	assert.Equal(t, "shared", item.Title)
+	assert.True(t, item.IsDone)
+
+	//...and the other way around
+	_, err = serverDb.AddItem(db.ToDoItem{Title: "from the server"})
+	require.NoError(t, err)
+	require.NoError(t, remote.UpdateItem(db.ToDoItem{Id: 2, Title: "edited remotely"}))
+	assert.Equal(t, map[int]string{1: "shared", 2: "edited remotely"}, titles(t, serverDb))
+
+	require.NoError(t, remote.DeleteItem(1))
+	assert.Equal(t, map[int]string{2: "edited remotely"}, titles(t, serverDb))
+	id, err = remote.AddItem(db.ToDoItem{Title: "new"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, id, "Ids of deleted items aren't handed out again")
+
+	//Remote writes are journaled on the server, so they can be undone there
+	entries, err := serverDb.Journal()
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	last := entries[len(entries)-1]
+	assert.Equal(t, "add", last.Op, "The server journals a write under the client's op")
+	require.Len(t, last.Changes, 1)
+	assert.Equal(t, 3, last.Changes[0].Id)
+}
+
+func TestReplaceChecksItems(t *testing.T) {
+	todo := newDbWith(t, db.ToDoItem{Title: "first"}, db.ToDoItem{Title: "blocker"})
+	_, version, err := todo.Dump()
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		items string
+		err   error
+	}{
+		{`{"id": 1, "title": "first"}, {"id": 1, "title": "again"}`, db.ErrExists},
+		{`{"id": 0, "title": "no id"}`, db.ErrBadId},
+		{`{"id": 1, "title": "first", "parent": 77}`, db.ErrBadParent},
+		{`{"id": 1, "title": "first", "parent": 2}, {"id": 2, "title": "blocker", "parent": 1}`, db.ErrBadParent},
+		{`{"id": 1, "title": "first", "blocked_by": [9]}`, db.ErrBadBlocker},
+		{`{"id": 1, "title": "first", "done": true, "blocked_by": [2]}, {"id": 2, "title": "blocker"}`, db.ErrBlocked},
+	} {
+		_, err := todo.Replace([]byte(`{"version": 2, "items": [`+tc.items+`]}`), version, "add")
+		assert.ErrorIs(t, err, tc.err, tc.items)
+	}
+	assert.Equal(t, map[int]string{1: "first", 2: "blocker"}, titles(t, todo), "Nothing was replaced")
+
+	//A bad op from the client is journaled as remote
+	_, err = todo.Replace([]byte(`{"version": 2, "items": [{"id": 1, "title": "renamed"}]}`), version, "rm -rf")
+	require.NoError(t, err)
+	entries, err := todo.Journal()
+	require.NoError(t, err)
+	last := entries[len(entries)-1]
+	assert.Equal(t, "remote", last.Op)
+	require.Len(t, last.Changes, 2)
+	assert.Equal(t, 1, last.Changes[0].Id)
+	assert.Nil(t, last.Changes[1].After, "Item 2 was deleted")
+}
+
+func TestReplaceVersionAfterHooks(t *testing.T) {
+	todo, dir := newHooksDb(t)
+	writeHook(t, dir, "pre-add", `echo '{"priority": 5}'`)
+	_, version, err := todo.Dump()
+	require.NoError(t, err)
+
+	newVersion, err := todo.Replace([]byte(`{"version": 2, "items": [{"id": 1, "title": "hooked"}]}`), version, "add")
+	require.NoError(t, err)
+	item, err := todo.GetItem(1)
+	require.NoError(t, err)
+	assert.Equal(t, 5, item.Priority)
+	_, saved, err := todo.Dump()
+	require.NoError(t, err)
+	assert.Equal(t, saved, newVersion, "The version is of what the pre-hook made of the item")
+}
+
+func TestRemoteStoreConcurrentClients(t *testing.T) {
+	server, serverDb := newRemoteServer(t)
+	serverDb.SetSnapshotPolicy(db.SnapshotPolicy{})
+
+	const clients, writes = 3, 10
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		remote := openRemote(t, server.URL)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				_, err := remote.AddItem(db.ToDoItem{Title: "item"})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	items, err := serverDb.GetAllItems()
+	require.NoError(t, err)
+	assert.Len(t, items, clients*writes, "Conflicting saves are retried, not lost")
+}
+
+func TestRemoteStoreConflict(t *testing.T) {
+	server, _ := newRemoteServer(t)
+
+	first, err := db.NewHttpStore(server.URL, time.Second)
+	require.NoError(t, err)
+	second, err := db.NewHttpStore(server.URL, time.Second)
+	require.NoError(t, err)
+
+	contents, err := first.Load()
+	require.NoError(t, err)
+	_, err = second.Load()
+	require.NoError(t, err)
+
+	contents.Items[1] = db.ToDoItem{Id: 1, Title: "first"}
+	require.NoError(t, first.Save(contents))
+	contents.Items[1] = db.ToDoItem{Id: 1, Title: "second"}
+	assert.ErrorIs(t, second.Save(contents), db.ErrConflict, "second saved over a version it never saw")
+
+	contents, err = second.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "first", contents.Items[1].Title)
+	contents.Items[1] = db.ToDoItem{Id: 1, Title: "second"}
+	assert.NoError(t, second.Save(contents), "After a fresh load the save goes through")
+}
+
+func TestRemoteStoreErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+	remote := openRemote(t, slow.URL+"?timeout=50ms")
+	_, err := remote.GetAllItems()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not answer within 50ms")
+
+	//A done context stops the request, not just the wait for the lock
+	remote = openRemote(t, slow.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = remote.QueryItemsContext(ctx, db.Query{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+	_, err = remote.AddItemContext(ctx, db.ToDoItem{Title: "late"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":500,"error":"disk on fire"}`))
+	}))
+	defer failing.Close()
+	remote = openRemote(t, failing.URL)
+	_, err = remote.GetAllItems()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "500 disk on fire", "The server's own message is passed on")
+
+	notTodo := httptest.NewServer(http.NotFoundHandler())
+	defer notTodo.Close()
+	remote = openRemote(t, notTodo.URL)
+	_, err = remote.AddItem(db.ToDoItem{Title: "lost"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a todo server")
+
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	remote = openRemote(t, gone.URL)
+	_, err = remote.GetItem(1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't reach todo server")
+	assert.False(t, errors.Is(err, db.ErrConflict))
+
+	_, err = db.Open("http://localhost:1080?timeout=soon")
+	assert.Error(t, err)
+	_, err = db.Open("http://")
+	assert.Error(t, err)
+}
+
+func TestStoreEndpointNeedsVersion(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	assert.Equal(t, http.StatusPreconditionRequired, call(t, app, "PUT", db.StorePath, `{"version":2,"items":[]}`, nil))
+
+	req := httptest.NewRequest("PUT", db.StorePath, nil)
+	req.Header.Set("If-Match", `"stale"`)
+	rsp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode, "An empty body is not a database")
+
+	req = httptest.NewRequest("PUT", db.StorePath, strings.NewReader(`{"version":2,"items":[]}`))
+	req.Header.Set("If-Match", `"stale"`)
+	rsp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode)
+
+	rsp, err = app.Test(httptest.NewRequest("GET", db.StorePath, nil), -1)
+	require.NoError(t, err)
+	req = httptest.NewRequest("PUT", db.StorePath, strings.NewReader(`{"version":2,"items":[{"id":-1,"title":"bad"}]}`))
+	req.Header.Set("If-Match", rsp.Header.Get("ETag"))
+	rsp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode, "A database the server can't hold is a bad request")
+}
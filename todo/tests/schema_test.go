@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// copyFixture copies one of the sample files from ../data into a temp
+// directory under the name todo.json and returns the new path
+func copyFixture(t *testing.T, fixture string) string {
+	data, err := os.ReadFile(filepath.Join("../data", fixture))
+	assert.NoError(t, err, "Reading fixture %s", fixture)
+
+	dbFile := filepath.Join(t.TempDir(), "todo.json")
+	assert.NoError(t, os.WriteFile(dbFile, data, 0644), "Writing fixture copy")
+	return dbFile
+}
+
+func readHeader(t *testing.T, dbFile string) map[string]any {
+	data, err := os.ReadFile(dbFile)
+	assert.NoError(t, err, "Reading DB file")
+
+	var header map[string]any
+	assert.NoError(t, json.Unmarshal(data, &header), "DB file should be a JSON object")
+	return header
+}
+
+func TestNewDbIsCurrentVersion(t *testing.T) {
+	dbFile := newTempDbFile(t)
+
+	header := readHeader(t, dbFile)
+	assert.EqualValues(t, db.SchemaVersion, header["version"])
+}
+
+func TestMigrateBareArray(t *testing.T) {
+	dbFile := copyFixture(t, "todo.json.bak")
+
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err, "Opening version 1 DB")
+
+	items, err := todo.GetAllItems()
+	assert.NoError(t, err, "Reading version 1 DB")
+	assert.Len(t, items, 4)
+
+	item, err := todo.GetItem(4)
+	assert.NoError(t, err)
+	assert.Equal(t, "Learn Why Professor Mitchell is the BEST! :-)", item.Title)
+
+	//The first write brings the file up to the current version
+	assert.NoError(t, todo.ChangeItemDoneStatus(1, true))
+	header := readHeader(t, dbFile)
+	assert.EqualValues(t, db.SchemaVersion, header["version"])
+	assert.Len(t, header["items"], 4)
+}
+
+func TestMigrateKeepsLegacyHighWaterMark(t *testing.T) {
+	dbFile := copyFixture(t, "todo.json.bak")
+	assert.NoError(t, os.WriteFile(dbFile+".meta", []byte(`{"last_id":40}`), 0644))
+
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err, "Opening version 1 DB")
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "after migration"})
+	assert.NoError(t, err)
+	assert.Equal(t, 41, id, "Ids up to the old high-water mark must not be reused")
+
+	header := readHeader(t, dbFile)
+	assert.EqualValues(t, 41, header["last_id"])
+	assert.NoFileExists(t, dbFile+".meta", "Side file should be folded into the header")
+}
+
+func TestNewerSchemaIsRejected(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "todo.json")
+	assert.NoError(t, os.WriteFile(dbFile, []byte(`{"version":999,"items":[]}`), 0644))
+
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err)
+
+	_, err = todo.GetAllItems()
+	assert.Error(t, err, "A DB from a newer version must not be read")
+}
+
+func TestRichItemRoundTrip(t *testing.T) {
+	todo, err := db.New(newTempDbFile(t))
+	assert.NoError(t, err)
+
+	due := time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC)
+	item := db.ToDoItem{
+		Title:    "Write the quarterly report",
+		DueDate:  &due,
+		Priority: 3,
+		Tags:     []string{"work", "writing"},
+		Notes:    "Numbers are in the shared drive",
+		Assignee: "pat",
+	}
+
+	id, err := todo.AddItem(item)
+	assert.NoError(t, err)
+
+	got, err := todo.GetItem(id)
+	assert.NoError(t, err)
+	item.Id = id
+	item = withStamps(t, item, got)
+	assert.Equal(t, item, got, "All the optional fields should survive a save and load")
+	assert.Nil(t, got.CompletedAt, "Item is not done yet")
+
+	//An update that leaves out created_at must not lose it
+	created := got.CreatedAt
+	got.CreatedAt = nil
+	got.IsDone = true
+	assert.NoError(t, todo.UpdateItem(got))
+
+	got, err = todo.GetItem(id)
+	assert.NoError(t, err)
+	assert.Equal(t, created, got.CreatedAt)
+	assert.NotNil(t, got.CompletedAt)
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotBeforeEachWrite(t *testing.T) {
+	todo := newJournalDb(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "two"})
+	require.NoError(t, err)
+	assert.Error(t, todo.DeleteItem(99), "A failed write changes nothing")
+
+	snapshots, err := todo.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2, "One snapshot per write that changed something")
+	for _, snapshot := range snapshots {
+		assert.False(t, snapshot.Manual)
+	}
+	assert.True(t, snapshots[0].Time.Before(snapshots[1].Time), "Snapshots are listed oldest first")
+
+	//The newest snapshot is the database as it was before the last write
+	changes, err := todo.DiffSnapshot(snapshots[1])
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, 2, changes[0].Id)
+	assert.Nil(t, changes[0].After, "Restoring it would remove item 2")
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	todo := newJournalDb(t)
+	todo.SetSnapshotPolicy(db.SnapshotPolicy{Keep: 3})
+
+	manual, err := todo.Backup()
+	require.NoError(t, err)
+	assert.True(t, manual.Manual)
+
+	for i := 0; i < 5; i++ {
+		_, err := todo.AddItem(db.ToDoItem{Title: "item"})
+		require.NoError(t, err)
+	}
+
+	snapshots, err := todo.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 4, "Three automatic snapshots and the manual one")
+	assert.Equal(t, manual.Name, snapshots[0].Name, "Manual snapshots are never rotated out")
+
+	todo.SetSnapshotPolicy(db.SnapshotPolicy{Keep: 10, MaxAge: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	_, err = todo.AddItem(db.ToDoItem{Title: "item"})
+	require.NoError(t, err)
+	snapshots, err = todo.Snapshots()
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1, "Snapshots past their age are deleted, even the one just taken")
+
+	todo.SetSnapshotPolicy(db.SnapshotPolicy{})
+	_, err = todo.AddItem(db.ToDoItem{Title: "item"})
+	require.NoError(t, err)
+	snapshots, err = todo.Snapshots()
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1, "Keep 0 turns automatic snapshots off")
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	todo := newJournalDb(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	backup, err := todo.Backup()
+	require.NoError(t, err)
+
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 1, Title: "renamed", IsDone: true}))
+	_, err = todo.AddItem(db.ToDoItem{Title: "two"})
+	require.NoError(t, err)
+
+	changes, err := todo.DiffSnapshot(backup)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Contains(t, changes[0].Fields(), "title")
+	assert.Contains(t, changes[0].Fields(), "done")
+	assert.NotContains(t, changes[0].Fields(), "id")
+	assert.Nil(t, changes[1].After)
+
+	require.NoError(t, todo.RestoreSnapshot(backup))
+	assert.Equal(t, map[int]string{1: "one"}, titles(t, todo))
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "three"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, id, "Restoring must not hand out ids again")
+
+	//A restore is a write like any other, so it can be undone
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "renamed", 2: "two"}, titles(t, todo))
+}
+
+func TestRestoreAutoSnapshot(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dbFile+".bak", data, 0644))
+	_, err = todo.AddItem(db.ToDoItem{Title: "two"})
+	require.NoError(t, err)
+
+	//The snapshot taken before adding item 2 is a copy of the file, and
+	//restores to what it held
+	snapshots, err := todo.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	require.NoError(t, todo.RestoreSnapshot(snapshots[1]))
+	assert.Equal(t, map[int]string{1: "one"}, titles(t, todo))
+
+	//A file that can't be read is not copied into the snapshot taken
+	//before restoring over it, so every snapshot can still be restored
+	require.NoError(t, os.WriteFile(dbFile, []byte(`{"version": 2, "items": [{"id": 1,`), 0644))
+	require.NoError(t, todo.RestoreDB())
+	snapshots, err = todo.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 4)
+	for _, snapshot := range snapshots {
+		_, err := todo.DiffSnapshot(snapshot)
+		assert.NoError(t, err, snapshot.Name)
+	}
+	require.NoError(t, todo.RestoreSnapshot(snapshots[3]))
+	assert.Empty(t, titles(t, todo), "The file couldn't be read, so it held nothing")
+}
+
+func TestSnapshotBefore(t *testing.T) {
+	todo := newJournalDb(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	_, err = todo.AddItem(db.ToDoItem{Title: "two"})
+	require.NoError(t, err)
+
+	snapshots, err := todo.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+
+	found, err := todo.SnapshotBefore(cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, snapshots[0].Name, found.Name)
+
+	found, err = todo.SnapshotBefore(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, snapshots[1].Name, found.Name)
+
+	latest, err := todo.FindSnapshot("latest")
+	require.NoError(t, err)
+	assert.Equal(t, snapshots[1].Name, latest.Name)
+
+	_, err = todo.SnapshotBefore(cutoff.Add(-time.Hour))
+	assert.Error(t, err, "Nothing is that old")
+	_, err = todo.FindSnapshot("no such snapshot")
+	assert.Error(t, err)
+}
+
+func TestBackupFileIsASnapshot(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	defer todo.Close()
+
+	_, err = todo.BackupFile()
+	assert.Error(t, err, "There is no .bak file yet")
+
+	_, err = todo.AddItem(db.ToDoItem{Title: "in the backup"})
+	require.NoError(t, err)
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dbFile+".bak", data, 0644))
+	require.NoError(t, todo.DeleteItem(1))
+
+	backup, err := todo.BackupFile()
+	require.NoError(t, err)
+	changes, err := todo.DiffSnapshot(backup)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "in the backup", changes[0].After.Title)
+
+	require.NoError(t, todo.RestoreDB())
+	assert.Equal(t, map[int]string{1: "in the backup"}, titles(t, todo))
+}
+
+func TestSnapshotsNeedAFile(t *testing.T) {
+	todo := db.NewWithStore(db.NewMemStore())
+	defer todo.Close()
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "no snapshot taken"})
+	require.NoError(t, err, "Writes still work without snapshots")
+
+	_, err = todo.Backup()
+	assert.Error(t, err)
+	_, err = todo.Snapshots()
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeOpener returns a new handle on one particular piece of storage,
+// every call opening the same data.  For stores backed by a file that
+// is a fresh Store value on the same file, which is how separate
+// processes see it.
+type storeOpener func() db.Store
+
+// Every Store implementation has a Test function here that hands a
+// storeOpener for a fresh, empty store to runStoreConformance.  A new
+// backend must pass the whole suite before it is wired into OpenStore.
+
+func TestJsonStoreConformance(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) storeOpener {
+		fileName := filepath.Join(t.TempDir(), "todo.json")
+		return func() db.Store {
+			store, err := db.NewJsonStore(fileName)
+			require.NoError(t, err, "Opening JSON store")
+			return store
+		}
+	})
+}
+
+func TestBoltStoreConformance(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) storeOpener {
+		fileName := filepath.Join(t.TempDir(), "todo.db")
+		return func() db.Store {
+			store, err := db.NewBoltStore(fileName)
+			require.NoError(t, err, "Opening bolt store")
+			return store
+		}
+	})
+}
+
+func TestMemStoreConformance(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) storeOpener {
+		store := db.NewMemStore()
+		return func() db.Store {
+			return store
+		}
+	})
+}
+
+func runStoreConformance(t *testing.T, newStore func(t *testing.T) storeOpener) {
+	t.Run("EmptyStore", func(t *testing.T) {
+		store := newStore(t)()
+		defer store.Close()
+
+		contents, err := store.Load()
+		require.NoError(t, err, "Loading an empty store")
+		assert.Empty(t, contents.Items)
+		assert.Equal(t, 0, contents.LastId)
+	})
+
+	t.Run("SaveAndLoad", func(t *testing.T) {
+		open := newStore(t)
+		store := open()
+		defer store.Close()
+
+		contents := sampleContents()
+		require.NoError(t, store.Save(contents), "Saving")
+
+		loaded, err := store.Load()
+		require.NoError(t, err, "Loading")
+		assert.Equal(t, contents, loaded, "Loaded contents should match what was saved")
+
+		reopened := open()
+		defer reopened.Close()
+		loaded, err = reopened.Load()
+		require.NoError(t, err, "Loading from a second handle")
+		assert.Equal(t, contents, loaded, "Another handle should see the same contents")
+	})
+
+	t.Run("SaveReplaces", func(t *testing.T) {
+		store := newStore(t)()
+		defer store.Close()
+
+		contents := sampleContents()
+		require.NoError(t, store.Save(contents))
+
+		delete(contents.Items, 2)
+		contents.Items[7] = db.ToDoItem{Id: 7, Title: "replacement"}
+		contents.LastId = 7
+		require.NoError(t, store.Save(contents))
+
+		loaded, err := store.Load()
+		require.NoError(t, err)
+		assert.Equal(t, contents, loaded, "Deleted items must not come back")
+	})
+
+	t.Run("NoSharedMemory", func(t *testing.T) {
+		store := newStore(t)()
+		defer store.Close()
+
+		contents := sampleContents()
+		require.NoError(t, store.Save(contents))
+		contents.Items[1] = db.ToDoItem{Id: 1, Title: "changed after save"}
+
+		loaded, err := store.Load()
+		require.NoError(t, err)
+		assert.Equal(t, "first", loaded.Items[1].Title, "Changing the saved map must not change the store")
+	})
+
+	t.Run("LockExcludes", func(t *testing.T) {
+		open := newStore(t)
+		store := open()
+		defer store.Close()
+		require.NoError(t, store.Save(db.Contents{Items: db.DbMap{}}))
+
+		//Each goroutine bumps LastId by one in a locked load-modify-save,
+		//so any overlap between lock holders shows up as a lost bump
+		const workers, bumps = 8, 20
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				store := open()
+				defer store.Close()
+				for i := 0; i < bumps; i++ {
+					unlock, err := store.Lock()
+					if !assert.NoError(t, err, "Locking") {
+						return
+					}
+					contents, err := store.Load()
+					if assert.NoError(t, err, "Loading") {
+						contents.LastId++
+						assert.NoError(t, store.Save(contents), "Saving")
+					}
+					assert.NoError(t, unlock(), "Unlocking")
+				}
+			}()
+		}
+		wg.Wait()
+
+		contents, err := store.Load()
+		require.NoError(t, err)
+		assert.Equal(t, workers*bumps, contents.LastId, "No locked update should be lost")
+	})
+
+	t.Run("ToDoOnStore", func(t *testing.T) {
+		todo := db.NewWithStore(newStore(t)())
+		defer todo.Close()
+
+		id, err := todo.AddItem(db.ToDoItem{Title: "through a ToDo"})
+		require.NoError(t, err)
+		assert.NoError(t, todo.ChangeItemDoneStatus(id, true))
+
+		item, err := todo.GetItem(id)
+		require.NoError(t, err)
+		assert.True(t, item.IsDone)
+
+		assert.NoError(t, todo.DeleteItem(id))
+		items, err := todo.GetAllItems()
+		assert.NoError(t, err)
+		assert.Empty(t, items)
+	})
+}
+
+func sampleContents() db.Contents {
+	due := time.Date(2031, time.March, 4, 5, 6, 7, 0, time.UTC)
+	return db.Contents{
+		LastId: 5,
+		Items: db.DbMap{
+			1:  {Id: 1, Title: "first"},
+			2:  {Id: 2, Title: "second", IsDone: true, CompletedAt: &due},
+			5:  {Id: 5, Title: "rich", DueDate: &due, Priority: 2, Tags: []string{"a", "b"}, Notes: "n", Assignee: "sam"},
+			-3: {Id: -3, Title: "stores keep the ids they are given, even negative ones"},
+		},
+	}
+}
+
+func TestOpenStoreSchemes(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := map[string]any{
+		filepath.Join(dir, "plain.json"):          &db.JsonStore{},
+		"json:" + filepath.Join(dir, "json.json"): &db.JsonStore{},
+		"bolt:" + filepath.Join(dir, "todo.db"):   &db.BoltStore{},
+		"mem:":                                    &db.MemStore{},
+	}
+	for dsn, want := range cases {
+		store, err := db.OpenStore(dsn)
+		if assert.NoError(t, err, "Opening %s", dsn) {
+			assert.IsType(t, want, store, "Store type for %s", dsn)
+			store.Close()
+		}
+	}
+
+	_, err := db.OpenStore("nosuchscheme:whatever")
+	assert.Error(t, err, "Unknown schemes should be rejected")
+}
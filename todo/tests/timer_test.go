@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimers(t *testing.T) {
+	todo, err := db.New(newTempDbFile(t))
+	require.NoError(t, err)
+	first, err := todo.AddItem(db.ToDoItem{Title: "first"})
+	require.NoError(t, err)
+	second, err := todo.AddItem(db.ToDoItem{Title: "second"})
+	require.NoError(t, err)
+
+	_, err = todo.StopTimer(0)
+	assert.ErrorIs(t, err, db.ErrNoTimer)
+
+	stopped, err := todo.StartTimer(first)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stopped)
+	_, err = todo.StartTimer(first)
+	assert.ErrorIs(t, err, db.ErrTimerRunning)
+
+	//Only one timer runs at a time
+	stopped, err = todo.StartTimer(second)
+	require.NoError(t, err)
+	assert.Equal(t, first, stopped)
+	running, found, err := todo.RunningTimer()
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, second, running.Id)
+
+	_, err = todo.StopTimer(first)
+	assert.ErrorIs(t, err, db.ErrNoTimer)
+	stopped, err = todo.StopTimer(0)
+	require.NoError(t, err)
+	assert.Equal(t, second, stopped)
+	_, found, err = todo.RunningTimer()
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	item, err := todo.GetItem(first)
+	require.NoError(t, err)
+	require.Len(t, item.Work, 1)
+	assert.NotNil(t, item.Work[0].End)
+
+	//Marking an item done stops its timer, and a done item can't be timed
+	_, err = todo.StartTimer(first)
+	require.NoError(t, err)
+	require.NoError(t, todo.ChangeItemDoneStatus(first, true))
+	_, found, err = todo.RunningTimer()
+	require.NoError(t, err)
+	assert.False(t, found)
+	_, err = todo.StartTimer(first)
+	assert.ErrorIs(t, err, db.ErrItemDone)
+
+	//Replacing the item without its work keeps it
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: first, Title: "first, renamed", IsDone: true}))
+	item, err = todo.GetItem(first)
+	require.NoError(t, err)
+	assert.Len(t, item.Work, 2)
+}
+
+func TestReport(t *testing.T) {
+	todo, err := db.New(newTempDbFile(t))
+	require.NoError(t, err)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	interval := func(start, end time.Time) db.Interval {
+		return db.Interval{Start: start, End: &end}
+	}
+
+	_, err = todo.AddItem(db.ToDoItem{Title: "spec", Tags: []string{"acme", "billing"}, Work: []db.Interval{
+		interval(at(1, 9, 0), at(1, 10, 30)),
+		interval(at(2, 23, 0), at(3, 1, 0)),
+	}})
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "bug", Work: []db.Interval{
+		interval(at(3, 14, 0), at(3, 14, 45)),
+	}})
+	require.NoError(t, err)
+	_, err = todo.AddItem(db.ToDoItem{Title: "never started"})
+	require.NoError(t, err)
+
+	report, err := todo.Report(db.ByItem, time.Time{}, time.Time{}, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, []db.WorkTotal{
+		{Id: 1, Name: "spec", Time: 3*time.Hour + 30*time.Minute},
+		{Id: 2, Name: "bug", Time: 45 * time.Minute},
+	}, report)
+
+	report, err = todo.Report(db.ByTag, time.Time{}, time.Time{}, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, []db.WorkTotal{
+		{Name: "acme", Time: 3*time.Hour + 30*time.Minute},
+		{Name: "billing", Time: 3*time.Hour + 30*time.Minute},
+		{Name: "", Time: 45 * time.Minute},
+	}, report, "Untagged items come last")
+
+	//Intervals are split at midnight
+	report, err = todo.Report(db.ByDay, time.Time{}, time.Time{}, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, []db.WorkTotal{
+		{Name: "2026-10-01", Time: 90 * time.Minute},
+		{Name: "2026-10-02", Time: time.Hour},
+		{Name: "2026-10-03", Time: time.Hour + 45*time.Minute},
+	}, report)
+
+	//Only the time between from and to counts
+	report, err = todo.Report(db.ByItem, at(2, 0, 0), at(3, 0, 0), time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, []db.WorkTotal{{Id: 1, Name: "spec", Time: time.Hour}}, report)
+
+	_, err = todo.Report("week", time.Time{}, time.Time{}, time.UTC)
+	assert.Error(t, err)
+}
@@ -73,7 +73,7 @@ func TestAddHardCodedItem(t *testing.T) {
 	//I will get you started, uncomment the lines below to add to the DB
 	//and ensure no errors:
 	//---------------------------------------------------------------
-	err := DB.AddItem(item)
+	_, err := DB.AddItem(item)
 	assert.NoError(t, err, "Error adding item to DB")
 
 	//DONE: Now finish the test case by looking up the item in the DB
@@ -81,6 +81,8 @@ func TestAddHardCodedItem(t *testing.T) {
 
 	newItem, err := DB.GetItem(999)
 	assert.NoError(t, err, "Error fetching item from DB")
+	assert.NotNil(t, newItem.CreatedAt, "Creation time should be recorded")
+	item = withStamps(t, item, newItem)
 	assert.Equal(t,item,newItem,"Item fetched from DB must match item inserted")
 }
 
@@ -89,12 +91,17 @@ func TestAddRandomStructItem(t *testing.T) {
 	//Not going to do anyting
 	item := db.ToDoItem{}
 	err := fake.Struct(&item)
+	//Random parents and blockers won't exist, and AddItem checks them,
+	//as it does that ids aren't negative
+	item.ParentId = 0
+	item.BlockedBy = nil
+	item.Id = max(item.Id, -item.Id, 1)
 	t.Log("Testing Adding a Randomly Generated Struct: ", item)
 
 	assert.NoError(t, err, "Created fake item OK")
 
 	//DONE: Complete the test
-	err = DB.AddItem(item)
+	_, err = DB.AddItem(item)
 	assert.NoError(t, err, "Added fake item to DB")
 
 	newItem, err := DB.GetItem(item.Id)
@@ -112,11 +119,12 @@ func TestAddRandomItem(t *testing.T) {
 
 	t.Log("Testing Adding an Item with Random Fields: ", item)
 
-	err := DB.AddItem(item)
+	_, err := DB.AddItem(item)
 	assert.NoError(t, err, "Added fake item to DB")
 
 	newItem, err := DB.GetItem(item.Id)
 	assert.NoError(t, err, "Fetched item from DB")
+	item = withStamps(t, item, newItem)
 	assert.Equal(t,item,newItem,"Item fetched from DB matches item inserted")
 }
 
@@ -133,10 +141,10 @@ func TestAddDuplicateItem(t *testing.T) {
 
 	t.Log("Testing Adding a duplicate item", item)
 
-	err := DB.AddItem(item)
+	_, err := DB.AddItem(item)
 	assert.NoError(t, err, "Added first item to DB")
 
-	err = DB.AddItem(item)
+	_, err = DB.AddItem(item)
 	assert.Error(t, err, "Adding duplicate item should fail")
 }
 
@@ -155,6 +163,8 @@ func TestUpdateItem(t *testing.T) {
 
 	checkItem, err := DB.GetItem(2)
 	assert.NoError(t, err, "After update, item should still be present")
+	assert.NotNil(t, checkItem.CompletedAt, "Marking the item done should record when")
+	item = withStamps(t, item, checkItem)
 	assert.Equal(t, item, checkItem, "All fields should match what we updated")
 }
 
@@ -165,6 +175,17 @@ func TestChangeItemDoneState(t *testing.T) {
 	checkItem, err := DB.GetItem(1)
 	assert.NoError(t, err, "Fetch item 1 from DB")
 	assert.Equal(t, true, checkItem.IsDone, "Item should be done now")
+	assert.NotNil(t, checkItem.CompletedAt, "Completion time should be recorded")
+
+	err = DB.ChangeItemDoneStatus(1,false)
+	assert.NoError(t, err, "Change item 1 back to not done")
+
+	checkItem, err = DB.GetItem(1)
+	assert.NoError(t, err, "Fetch item 1 from DB")
+	assert.Nil(t, checkItem.CompletedAt, "Completion time should be cleared")
+
+	err = DB.ChangeItemDoneStatus(1,true)
+	assert.NoError(t, err, "Change item 1 done state again")
 }
 
 func TestDeleteItem(t *testing.T) {
@@ -180,3 +201,52 @@ func TestGetAllItems(t *testing.T) {
 	assert.NoError(t, err, "Getting all items should not fail")
 	assert.GreaterOrEqual(t, len(items), 4, "At least 4 items should have been returned")
 }
+
+func TestAddItemAssignsId(t *testing.T) {
+	todo, err := db.New(newTempDbFile(t))
+	assert.NoError(t, err, "Opening temp DB")
+
+	_, err = todo.AddItem(db.ToDoItem{Id: 5, Title: "explicit id"})
+	assert.NoError(t, err, "Adding item with an explicit id")
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "no id given"})
+	assert.NoError(t, err, "Adding item without an id")
+	assert.Equal(t, 6, id, "Should get the id after the highest one in use")
+
+	item, err := todo.GetItem(id)
+	assert.NoError(t, err, "Fetching the new item")
+	assert.Equal(t, "no id given", item.Title)
+}
+
+func TestDeletedIdsAreNotReused(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err, "Opening temp DB")
+
+	first, err := todo.AddItem(db.ToDoItem{Title: "first"})
+	assert.NoError(t, err)
+	second, err := todo.AddItem(db.ToDoItem{Title: "second"})
+	assert.NoError(t, err)
+	assert.NoError(t, todo.DeleteItem(second), "Deleting the newest item")
+
+	//Use a fresh ToDo so the high-water mark has to come from disk
+	todo, err = db.New(dbFile)
+	assert.NoError(t, err, "Reopening temp DB")
+	third, err := todo.AddItem(db.ToDoItem{Title: "third"})
+	assert.NoError(t, err)
+	assert.Greater(t, third, second, "Id %d was deleted and must not be reused", second)
+	assert.Greater(t, second, first)
+}
+
+// withStamps checks that the db package stamped got as written, then
+// copies the timestamps it manages onto want so the remaining fields
+// can be compared with assert.Equal.  CreatedAt is not checked here
+// because items migrated from the old format don't have one.
+func withStamps(t *testing.T, want, got db.ToDoItem) db.ToDoItem {
+	assert.NotNil(t, got.UpdatedAt, "UpdatedAt should be set")
+
+	want.CreatedAt = got.CreatedAt
+	want.UpdatedAt = got.UpdatedAt
+	want.CompletedAt = got.CompletedAt
+	return want
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDbWith returns a DB in a temp file holding items, added in order so
+// that the ones without an id get 1, 2 and so on
+func newDbWith(t *testing.T, items ...db.ToDoItem) *db.ToDo {
+	todo := newJournalDb(t)
+	for _, item := range items {
+		_, err := todo.AddItem(item)
+		require.NoError(t, err)
+	}
+	return todo
+}
+
+// newTreeDb returns a DB holding
+//
+//	1 release
+//	  2 write notes
+//	    4 proofread
+//	  3 tag build
+//	5 unrelated
+func newTreeDb(t *testing.T) *db.ToDo {
+	return newDbWith(t,
+		db.ToDoItem{Title: "release"},
+		db.ToDoItem{Title: "write notes", ParentId: 1},
+		db.ToDoItem{Title: "tag build", ParentId: 1},
+		db.ToDoItem{Title: "proofread", ParentId: 2},
+		db.ToDoItem{Title: "unrelated"},
+	)
+}
+
+func parents(t *testing.T, todo *db.ToDo) map[int]int {
+	items, err := todo.GetAllItems()
+	require.NoError(t, err)
+	byId := make(map[int]int)
+	for _, item := range items {
+		byId[item.Id] = item.ParentId
+	}
+	return byId
+}
+
+func TestParentChecks(t *testing.T) {
+	todo := newTreeDb(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Title: "orphan", ParentId: 99})
+	assert.ErrorIs(t, err, db.ErrBadParent, "Parents must exist")
+
+	err = todo.UpdateItem(db.ToDoItem{Id: 1, Title: "release", ParentId: 1})
+	assert.ErrorIs(t, err, db.ErrBadParent, "An item can't be its own parent")
+	err = todo.UpdateItem(db.ToDoItem{Id: 1, Title: "release", ParentId: 4})
+	assert.ErrorIs(t, err, db.ErrBadParent, "An item can't be under its own subtask")
+
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 4, Title: "proofread", ParentId: 3}))
+	assert.Equal(t, map[int]int{1: 0, 2: 1, 3: 1, 4: 3, 5: 0}, parents(t, todo))
+}
+
+func TestDeleteModes(t *testing.T) {
+	todo := newTreeDb(t)
+
+	err := todo.DeleteItem(1)
+	assert.ErrorIs(t, err, db.ErrHasChildren, "DeleteItem refuses to orphan subtasks")
+	_, err = todo.DeleteItemMode(2, db.DeleteRefuse)
+	assert.ErrorIs(t, err, db.ErrHasChildren)
+	assert.Len(t, parents(t, todo), 5, "Nothing was deleted")
+
+	deleted, err := todo.DeleteItemMode(2, db.DeleteReparent)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, deleted)
+	assert.Equal(t, map[int]int{1: 0, 3: 1, 4: 1, 5: 0}, parents(t, todo), "4 moved up to 2's parent")
+
+	deleted, err = todo.DeleteItemMode(1, db.DeleteCascade)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 3, 4}, deleted)
+	assert.Equal(t, map[int]int{5: 0}, parents(t, todo))
+
+	//A cascade is one write
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	assert.Len(t, parents(t, todo), 4)
+
+	_, err = todo.DeleteItemMode(5, "sideways")
+	assert.Error(t, err)
+}
+
+func TestChangeTreeDoneStatus(t *testing.T) {
+	todo := newTreeDb(t)
+
+	require.NoError(t, todo.ChangeItemDoneStatus(1, true))
+	item, err := todo.GetItem(2)
+	require.NoError(t, err)
+	assert.False(t, item.IsDone, "Plain ChangeItemDoneStatus leaves subtasks alone")
+
+	require.NoError(t, todo.ChangeTreeDoneStatus(1, true))
+	items, err := todo.GetAllItems()
+	require.NoError(t, err)
+	for _, item := range items {
+		assert.Equal(t, item.Id != 5, item.IsDone, "item %d", item.Id)
+	}
+
+	require.NoError(t, todo.ChangeTreeDoneStatus(2, false))
+	for id, want := range map[int]bool{1: true, 2: false, 3: true, 4: false} {
+		item, err := todo.GetItem(id)
+		require.NoError(t, err)
+		assert.Equal(t, want, item.IsDone, "item %d", id)
+	}
+
+	assert.Error(t, todo.ChangeTreeDoneStatus(99, true))
+}
+
+func TestTreeOrder(t *testing.T) {
+	items := []db.ToDoItem{
+		{Id: 5, Title: "unrelated"},
+		{Id: 4, Title: "proofread", ParentId: 2},
+		{Id: 3, Title: "tag build", ParentId: 1},
+		{Id: 2, Title: "write notes", ParentId: 1},
+		{Id: 1, Title: "release"},
+		{Id: 7, Title: "filtered out parent", ParentId: 6},
+		{Id: 8, Title: "loop", ParentId: 9},
+		{Id: 9, Title: "loop", ParentId: 8},
+	}
+
+	ordered, depths := db.TreeOrder(items)
+	var ids []int
+	for _, item := range ordered {
+		ids = append(ids, item.Id)
+	}
+	assert.Equal(t, []int{5, 1, 3, 2, 4, 7, 8, 9}, ids, "Children follow parents, keeping their order")
+	assert.Equal(t, []int{0, 0, 1, 1, 2, 0, 0, 1}, depths)
+
+	var buf strings.Builder
+	require.NoError(t, db.WriteTree(&buf, db.FormatMarkdown, items[:5]))
+	assert.Equal(t, "- [ ] unrelated\n- [ ] release\n  - [ ] tag build\n  - [ ] write notes\n    - [ ] proofread\n",
+		buf.String())
+}
+
+func TestImportKeepsSubtasksWithRenumberedParents(t *testing.T) {
+	todo := newTreeDb(t)
+
+	actions, err := todo.ImportItems([]db.ToDoItem{
+		{Id: 1, Title: "imported parent"},
+		{Id: 10, Title: "imported child", ParentId: 1},
+		{Id: 11, Title: "child of an existing item", ParentId: 5},
+	}, db.CollisionRenumber, false)
+	require.NoError(t, err)
+	require.Len(t, actions, 3)
+	assert.Equal(t, "renumber", actions[0].Action)
+	assert.Equal(t, actions[0].Item.Id, actions[1].Item.ParentId, "The child follows its parent")
+	assert.Equal(t, 5, actions[2].Item.ParentId)
+
+	_, err = todo.ImportItems([]db.ToDoItem{{Title: "orphan", ParentId: 99}}, db.CollisionSkip, false)
+	assert.ErrorIs(t, err, db.ErrBadParent)
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateCommitsOnce(t *testing.T) {
+	todo := newJournalDb(t)
+	_, err := todo.AddItem(db.ToDoItem{Title: "existing"})
+	require.NoError(t, err)
+
+	err = todo.Update(func(tx *db.Tx) error {
+		id, err := tx.AddItem(db.ToDoItem{Title: "parent"})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.AddItem(db.ToDoItem{Title: "child", ParentId: id}); err != nil {
+			return err
+		}
+
+		//Reads see the transaction's own changes
+		item, err := tx.GetItem(id)
+		if err != nil {
+			return err
+		}
+		item.Priority = 3
+		if err := tx.UpdateItem(item); err != nil {
+			return err
+		}
+		if err := tx.ChangeItemDoneStatus(1, true); err != nil {
+			return err
+		}
+		open := false
+		items, err := tx.QueryItems(db.Query{Done: &open})
+		assert.Len(t, items, 2)
+		return err
+	})
+	require.NoError(t, err)
+
+	entries, err := todo.Journal()
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "One entry for the add, one for the whole transaction")
+	assert.Equal(t, "batch", entries[1].Op)
+	assert.Len(t, entries[1].Changes, 3)
+
+	item, err := todo.GetItem(2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, item.Priority)
+
+	_, err = todo.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "existing"}, titles(t, todo), "One undo takes it all back")
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	todo := newJournalDb(t)
+	_, err := todo.AddItem(db.ToDoItem{Title: "existing"})
+	require.NoError(t, err)
+
+	oops := errors.New("oops")
+	err = todo.Update(func(tx *db.Tx) error {
+		if _, err := tx.AddItem(db.ToDoItem{Title: "never saved"}); err != nil {
+			return err
+		}
+		if err := tx.DeleteItem(1); err != nil {
+			return err
+		}
+		return oops
+	})
+	assert.ErrorIs(t, err, oops)
+	assert.Equal(t, map[int]string{1: "existing"}, titles(t, todo))
+
+	//The rolled back changes don't ride along with the next write
+	_, err = todo.AddItem(db.ToDoItem{Title: "later"})
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "existing", 2: "later"}, titles(t, todo))
+}
+
+func TestUpdateFailedChange(t *testing.T) {
+	todo := newJournalDb(t)
+
+	var leaked *db.Tx
+	err := todo.Update(func(tx *db.Tx) error {
+		leaked = tx
+		if _, err := tx.AddItem(db.ToDoItem{Title: "first"}); err != nil {
+			return err
+		}
+
+		//Ignoring the error doesn't save the rest
+		err := tx.UpdateItem(db.ToDoItem{Id: 99, Title: "missing"})
+		assert.Error(t, err)
+		_, err = tx.AddItem(db.ToDoItem{Title: "second"})
+		assert.Error(t, err, "Changes after a failed one fail too")
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Empty(t, titles(t, todo))
+
+	_, err = leaked.AddItem(db.ToDoItem{Title: "too late"})
+	assert.Error(t, err, "A Tx can't be used once its transaction is over")
+	_, err = leaked.GetItem(1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	before := db.DbMap{
+		1: {Id: 1, Title: "same"},
+		2: {Id: 2, Title: "finish"},
+		3: {Id: 3, Title: "rename"},
+		4: {Id: 4, Title: "delete"},
+		5: {Id: 5, Title: "reopen", IsDone: true},
+	}
+	after := db.DbMap{
+		1: {Id: 1, Title: "same"},
+		2: {Id: 2, Title: "finish", IsDone: true, Priority: 1},
+		3: {Id: 3, Title: "renamed", Priority: 2},
+		5: {Id: 5, Title: "reopen"},
+		6: {Id: 6, Title: "add"},
+	}
+
+	events := db.Diff(before, after)
+	kinds := make(map[int]db.EventKind)
+	for _, event := range events {
+		kinds[event.Id] = event.Kind
+	}
+	assert.Equal(t, map[int]db.EventKind{
+		2: db.EventCompleted,
+		3: db.EventUpdated,
+		4: db.EventDeleted,
+		5: db.EventUpdated,
+		6: db.EventAdded,
+	}, kinds)
+	assert.Equal(t, []string{"title", "priority"}, events[1].Fields)
+	assert.Equal(t, "delete", events[2].Item.Title, "A deleted item is as it was")
+	assert.Empty(t, db.Diff(after, after))
+}
+
+func TestWatch(t *testing.T) {
+	dbFile := newTempDbFile(t)
+	watched, err := db.New(dbFile)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan db.Event, 10)
+	done := make(chan error)
+	go func() {
+		done <- watched.Watch(ctx, func(found []db.Event, err error) error {
+			for _, event := range found {
+				events <- event
+			}
+			return err
+		})
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	//Changes come from another ToDo, as they would from another process
+	todo, err := db.New(dbFile)
+	require.NoError(t, err)
+	next := func() db.Event {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no event")
+			return db.Event{}
+		}
+	}
+
+	id, err := todo.AddItem(db.ToDoItem{Title: "watched"})
+	require.NoError(t, err)
+	assert.Equal(t, db.EventAdded, next().Kind)
+
+	require.NoError(t, todo.UpdateItem(db.ToDoItem{Id: id, Title: "watched closely"}))
+	event := next()
+	assert.Equal(t, db.EventUpdated, event.Kind)
+	assert.Equal(t, "watched closely", event.Item.Title)
+
+	require.NoError(t, todo.ChangeItemDoneStatus(id, true))
+	assert.Equal(t, db.EventCompleted, next().Kind)
+
+	require.NoError(t, todo.DeleteItem(id))
+	event = next()
+	assert.Equal(t, db.EventDeleted, event.Kind)
+	assert.Equal(t, id, event.Id)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
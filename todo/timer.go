@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"drexel.edu/todo/db"
+)
+
+func setupStart(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("%w: start takes exactly one id", errUsage)
+		}
+		ids, err := parseIds(args)
+		if err != nil {
+			return err
+		}
+
+		stopped, err := todo.StartTimer(ids[0])
+		if err != nil {
+			return err
+		}
+		if stopped != 0 {
+			if err := reportStopped(todo, stopped); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Started the timer on item", ids[0])
+		return nil
+	}
+}
+
+func setupStop(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("%w: stop takes at most one id", errUsage)
+		}
+		id := 0
+		if len(args) == 1 {
+			ids, err := parseIds(args)
+			if err != nil {
+				return err
+			}
+			id = ids[0]
+		}
+
+		stopped, err := todo.StopTimer(id)
+		if err != nil {
+			return err
+		}
+		return reportStopped(todo, stopped)
+	}
+}
+
+// reportStopped says how long the interval just stopped on item id ran
+func reportStopped(todo *db.ToDo, id int) error {
+	item, err := todo.GetItem(id)
+	if err != nil {
+		return err
+	}
+	last := item.Work[len(item.Work)-1]
+	fmt.Fprintf(os.Stderr, "Stopped the timer on item %d after %s\n", id, formatWork(last.Duration(time.Now())))
+	return nil
+}
+
+// setupReport is the report subcommand, which sums the time worked by
+// item, tag or day, for the days from -from to -to
+func setupReport(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	byFlag := fs.String("by", string(db.ByItem), "What to sum the time by: item, tag or day")
+	fromFlag := fs.String("from", "", "Only count time from the start of this day, YYYY-MM-DD")
+	toFlag := fs.String("to", "", "Only count time up to the end of this day, YYYY-MM-DD")
+	formatFlag := fs.String("format", "table", "How to print the report: table, or csv with the time in hours")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: report takes no arguments", errUsage)
+		}
+		by, err := db.ParseWorkGroup(*byFlag)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if *formatFlag != "table" && *formatFlag != "csv" {
+			return fmt.Errorf("%w: unknown format %q, use table or csv", errUsage, *formatFlag)
+		}
+		from, err := parseDay(*fromFlag)
+		if err != nil {
+			return fmt.Errorf("%w: -from %v", errUsage, err)
+		}
+		to, err := parseDay(*toFlag)
+		if err != nil {
+			return fmt.Errorf("%w: -to %v", errUsage, err)
+		}
+		if !to.IsZero() {
+			to = to.AddDate(0, 0, 1)
+		}
+		if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+			return fmt.Errorf("%w: -from is after -to", errUsage)
+		}
+
+		report, err := todo.Report(by, from, to, time.Local)
+		if err != nil {
+			return err
+		}
+
+		if *formatFlag == "csv" {
+			return writeReportCSV(by, report)
+		}
+		var total time.Duration
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		header := map[db.WorkGroup]string{db.ByItem: "ID\tTITLE\tTIME", db.ByTag: "TAG\tTIME", db.ByDay: "DAY\tTIME"}
+		fmt.Fprintln(tw, header[by])
+		for _, row := range report {
+			total += row.Time
+			switch by {
+			case db.ByItem:
+				fmt.Fprintf(tw, "%d\t%s\t%s\n", row.Id, row.Name, formatWork(row.Time))
+			case db.ByTag:
+				fmt.Fprintf(tw, "%s\t%s\n", tagName(row.Name), formatWork(row.Time))
+			default:
+				fmt.Fprintf(tw, "%s\t%s\n", row.Name, formatWork(row.Time))
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		//An item with several tags is counted once for each
+		if by != db.ByTag {
+			fmt.Fprintln(os.Stderr, "TOTAL TIME", formatWork(total))
+		}
+		return nil
+	}
+}
+
+// writeReportCSV writes a report as CSV, with the time in hours to two
+// decimal places, which is how timesheets want it
+func writeReportCSV(by db.WorkGroup, report []db.WorkTotal) error {
+	w := csv.NewWriter(os.Stdout)
+	header := map[db.WorkGroup][]string{db.ByItem: {"id", "title", "hours"}, db.ByTag: {"tag", "hours"}, db.ByDay: {"day", "hours"}}
+	if err := w.Write(header[by]); err != nil {
+		return err
+	}
+	for _, row := range report {
+		hours := fmt.Sprintf("%.2f", row.Time.Hours())
+		record := []string{row.Name, hours}
+		if by == db.ByItem {
+			record = []string{fmt.Sprint(row.Id), row.Name, hours}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseDay parses a YYYY-MM-DD date as the start of that day in local
+// time, or "" as the zero time
+func parseDay(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date, use YYYY-MM-DD", s)
+	}
+	return day, nil
+}
+
+// formatWork prints time worked as hours and minutes, such as 1:05
+func formatWork(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// tagName is how the report names the total for a tag, "" being the
+// items without one
+func tagName(tag string) string {
+	if tag == "" {
+		return "(none)"
+	}
+	return tag
+}
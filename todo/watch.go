@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"drexel.edu/todo/db"
+)
+
+// setupWatch is the watch subcommand, which prints a line for every
+// item that changes, whoever changes it, until it is interrupted
+func setupWatch(fs *flag.FlagSet) func(*db.ToDo, []string) error {
+	formatFlag := fs.String("format", "text", "How to print the changes: text, or jsonl for one JSON object each")
+
+	return func(todo *db.ToDo, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: watch takes no arguments", errUsage)
+		}
+		if *formatFlag != "text" && *formatFlag != "jsonl" {
+			return fmt.Errorf("%w: unknown format %q, use text or jsonl", errUsage, *formatFlag)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		encoder := json.NewEncoder(os.Stdout)
+		fmt.Fprintln(os.Stderr, "Watching the database for changes, press Ctrl-C to stop")
+		return todo.Watch(ctx, func(events []db.Event, err error) error {
+			//The file may be caught half edited by hand, so carry on,
+			//the next change will be read once it is fixed
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: ", err)
+				return nil
+			}
+
+			now := time.Now()
+			for _, event := range events {
+				if *formatFlag == "jsonl" {
+					err := encoder.Encode(struct {
+						Time time.Time `json:"time"`
+						db.Event
+					}{now, event})
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				fmt.Printf("%s  %s\n", now.Format("2006-01-02 15:04:05"), describeEvent(event))
+			}
+			return nil
+		})
+	}
+}
+
+// describeEvent sums up an event in one line, such as
+//
+//	updated   3 "Buy milk": priority, due
+func describeEvent(event db.Event) string {
+	line := fmt.Sprintf("%-9s %d %q", event.Kind, event.Id, event.Item.Title)
+	if event.Kind == db.EventUpdated {
+		line += ": " + strings.Join(event.Fields, ", ")
+	}
+	return line
+}